@@ -70,3 +70,49 @@ func (t *Transformer) ArrayToArrayIDCT(in [64]int) [64]int {
 	return out
 
 }
+
+// A.3.3 forward DCT, in the same straightforward form as the inverse. Input is level shifted samples in raster order,
+// output is coefficients in the same row = v, column = u layout ArrayToArrayIDCT reads
+func (t *Transformer) ArrayToArrayFDCT(in [64]int) [64]int {
+
+	matrix := [8][8]float64{}
+
+	for i, e := range in {
+		matrix[i/8][i%8] = float64(e)
+	}
+
+	outMatrix := [8][8]float64{}
+
+	for u := 0; u < 8; u++ {
+
+		for v := 0; v < 8; v++ {
+
+			multiplier := 1.0
+
+			if u == 0 {
+				multiplier /= math.Pow(2, 0.5)
+			}
+
+			if v == 0 {
+				multiplier /= math.Pow(2, 0.5)
+			}
+
+			for x := 0; x < 8; x++ {
+				for y := 0; y < 8; y++ {
+
+					outMatrix[v][u] += multiplier * matrix[y][x] * math.Cos(((2*float64(x)+1)*float64(u)*math.Pi)/16) * math.Cos(((2*float64(y)+1)*float64(v)*math.Pi)/16)
+
+				}
+			}
+		}
+	}
+
+	out := [64]int{}
+
+	for i, _ := range out {
+		out[i] = t.Round((outMatrix[i/8][i%8]) * 0.25)
+	}
+
+	return out
+
+}
@@ -0,0 +1,111 @@
+package huffman
+
+import (
+	"errors"
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+// Hands out the bits of a byte slice, most significant first
+type sliceBits struct {
+	data   []byte
+	offset int
+}
+
+func (s *sliceBits) NextBit() (byte, error) {
+	if s.offset >= len(s.data)*8 {
+		return 0, errors.New("no data left")
+	}
+
+	bit := s.data[s.offset/8] >> uint(7-s.offset%8) & 1
+	s.offset++
+
+	return bit, nil
+}
+
+func (s *sliceBits) NextBits(numBits int) int {
+	value := 0
+
+	for i := 0; i < numBits; i++ {
+		bit, err := s.NextBit()
+		if err != nil {
+			panic(err)
+		}
+		value = value<<1 | int(bit)
+	}
+
+	return value
+}
+
+func (s *sliceBits) PrintDebug() {}
+
+// BITS is the first 16 bytes of table and HUFFVAL the rest, as they're laid out in a DHT segment
+func FuzzHuffmanReader(f *testing.F) {
+	// Annex K luminance DC and AC tables with some data
+	f.Add([]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, []byte{0x5a, 0xc3, 0xff, 0x00})
+	f.Add([]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 0x7d, 0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12}, []byte{0x12, 0x34, 0x56, 0x78})
+	// Over-subscribed: three 1 bit codes
+	f.Add([]byte{3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3}, []byte{0xff})
+	// Incomplete: a single 16 bit code, so nearly every bit pattern matches nothing
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0}, []byte{0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, table []byte, data []byte) {
+		if len(table) < 16 {
+			return
+		}
+
+		bits := make(map[int]int)
+		total := 0
+		for i := 1; i <= 16; i++ {
+			bits[i] = int(table[i-1])
+			total += bits[i]
+		}
+
+		// Exactly as many values as BITS counts, the way a DHT segment is read
+		if len(table) < 16+total {
+			return
+		}
+
+		huffVal := make([]int, 0)
+		for _, v := range table[16 : 16+total] {
+			huffVal = append(huffVal, int(v))
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				if err, isRuntime := r.(runtime.Error); isRuntime {
+					t.Fatalf("%v\n%s", err, debug.Stack())
+				}
+			}
+		}()
+
+		h, err := NewHuffmanReader(TARGET_AC, 0, bits, huffVal)
+		if err != nil {
+			return
+		}
+		provider := &sliceBits{data: data}
+
+		// Runs until the data does
+		for {
+			h.DecodeACCoefficients(provider)
+		}
+	})
+}
+
+// testdata/fuzz/FuzzHuffmanReader/7901897db0edbb0a: 48 codes of every length and no values, far more than 16 bits
+// can hold
+func TestFuzzOverSubscribedTable(t *testing.T) {
+	bits := make(map[int]int)
+	for i := 1; i <= 16; i++ {
+		bits[i] = '0'
+	}
+
+	if err := ValidateTable(bits, []int{}); err == nil {
+		t.Fatal("no error")
+	}
+
+	if h, err := NewHuffmanReader(TARGET_AC, 0, bits, []int{}); h != nil || err == nil {
+		t.Errorf("got %v", err)
+	}
+}
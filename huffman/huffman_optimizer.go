@@ -0,0 +1,156 @@
+package huffman
+
+// Annex K.2: building a Huffman table from the symbol frequencies of an image instead of using the example tables.
+// Symbol 256 is reserved with a count of 1 so that no real symbol gets the all ones code
+
+const reservedSymbol = 256
+
+// A HuffmanWriter that only counts the symbols it's asked to encode. Running the encoder once with these gives the
+// frequencies for OptimalTable
+func NewFrequencyCounter(target int, identifier int) *HuffmanWriter {
+	return &HuffmanWriter{
+		Target:      target,
+		Identifier:  identifier,
+		Frequencies: make(map[int]int),
+	}
+}
+
+// Throws the bits away. The counting pass only cares about symbols
+type DiscardBitWriter struct{}
+
+func (d DiscardBitWriter) WriteBits(bits int, numBits int) {}
+
+// Figures K.1 to K.4. Returns BITS, keyed 1 to 16 like the rest of this package, and HUFFVAL
+func OptimalTable(frequencies map[int]int) (map[int]int, []int) {
+	freq := make([]int, 257)
+	for symbol, count := range frequencies {
+		freq[symbol] = count
+	}
+	freq[reservedSymbol] = 1
+
+	codeSize := generateCodeSizes(freq)
+
+	bits := countBits(codeSize)
+
+	adjustBits(bits)
+
+	return bitsToMap(bits), sortInput(codeSize)
+}
+
+// Figure K.1
+func generateCodeSizes(freq []int) []int {
+	codeSize := make([]int, len(freq))
+	others := make([]int, len(freq))
+
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		// The two least frequent symbols still in play. Ties go to the larger symbol value
+		v1 := -1
+		v2 := -1
+
+		for i, f := range freq {
+			if f == 0 {
+				continue
+			}
+
+			if v1 == -1 || f <= freq[v1] {
+				v2 = v1
+				v1 = i
+			} else if v2 == -1 || f <= freq[v2] {
+				v2 = i
+			}
+		}
+
+		if v2 == -1 {
+			break
+		}
+
+		freq[v1] += freq[v2]
+		freq[v2] = 0
+
+		codeSize[v1]++
+		for others[v1] != -1 {
+			v1 = others[v1]
+			codeSize[v1]++
+		}
+
+		others[v1] = v2
+
+		codeSize[v2]++
+		for others[v2] != -1 {
+			v2 = others[v2]
+			codeSize[v2]++
+		}
+	}
+
+	return codeSize
+}
+
+// Figure K.2. Code lengths can reach 32 before adjusting
+func countBits(codeSize []int) []int {
+	bits := make([]int, 33)
+
+	for _, size := range codeSize {
+		if size != 0 {
+			bits[size]++
+		}
+	}
+
+	return bits
+}
+
+// Figure K.3. Moves codes longer than 16 bits up the tree, then drops the reserved code point
+func adjustBits(bits []int) {
+	i := 32
+
+	for i > 16 {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+
+		i--
+	}
+
+	for bits[i] == 0 {
+		i--
+	}
+
+	bits[i]--
+}
+
+// Figure K.4. Symbols in order of code length, which is how HUFFVAL lists them. The reserved symbol always has one
+// of the longest codes so it's the last one and gets left off
+func sortInput(codeSize []int) []int {
+	huffVal := make([]int, 0)
+
+	for i := 1; i <= 32; i++ {
+		for symbol := 0; symbol < reservedSymbol; symbol++ {
+			if codeSize[symbol] == i {
+				huffVal = append(huffVal, symbol)
+			}
+		}
+	}
+
+	return huffVal
+}
+
+func bitsToMap(bits []int) map[int]int {
+	m := make(map[int]int)
+
+	for i := 1; i <= 16; i++ {
+		m[i] = bits[i]
+	}
+
+	return m
+}
@@ -3,6 +3,7 @@ package huffman
 import (
 	//"fmt"
 	//"github.com/davecgh/go-spew/spew"
+	"errors"
 	"math"
 )
 
@@ -29,8 +30,13 @@ type HuffmanReader struct {
 	Bits       map[int]int
 }
 
-// I think move NextBitProvider into initializer since it's integral to this class
-func NewHuffmanReader(target int, identifier int, bits map[int]int, huffVal []int) *HuffmanReader {
+// I think move NextBitProvider into initializer since it's integral to this class. Returns an error if the table
+// can't be a Huffman code, see ValidateTable
+func NewHuffmanReader(target int, identifier int, bits map[int]int, huffVal []int) (*HuffmanReader, error) {
+	if err := ValidateTable(bits, huffVal); err != nil {
+		return nil, err
+	}
+
 	huff := &HuffmanReader{Bits: bits,
 		HuffVal:    huffVal,
 		Identifier: identifier,
@@ -43,7 +49,45 @@ func NewHuffmanReader(target int, identifier int, bits map[int]int, huffVal []in
 	huff.generateSizeTable()
 	huff.generateHuffCode()
 	huff.generateDecodeTables()
-	return huff
+	return huff, nil
+}
+
+// Checks BITS and HUFFVAL describe a code that can be built (C.2). There can be at most 256 symbols, one for each
+// value a byte can hold, and there can't be more codes of a length than the shorter codes leave room for, which is
+// the Kraft inequality. Filling the room exactly is rejected too, since the last code assigned is then all ones,
+// which the spec keeps free (C.2) and libjpeg's jpeg_make_d_derived_tbl refuses. Codes that leave room over are
+// fine. A bit pattern that matches nothing fails when it's decoded
+func ValidateTable(bits map[int]int, huffVal []int) error {
+	total := 0
+	// Codes of the current length still free, counted as if every code was 16 bits long
+	room := 1 << 16
+
+	for i := 1; i <= 16; i++ {
+		if bits[i] < 0 {
+			return errors.New("negative count in huffman BITS")
+		}
+
+		total += bits[i]
+		room -= bits[i] << uint(16-i)
+
+		if room < 0 {
+			return errors.New("huffman code lengths are over-subscribed")
+		}
+	}
+
+	if total > 0 && room == 0 {
+		return errors.New("huffman code lengths assign the all ones code")
+	}
+
+	if total > 256 {
+		return errors.New("huffman table has more than 256 symbols")
+	}
+
+	if total != len(huffVal) {
+		return errors.New("huffman BITS and HUFFVAL don't agree on the number of symbols")
+	}
+
+	return nil
 }
 
 // From figure C.1
@@ -160,6 +204,12 @@ func (h *HuffmanReader) Decode(provider NextBitProvider) int {
 
 	for code > h.MaxCode[i] {
 		i += 1
+
+		// No code is longer than 16 bits (C.2). Bits that don't match anything are corrupt data or a bad table
+		if i > 16 {
+			panic("huffman code longer than 16 bits")
+		}
+
 		code <<= 1
 		nextBit, err := provider.NextBit()
 		if err != nil {
@@ -172,6 +222,11 @@ func (h *HuffmanReader) Decode(provider NextBitProvider) int {
 
 	j += code - h.MinCode[i]
 
+	// The table's BITS count more codes than HUFFVAL has values for
+	if j < 0 || j >= len(h.HuffVal) {
+		panic("huffman code has no value")
+	}
+
 	ret := h.HuffVal[j]
 
 	return ret
@@ -225,6 +280,10 @@ func (h *HuffmanReader) DecodeACCoefficients(provider NextBitProvider) [64]int {
 		} else {
 			k += r
 
+			if k > 63 {
+				panic("run of zeros goes past the end of the block")
+			}
+
 			zz[k] = h.DecodeZZ(provider, ssss)
 
 			if k == 63 {
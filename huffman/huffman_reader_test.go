@@ -0,0 +1,46 @@
+package huffman
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestValidateTable(t *testing.T) {
+	cases := []struct {
+		bits    map[int]int
+		symbols int
+		valid   bool
+	}{
+		{map[int]int{}, 0, true},
+		// 0 and 10, with 11 left over
+		{map[int]int{1: 1, 2: 1}, 2, true},
+		{map[int]int{2: 3}, 3, true},
+		// 0 and 1. The second is the all ones code
+		{map[int]int{1: 2}, 2, false},
+		// 0, 10 and 11
+		{map[int]int{1: 1, 2: 2}, 3, false},
+		{map[int]int{16: 1 << 16}, 1 << 16, false},
+		{map[int]int{1: 3}, 3, false},
+		{map[int]int{2: 2}, 3, false},
+		{map[int]int{2: -1}, -1, false},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprint(c.bits), func(t *testing.T) {
+			huffVal := make([]int, 0)
+			for i := 0; i < c.symbols; i++ {
+				huffVal = append(huffVal, i%256)
+			}
+
+			err := ValidateTable(c.bits, huffVal)
+			if (err == nil) != c.valid {
+				t.Fatalf("got %v", err)
+			}
+
+			h, err := NewHuffmanReader(TARGET_AC, 0, c.bits, huffVal)
+			if (err == nil) != c.valid || (h != nil) != c.valid {
+				t.Errorf("NewHuffmanReader gave %v", err)
+			}
+		})
+	}
+}
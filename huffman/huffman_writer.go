@@ -0,0 +1,148 @@
+package huffman
+
+// The encoding side of HuffmanReader. Built from the same BITS and HUFFVAL lists that end up in a DHT segment
+
+type BitWriter interface {
+	WriteBits(bits int, numBits int)
+}
+
+// Natural (row major) position of each coefficient in zig-zag order, figure A.6. DeZigZag walks this path by hand
+var ZigZagOrder = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+type HuffmanWriter struct {
+	Target     int
+	Identifier int
+	Bits       map[int]int
+	HuffVal    []int
+	// Code and code length for each symbol, EHUFCO and EHUFSI in the spec
+	EHufCo map[int]int
+	EHufSi map[int]int
+	// Only set on frequency counters. Encode tallies symbols here instead of writing them
+	Frequencies map[int]int
+}
+
+func NewHuffmanWriter(target int, identifier int, bits map[int]int, huffVal []int) *HuffmanWriter {
+	h := &HuffmanWriter{
+		Target:     target,
+		Identifier: identifier,
+		Bits:       bits,
+		HuffVal:    huffVal,
+		EHufCo:     make(map[int]int),
+		EHufSi:     make(map[int]int),
+	}
+
+	// HUFFSIZE and HUFFCODE come out of figures C.1 and C.2 just as they do for decoding. The tables come from
+	// Annex K or the optimizer rather than a file, so a bad one is a bug
+	reader, err := NewHuffmanReader(target, identifier, bits, huffVal)
+	if err != nil {
+		panic(err)
+	}
+
+	h.generateEncodeTables(reader)
+
+	return h
+}
+
+// Figure C.3
+func (h *HuffmanWriter) generateEncodeTables(reader *HuffmanReader) {
+	for k, value := range h.HuffVal {
+		h.EHufCo[value] = reader.HuffCode[k]
+		h.EHufSi[value] = reader.HuffSize[k]
+	}
+}
+
+func (h *HuffmanWriter) Encode(writer BitWriter, value int) {
+	if h.Frequencies != nil {
+		h.Frequencies[value]++
+		return
+	}
+
+	size, present := h.EHufSi[value]
+	if !present {
+		panic("no huffman code for symbol")
+	}
+
+	writer.WriteBits(h.EHufCo[value], size)
+}
+
+// SSSS from tables F.1 and F.2: the number of bits needed for the magnitude
+func Category(v int) int {
+	if v < 0 {
+		v = -v
+	}
+
+	ssss := 0
+	for v > 0 {
+		ssss++
+		v >>= 1
+	}
+
+	return ssss
+}
+
+// The inverse of ExtendVal. Negative values are sent as v - 1 in ssss bits (F.1.2.1)
+func (h *HuffmanWriter) EncodeZZ(writer BitWriter, v int, ssss int) {
+	if v < 0 {
+		v -= 1
+	}
+
+	writer.WriteBits(v&((1<<uint(ssss))-1), ssss)
+}
+
+// F.1.2.1. Returns the new prediction, mirroring DecodeDC
+func (h *HuffmanWriter) EncodeDC(writer BitWriter, dc int, prev int) int {
+	diff := dc - prev
+	ssss := Category(diff)
+
+	h.Encode(writer, ssss)
+	h.EncodeZZ(writer, diff, ssss)
+
+	return dc
+}
+
+// Figures F.2 and F.3. zz is in zig-zag order and position 0 is ignored
+func (h *HuffmanWriter) EncodeACCoefficients(writer BitWriter, zz [64]int) {
+	r := 0
+
+	for k := 1; k < 64; k++ {
+		if zz[k] == 0 {
+			r++
+			continue
+		}
+
+		for r > 15 {
+			h.Encode(writer, 0xF0) // ZRL
+			r -= 16
+		}
+
+		ssss := Category(zz[k])
+
+		h.Encode(writer, r<<4|ssss)
+		h.EncodeZZ(writer, zz[k], ssss)
+
+		r = 0
+	}
+
+	if r > 0 {
+		h.Encode(writer, 0x00) // EOB
+	}
+}
+
+func ZigZag(natural [64]int) [64]int {
+	out := [64]int{}
+
+	for k, i := range ZigZagOrder {
+		out[k] = natural[i]
+	}
+
+	return out
+}
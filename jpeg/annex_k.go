@@ -0,0 +1,144 @@
+package jpeg
+
+import (
+	"huffman"
+)
+
+// Example tables from Annex K of the spec. Nearly every encoder in the wild uses these, with the quantization tables
+// scaled by the IJG quality formula
+
+// Table K.1, natural (row major) order
+var AnnexKLuminanceQuantization = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// Table K.2, natural (row major) order
+var AnnexKChrominanceQuantization = [64]int{
+	17, 18, 24, 47, 99, 99, 99, 99,
+	18, 21, 26, 66, 99, 99, 99, 99,
+	24, 26, 56, 99, 99, 99, 99, 99,
+	47, 66, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+}
+
+// Table K.3
+var AnnexKLuminanceDCBits = [16]int{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0}
+var AnnexKLuminanceDCValues = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+
+// Table K.4
+var AnnexKChrominanceDCBits = [16]int{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0}
+var AnnexKChrominanceDCValues = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+
+// Table K.5
+var AnnexKLuminanceACBits = [16]int{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 0x7d}
+var AnnexKLuminanceACValues = []int{
+	0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+	0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+	0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+	0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+	0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+	0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+	0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+	0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+	0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+	0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+	0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+	0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+	0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+	0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+	0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+	0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+	0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+	0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+	0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+	0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+	0xf9, 0xfa,
+}
+
+// Table K.6
+var AnnexKChrominanceACBits = [16]int{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 0x77}
+var AnnexKChrominanceACValues = []int{
+	0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+	0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+	0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+	0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+	0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+	0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+	0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+	0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+	0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+	0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+	0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+	0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+	0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+	0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+	0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+	0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+	0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+	0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+	0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+	0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+	0xf9, 0xfa,
+}
+
+// The IJG quality scaling (jcparam.c). 50 gives the tables as printed, 100 gives all ones. The result is in zig-zag
+// order, the way DQT stores it and QuantizationTables holds it. Quality outside 1 to 100 is clamped like
+// jpeg_quality_scaling does, so callers taking it from a user check the range first
+func ScaleQuantizationTable(natural [64]int, quality int) [64]int {
+	if quality < 1 {
+		quality = 1
+	} else if quality > 100 {
+		quality = 100
+	}
+
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+
+	scaled := [64]int{}
+
+	for i, e := range natural {
+		q := (e*scale + 50) / 100
+
+		if q < 1 {
+			q = 1
+		} else if q > 255 {
+			q = 255
+		}
+
+		scaled[i] = q
+	}
+
+	return huffman.ZigZag(scaled)
+}
+
+// BITS as the map HuffmanReader and HuffmanWriter take, keyed 1 to 16
+func bitsMap(bits [16]int) map[int]int {
+	m := make(map[int]int)
+
+	for i, e := range bits {
+		m[i+1] = e
+	}
+
+	return m
+}
+
+func AnnexKHuffmanWriters() []*huffman.HuffmanWriter {
+	return []*huffman.HuffmanWriter{
+		huffman.NewHuffmanWriter(huffman.TARGET_DC, 0, bitsMap(AnnexKLuminanceDCBits), AnnexKLuminanceDCValues),
+		huffman.NewHuffmanWriter(huffman.TARGET_AC, 0, bitsMap(AnnexKLuminanceACBits), AnnexKLuminanceACValues),
+		huffman.NewHuffmanWriter(huffman.TARGET_DC, 1, bitsMap(AnnexKChrominanceDCBits), AnnexKChrominanceDCValues),
+		huffman.NewHuffmanWriter(huffman.TARGET_AC, 1, bitsMap(AnnexKChrominanceACBits), AnnexKChrominanceACValues),
+	}
+}
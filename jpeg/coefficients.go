@@ -0,0 +1,226 @@
+package jpeg
+
+import (
+	"bytes"
+	"errors"
+	"huffman"
+)
+
+// The entropy decoded image before dequantization. Everything that works on these, and writes them back out with
+// the same quantization tables, is lossless
+
+// Quantized coefficients for one component
+type CoefficientPlane struct {
+	Component *Component
+	// Blocks per line and per column. Interleaved frames are padded out to whole MCUs
+	BlockCols int
+	BlockRows int
+	// Zig-zag order, one [64]int per block in raster order
+	Blocks [][64]int
+}
+
+func (p *CoefficientPlane) Block(row int, col int) [64]int {
+	return p.Blocks[row*p.BlockCols+col]
+}
+
+type Coefficients struct {
+	Frame
+	// Zig-zag order, keyed by table id, the same as JpegParser.QuantizationTables
+	QuantizationTables map[int][64]int
+	// One per frame component, in frame order
+	Planes []*CoefficientPlane
+	// The source file's APPn and COM segments, in file order, for the encoder to carry over
+	Segments []*Section
+}
+
+// Runs the entropy decoder over every scan and stops there
+func (j *JpegParser) DecodeCoefficients() (*Coefficients, error) {
+	if len(j.Components) != 1 && len(j.Components) != 3 {
+		return nil, errors.New("only grayscale and YCbCr images are supported")
+	}
+
+	c := &Coefficients{QuantizationTables: make(map[int][64]int)}
+
+	c.XLines = j.XLines
+	c.YLines = j.YLines
+
+	for _, component := range j.Components {
+		copied := *component
+		c.Components = append(c.Components, &copied)
+	}
+
+	c.ScanComponents = c.Components
+
+	for id, table := range j.QuantizationTables {
+		c.QuantizationTables[id] = table
+	}
+
+	c.Segments = carriedSegments(j.Segments)
+
+	planes, decodeErr := j.decodeCoefficientPlanes(&DecodeOptions{})
+
+	for i, p := range planes {
+		c.Planes = append(c.Planes, &CoefficientPlane{
+			Component: c.Components[i],
+			BlockCols: p.blockCols,
+			BlockRows: p.blockRows,
+			Blocks:    p.coefficients,
+		})
+	}
+
+	// A truncated file still gives back what it has, with a *TruncatedError
+	return c, decodeErr
+}
+
+// Sets up an encoder that writes the coefficients as they are, along with the source file's segments unless
+// opts.DropMetadata is set. Quality and Subsampling in opts don't apply since the quantization and sampling come
+// from the coefficients
+func NewEncoderFromCoefficients(c *Coefficients, opts *EncodeOptions) (*Encoder, error) {
+	if opts.RestartInterval < 0 || opts.RestartInterval > 65535 {
+		return nil, errors.New("restart interval must be between 0 and 65535")
+	}
+
+	if len(c.Components) != 1 && len(c.Components) != 3 {
+		return nil, errors.New("only grayscale and YCbCr images are supported")
+	}
+
+	e := &Encoder{
+		QuantizationTables: make(map[int][64]int),
+		HuffmanWriters:     AnnexKHuffmanWriters(),
+		RestartInterval:    opts.RestartInterval,
+	}
+
+	if !opts.DropMetadata {
+		e.Segments = c.Segments
+	}
+
+	e.XLines = c.XLines
+	e.YLines = c.YLines
+
+	for i, component := range c.Components {
+		copied := *component
+
+		// The Annex K tables are luminance for the first component and chrominance for the rest
+		copied.Td, copied.Ta = 0, 0
+		if i > 0 {
+			copied.Td, copied.Ta = 1, 1
+		}
+
+		e.Components = append(e.Components, &copied)
+
+		table, present := c.QuantizationTables[component.Tq]
+		if !present {
+			return nil, errors.New("component uses a quantization table that isn't there")
+		}
+
+		e.QuantizationTables[component.Tq] = table
+	}
+
+	e.ScanComponents = e.Components
+
+	// The plane layout has to be the one the encoder expects for this frame
+	e.planes = e.newComponentPlanes()
+
+	for i, p := range e.planes {
+		src := c.Planes[i]
+
+		if p.blockCols != src.BlockCols || p.blockRows != src.BlockRows {
+			return nil, errors.New("coefficient plane doesn't match the frame")
+		}
+
+		copy(p.coefficients, src.Blocks)
+	}
+
+	if err := e.applyScanOptions(opts); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// A copy with planes laid out for frame, each block taken from the original by fn. fn gets the component index and
+// the block's position in the new plane
+func (c *Coefficients) remap(frame Frame, tables map[int][64]int, fn func(ci int, row int, col int) [64]int) *Coefficients {
+	out := &Coefficients{Frame: frame, QuantizationTables: tables, Segments: c.Segments}
+	out.ScanComponents = out.Components
+
+	for ci, p := range out.newComponentPlanes() {
+		plane := &CoefficientPlane{
+			Component: out.Components[ci],
+			BlockCols: p.blockCols,
+			BlockRows: p.blockRows,
+			Blocks:    p.coefficients,
+		}
+
+		for row := 0; row < plane.BlockRows; row++ {
+			for col := 0; col < plane.BlockCols; col++ {
+				plane.Blocks[row*plane.BlockCols+col] = fn(ci, row, col)
+			}
+		}
+
+		out.Planes = append(out.Planes, plane)
+	}
+
+	return out
+}
+
+// The block at row, col of a component, or all zeros outside the plane. Only padding ever lands out there
+// The segments a rewrite keeps, like jpegtran -copy all. The encoder writes its own JFIF segment, and an MPF index
+// would point at images that aren't written
+func carriedSegments(segments []*Section) []*Section {
+	carried := make([]*Section, 0)
+
+	for _, s := range segments {
+		switch {
+		case !s.IsApp() && s.Type != MARKER_COM:
+		case s.Type == MARKER_JFIF && bytes.HasPrefix(s.Body, jfifIdentifier):
+		case s.Type == MARKER_APP2 && IsMpf(s.Body):
+		default:
+			carried = append(carried, s)
+		}
+	}
+
+	return carried
+}
+
+func (c *Coefficients) blockOrZero(ci int, row int, col int) [64]int {
+	p := c.Planes[ci]
+
+	if row < 0 || col < 0 || row >= p.BlockRows || col >= p.BlockCols {
+		return [64]int{}
+	}
+
+	return p.Block(row, col)
+}
+
+func (c *Coefficients) copyFrame() Frame {
+	f := Frame{XLines: c.XLines, YLines: c.YLines}
+
+	for _, component := range c.Components {
+		copied := *component
+		f.Components = append(f.Components, &copied)
+	}
+
+	return f
+}
+
+func copyTables(tables map[int][64]int) map[int][64]int {
+	out := make(map[int][64]int)
+
+	for id, table := range tables {
+		out[id] = table
+	}
+
+	return out
+}
+
+// Zig-zag to natural order and back
+func naturalOrder(zz [64]int) [64]int {
+	out := [64]int{}
+
+	for k, i := range huffman.ZigZagOrder {
+		out[i] = zz[k]
+	}
+
+	return out
+}
@@ -0,0 +1,16 @@
+package jpeg
+
+// Frame component specification from B.2.2. The table selectors from the scan header (B.2.3) are stored
+// alongside since the decoder needs both together
+type Component struct {
+	Id int
+	H  int // horizontal sampling factor
+	V  int // vertical sampling factor
+	Tq int // quantization table
+	Td int // DC huffman table, from the scan header
+	Ta int // AC huffman table, from the scan header
+}
+
+func NewComponent(id int, h int, v int, tq int) *Component {
+	return &Component{Id: id, H: h, V: v, Tq: tq}
+}
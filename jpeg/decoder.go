@@ -0,0 +1,351 @@
+package jpeg
+
+import (
+	"errors"
+	"image"
+	"image/color"
+
+	"dct"
+	"huffman"
+)
+
+// Decoded samples for one component. The plane covers every block the scan codes, so in an interleaved scan it
+// runs past the image edge out to the last whole MCU
+type componentPlane struct {
+	component *Component
+	// Blocks per line and per column in the plane
+	blockCols int
+	blockRows int
+	// Quantized coefficients in zig-zag order, one [64]int per block in raster order
+	coefficients [][64]int
+	samples      []int
+}
+
+func newComponentPlane(c *Component, blockCols int, blockRows int) *componentPlane {
+	return &componentPlane{
+		component:    c,
+		blockCols:    blockCols,
+		blockRows:    blockRows,
+		coefficients: make([][64]int, blockCols*blockRows),
+	}
+}
+
+func (p *componentPlane) stride() int {
+	return p.blockCols * 8
+}
+
+type DecodeOptions struct {
+	// Stretch the output so pixels are square when the JFIF densities say they aren't. Off by default since
+	// the output no longer lines up 1:1 with the coded samples
+	ApplyAspectRatio bool
+	// Fill in intervals that can't be decoded instead of panicking. The image still comes back, with a
+	// *CorruptDataError saying which MCUs were filled
+	Tolerant bool
+	// FILL_GRAY or FILL_COPY_ABOVE
+	Fill   int
+	Limits DecodeLimits
+}
+
+// Decode runs the whole pipeline: entropy decoding, dequantization, IDCT and color conversion. Only Huffman coded
+// frames with one (grayscale) or three (YCbCr) components are handled
+func (j *JpegParser) Decode() (*image.RGBA, error) {
+	return j.DecodeWithOptions(&DecodeOptions{})
+}
+
+func (j *JpegParser) DecodeWithOptions(opts *DecodeOptions) (*image.RGBA, error) {
+	img, _, err := j.decode(opts)
+	if img == nil {
+		return nil, err
+	}
+
+	if opts.ApplyAspectRatio && j.JFIF != nil {
+		img = applyAspectRatio(img, j.JFIF)
+	}
+
+	// The image is still worth having when parts of it are missing
+	return img, err
+}
+
+// DecodeWithOptions short of the aspect ratio, also returning the planes
+func (j *JpegParser) decode(opts *DecodeOptions) (*image.RGBA, []*componentPlane, error) {
+	if len(j.Components) != 1 && len(j.Components) != 3 {
+		return nil, nil, errors.New("only grayscale and YCbCr images are supported")
+	}
+
+	if err := j.checkLimits(&opts.Limits); err != nil {
+		return nil, nil, err
+	}
+
+	planes, decodeErr := j.decodeCoefficientPlanes(opts)
+
+	for _, p := range planes {
+		j.reconstructPlane(p)
+	}
+
+	return j.planesToImage(planes), planes, decodeErr
+}
+
+// Entropy decodes every scan. The planes come back holding quantized coefficients and no samples. The error is a
+// *TruncatedError or a *CorruptDataError when some of the planes had to be left empty or filled in
+func (j *JpegParser) decodeCoefficientPlanes(opts *DecodeOptions) ([]*componentPlane, error) {
+	planes := j.newComponentPlanes()
+	damaged := make([]MCURange, 0)
+
+	// Baseline files can also split components over several scans. Either way every scan adds to the same planes
+	for si, scan := range j.Scans {
+		j.ActivateScan(scan)
+
+		// A file that ends early ends somewhere in its last scan
+		if j.Truncated && si == len(j.Scans)-1 {
+			return planes, j.decodeTruncatedScan(scan, planes)
+		}
+
+		for _, interval := range j.Intervals {
+			// Intervals remember how far they've been read, so start over in case this isn't the first decode
+			interval.Reset()
+
+			if opts.Tolerant {
+				if j.decodeIntervalTolerant(scan, interval, planes, opts.Fill) {
+					damaged = append(damaged, MCURange{Scan: si, First: interval.MCUOffset, Count: interval.MCUs})
+				}
+				continue
+			}
+
+			if interval.Damaged {
+				panic("Found a marker in the frame body that we don't expect")
+			}
+
+			if j.Progressive {
+				j.decodeProgressiveInterval(scan, interval, planes)
+			} else {
+				j.decodeInterval(interval, planes)
+			}
+		}
+	}
+
+	if len(damaged) > 0 {
+		return planes, &CorruptDataError{Damaged: damaged}
+	}
+
+	return planes, nil
+}
+
+func (f *Frame) newComponentPlanes() []*componentPlane {
+	planes := make([]*componentPlane, len(f.Components))
+
+	for i, size := range f.planeSizes() {
+		planes[i] = newComponentPlane(f.Components[i], size[0], size[1])
+	}
+
+	return planes
+}
+
+// Blocks across and down the plane of each component
+func (f *Frame) planeSizes() [][2]int {
+	sizes := make([][2]int, len(f.Components))
+
+	for i, c := range f.Components {
+		cols, rows := f.ComponentBlocks(c)
+
+		// Interleaved scans code whole MCUs so round up to the MCU grid. Any scan of a multi component frame
+		// may be interleaved
+		if len(f.Components) > 1 {
+			cols = f.MCUCols() * c.H
+			rows = f.MCURows() * c.V
+		}
+
+		sizes[i] = [2]int{cols, rows}
+	}
+
+	return sizes
+}
+
+func planeFor(planes []*componentPlane, c *Component) *componentPlane {
+	for _, p := range planes {
+		if p.component == c {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Entropy decode a single block: the DC difference from figure F.12 and the AC coefficients from figure F.13. Returns
+// the quantized coefficients in zig-zag order and the new DC prediction. index is the block's place in the
+// component's plane, for counting bits
+func (j *JpegParser) decodeBlock(interval *Interval, c *Component, index int, previousDC int) ([64]int, int) {
+
+	array := [64]int{}
+
+	dcReader := j.GetHuffmanReader(huffman.TARGET_DC, c.Td)
+	acReader := j.GetHuffmanReader(huffman.TARGET_AC, c.Ta)
+
+	start := interval.BitsRead()
+
+	dc := dcReader.DecodeDC(interval, previousDC)
+
+	array[0] = dc
+
+	dcBits := interval.BitsRead() - start
+
+	zigZag := acReader.DecodeACCoefficients(interval)
+
+	j.bitCounter.add(c, index, dcBits, interval.BitsRead()-start-dcBits)
+
+	for i := 1; i < 64; i++ {
+		array[i] = zigZag[i]
+	}
+
+	return array, dc
+}
+
+// Calls fn for every block of one MCU of the scan, in coding order. ci is the component's index in the scan and
+// index is the block's position in the plane
+func (f *Frame) forEachMCUBlock(planes []*componentPlane, thisMCU int, fn func(ci int, p *componentPlane, index int)) {
+
+	// Non interleaved. The MCU is one block and they go left to right across the component
+	if len(f.ScanComponents) == 1 {
+		p := planeFor(planes, f.ScanComponents[0])
+
+		cols, _ := f.ComponentBlocks(p.component)
+		row := thisMCU / cols
+		col := thisMCU % cols
+
+		fn(0, p, row*p.blockCols+col)
+		return
+	}
+
+	// Need to rebuild col and row here
+	mcuCol := thisMCU % f.MCUCols()
+	mcuRow := thisMCU / f.MCUCols()
+
+	for ci, c := range f.ScanComponents {
+		p := planeFor(planes, c)
+
+		// Each component contributes H x V blocks to the MCU, left to right then top to bottom (A.2.3)
+		for v := 0; v < c.V; v++ {
+			for h := 0; h < c.H; h++ {
+				row := mcuRow*c.V + v
+				col := mcuCol*c.H + h
+
+				fn(ci, p, row*p.blockCols+col)
+			}
+		}
+	}
+}
+
+func (j *JpegParser) decodeInterval(interval *Interval, planes []*componentPlane) {
+
+	// Always zero at start of an interval
+	predictions := make([]int, len(j.ScanComponents))
+
+	for i := 0; i < interval.MCUs; i++ {
+		j.forEachMCUBlock(planes, interval.MCUOffset+i, func(ci int, p *componentPlane, index int) {
+			if t := j.tracer.block(p.component, index); t != nil {
+				p.coefficients[index], predictions[ci] = j.traceBlock(interval, p.component, predictions[ci], t)
+				return
+			}
+
+			p.coefficients[index], predictions[ci] = j.decodeBlock(interval, p.component, index, predictions[ci])
+		})
+
+		interval.decodedMCUs = i + 1
+	}
+
+}
+
+// Dequantize, de-zig-zag, IDCT, then recenter and clamp every block of the plane into samples
+func (j *JpegParser) reconstructPlane(p *componentPlane) {
+	p.samples = make([]int, p.blockCols*p.blockRows*64)
+
+	table := j.QuantizationTables[p.component.Tq]
+
+	// DeZigZag doesn't use any state on the reader but it lives there
+	reader := &huffman.HuffmanReader{}
+	dctTransformer := dct.NewTransformer()
+
+	for b, coefficients := range p.coefficients {
+		_, _, array := reconstructBlock(coefficients, table, reader, dctTransformer)
+
+		blockRow := b / p.blockCols
+		blockCol := b % p.blockCols
+
+		for i, e := range array {
+			y := blockRow*8 + i/8
+			x := blockCol*8 + i%8
+
+			p.samples[y*p.stride()+x] = intClamp(e + 128)
+		}
+	}
+}
+
+// One block of reconstructPlane, returning every stage: the dequantized coefficients in zig-zag order, then in
+// natural order, then the IDCT output, still centred on 0
+func reconstructBlock(coefficients [64]int, table [64]int, reader *huffman.HuffmanReader, dctTransformer *dct.Transformer) ([64]int, [64]int, [64]int) {
+	dequantized := coefficients
+
+	for i := 0; i < 64; i++ {
+		dequantized[i] *= table[i]
+	}
+
+	// DeZigZag leaves the DC out
+	natural := dequantized
+	straightened := reader.DeZigZag(dequantized)
+	for i := 1; i < 64; i++ {
+		natural[i] = straightened[i]
+	}
+
+	return dequantized, natural, dctTransformer.ArrayToArrayIDCT(natural)
+}
+
+func intClamp(val int) int {
+	if val > 255 {
+		val = 255
+	} else if val < 0 {
+		val = 0
+	}
+	return val
+}
+
+func (j *JpegParser) planesToImage(planes []*componentPlane) *image.RGBA {
+	clrImg := image.NewRGBA(image.Rect(0, 0, j.XLines, j.YLines))
+
+	hMax, vMax := j.MaxSampling()
+
+	// Subsampled components cover more than one output pixel each. This is the same 1:2 in each dimension
+	// basis as before, just worked out from the sampling factors instead of fixed
+	sample := func(p *componentPlane, x int, y int) float64 {
+		sx := x * p.component.H / hMax
+		sy := y * p.component.V / vMax
+		return float64(p.samples[sy*p.stride()+sx])
+	}
+
+	for y := 0; y < j.YLines; y++ {
+		for x := 0; x < j.XLines; x++ {
+			luma := sample(planes[0], x, y)
+
+			if len(planes) == 1 {
+				g := uint8(intClamp(int(luma)))
+				clrImg.Set(x, y, color.RGBA{g, g, g, 255})
+				continue
+			}
+
+			cb := sample(planes[1], x, y)
+			cr := sample(planes[2], x, y)
+
+			clrImg.Set(x, y, yCbCrToRGBA(luma, cb, cr))
+		}
+	}
+
+	return clrImg
+}
+
+func yCbCrToRGBA(luma float64, cb float64, cr float64) color.RGBA {
+	// This was a problem since it's not in the itu 81 spec. link to JFIF spec
+	// https://www.w3.org/Graphics/JPEG/jfif3.pdf
+	r := luma + 1.402*(cr-128)
+	g := luma - 0.34414*(cb-128) - 0.71414*(cr-128)
+	b := luma + 1.772*(cb-128)
+
+	return color.RGBA{uint8(intClamp(int(r))), uint8(intClamp(int(g))), uint8(intClamp(int(b))), 255}
+}
@@ -0,0 +1,530 @@
+package jpeg
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"io"
+	"sort"
+
+	"dct"
+	"huffman"
+)
+
+const (
+	SUBSAMPLING_444 int = 0
+	SUBSAMPLING_422 int = 1 // chroma halved horizontally
+	SUBSAMPLING_420 int = 2 // chroma halved in both directions
+)
+
+// The quality an EncodeOptions without one gets, the same as libjpeg's
+const DEFAULT_QUALITY int = 75
+
+type EncodeOptions struct {
+	// 1 to 100, scaling the Annex K quantization tables like libjpeg does. 0 is DEFAULT_QUALITY
+	Quality     int
+	Subsampling int
+	// MCUs between restart markers. 0 leaves them out
+	RestartInterval int
+	// Build Huffman tables from this image's symbol counts (K.2) instead of using the Annex K ones. Costs a
+	// second pass over the coefficients
+	OptimizeHuffman bool
+	// Write a progressive (SOF2) file instead of baseline. Progressive scans always get optimized tables
+	Progressive bool
+	// The scans to write when Progressive is set. nil uses DefaultScanScript
+	ScanScript []ScanSpec
+	// Leave out the source file's APPn and COM segments when writing coefficients back out
+	DropMetadata bool
+}
+
+// Baseline sequential and progressive encoder. Goes through the decoder's pipeline backwards: color conversion,
+// downsampling, FDCT, quantization, zig-zag, then Huffman coding with the Annex K tables
+type Encoder struct {
+	Frame
+	// Zig-zag order, keyed by table id, the same as JpegParser.QuantizationTables
+	QuantizationTables map[int][64]int
+	HuffmanWriters     []*huffman.HuffmanWriter
+	RestartInterval    int
+	// The progressive scans in order. nil for baseline
+	ScanScript []ScanSpec
+	// Written after the JFIF segment. Only set when the coefficients came from a file
+	Segments []*Section
+	planes   []*componentPlane
+}
+
+// Gray and Gray16 images, and paletted or YCbCr ones that only hold grays, are written as a single component.
+// Everything else is YCbCr with opts.Subsampling
+func Encode(w io.Writer, img image.Image, opts *EncodeOptions) error {
+	e, err := NewEncoder(img, opts)
+	if err != nil {
+		return err
+	}
+
+	return e.Write(w)
+}
+
+func NewEncoder(img image.Image, opts *EncodeOptions) (*Encoder, error) {
+	bounds := img.Bounds()
+
+	if bounds.Dx() < 1 || bounds.Dy() < 1 || bounds.Dx() > 65535 || bounds.Dy() > 65535 {
+		return nil, errors.New("image dimensions must be between 1 and 65535")
+	}
+
+	if opts.RestartInterval < 0 || opts.RestartInterval > 65535 {
+		return nil, errors.New("restart interval must be between 0 and 65535")
+	}
+
+	quality := opts.Quality
+	if quality == 0 {
+		quality = DEFAULT_QUALITY
+	}
+
+	if quality < 1 || quality > 100 {
+		return nil, errors.New("quality must be between 1 and 100")
+	}
+
+	e := &Encoder{
+		QuantizationTables: make(map[int][64]int),
+		HuffmanWriters:     AnnexKHuffmanWriters(),
+		RestartInterval:    opts.RestartInterval,
+	}
+
+	e.XLines = bounds.Dx()
+	e.YLines = bounds.Dy()
+
+	gray := isGrayImage(img)
+
+	if gray {
+		e.Components = []*Component{NewComponent(1, 1, 1, 0)}
+	} else {
+		lumaH, lumaV := 1, 1
+
+		switch opts.Subsampling {
+		case SUBSAMPLING_444:
+		case SUBSAMPLING_422:
+			lumaH = 2
+		case SUBSAMPLING_420:
+			lumaH, lumaV = 2, 2
+		default:
+			return nil, errors.New("unknown subsampling")
+		}
+
+		e.Components = []*Component{
+			NewComponent(1, lumaH, lumaV, 0),
+			NewComponent(2, 1, 1, 1),
+			NewComponent(3, 1, 1, 1),
+		}
+
+		e.Components[1].Td, e.Components[1].Ta = 1, 1
+		e.Components[2].Td, e.Components[2].Ta = 1, 1
+	}
+
+	e.ScanComponents = e.Components
+
+	e.QuantizationTables[0] = ScaleQuantizationTable(AnnexKLuminanceQuantization, quality)
+	if !gray {
+		e.QuantizationTables[1] = ScaleQuantizationTable(AnnexKChrominanceQuantization, quality)
+	}
+
+	e.planes = e.newComponentPlanes()
+
+	e.samplePlanes(img)
+
+	for _, p := range e.planes {
+		e.transformPlane(p)
+	}
+
+	if err := e.applyScanOptions(opts); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Picks the scan layout and Huffman tables once the coefficients are in place
+func (e *Encoder) applyScanOptions(opts *EncodeOptions) error {
+	if opts.Progressive {
+		e.ScanScript = opts.ScanScript
+		if e.ScanScript == nil {
+			e.ScanScript = DefaultScanScript(len(e.Components))
+		}
+
+		return e.validateScanScript(e.ScanScript)
+	}
+
+	if opts.OptimizeHuffman {
+		e.OptimizeHuffmanTables()
+	}
+
+	return nil
+}
+
+// Runs the scan once with counters in place of the writers, then swaps in tables built from the counts
+func (e *Encoder) OptimizeHuffmanTables() {
+	counters := make([]*huffman.HuffmanWriter, 0)
+
+	for _, h := range e.HuffmanWriters {
+		counters = append(counters, huffman.NewFrequencyCounter(h.Target, h.Identifier))
+	}
+
+	e.HuffmanWriters = counters
+
+	e.encodeScanTo(huffman.DiscardBitWriter{})
+
+	writers := make([]*huffman.HuffmanWriter, 0)
+
+	for _, c := range counters {
+		// A table the scan doesn't use has nothing to build from
+		if len(c.Frequencies) == 0 {
+			continue
+		}
+
+		bits, huffVal := huffman.OptimalTable(c.Frequencies)
+		writers = append(writers, huffman.NewHuffmanWriter(c.Target, c.Identifier, bits, huffVal))
+	}
+
+	e.HuffmanWriters = writers
+}
+
+// Full resolution Y, Cb and Cr for the image. The inverse of yCbCrToRGBA, from the JFIF spec
+// Whether the image can only hold grays, or happens to
+func isGrayImage(img image.Image) bool {
+	switch i := img.(type) {
+	case *image.Gray, *image.Gray16:
+		return true
+	case *image.Paletted:
+		for _, c := range i.Palette {
+			r, g, b, _ := c.RGBA()
+			if r != g || g != b {
+				return false
+			}
+		}
+		return true
+	case *image.YCbCr:
+		for _, v := range i.Cb {
+			if v != 128 {
+				return false
+			}
+		}
+		for _, v := range i.Cr {
+			if v != 128 {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+func imageToYCbCr(img image.Image) [3][]float64 {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+
+	out := [3][]float64{}
+	for i := range out {
+		out[i] = make([]float64, width*height)
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r16, g16, b16, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+
+			r := float64(r16 >> 8)
+			g := float64(g16 >> 8)
+			b := float64(b16 >> 8)
+
+			i := y*width + x
+			out[0][i] = 0.299*r + 0.587*g + 0.114*b
+			out[1][i] = -0.1687*r - 0.3313*g + 0.5*b + 128
+			out[2][i] = 0.5*r - 0.4187*g - 0.0813*b + 128
+		}
+	}
+
+	return out
+}
+
+// Fills each plane's samples. Subsampled components average the full resolution pixels they cover, and anything
+// past the image edge repeats the last row or column so the padding doesn't bleed into the picture
+func (e *Encoder) samplePlanes(img image.Image) {
+	full := imageToYCbCr(img)
+
+	hMax, vMax := e.MaxSampling()
+
+	for ci, p := range e.planes {
+		p.samples = make([]int, p.blockCols*p.blockRows*64)
+
+		factorX := hMax / p.component.H
+		factorY := vMax / p.component.V
+
+		for sy := 0; sy < p.blockRows*8; sy++ {
+			for sx := 0; sx < p.stride(); sx++ {
+				sum := 0.0
+
+				for dy := 0; dy < factorY; dy++ {
+					for dx := 0; dx < factorX; dx++ {
+						x := minInt(sx*factorX+dx, e.XLines-1)
+						y := minInt(sy*factorY+dy, e.YLines-1)
+
+						sum += full[ci][y*e.XLines+x]
+					}
+				}
+
+				p.samples[sy*p.stride()+sx] = intClamp(int(sum/float64(factorX*factorY) + 0.5))
+			}
+		}
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Level shift, FDCT, zig-zag and quantize every block of the plane. The inverse of reconstructPlane
+func (e *Encoder) transformPlane(p *componentPlane) {
+	table := e.QuantizationTables[p.component.Tq]
+
+	dctTransformer := dct.NewTransformer()
+
+	for b := range p.coefficients {
+		blockRow := b / p.blockCols
+		blockCol := b % p.blockCols
+
+		block := [64]int{}
+
+		for i := range block {
+			y := blockRow*8 + i/8
+			x := blockCol*8 + i%8
+
+			block[i] = p.samples[y*p.stride()+x] - 128
+		}
+
+		zigZag := huffman.ZigZag(dctTransformer.ArrayToArrayFDCT(block))
+
+		for i := range zigZag {
+			zigZag[i] = quantize(zigZag[i], table[i])
+		}
+
+		p.coefficients[b] = zigZag
+	}
+}
+
+// Division rounding half away from zero, as in A.3.4
+func quantize(v int, q int) int {
+	if v < 0 {
+		return -((-v + q/2) / q)
+	}
+
+	return (v + q/2) / q
+}
+
+func (e *Encoder) GetHuffmanWriter(target int, identifier int) *huffman.HuffmanWriter {
+	var ret *huffman.HuffmanWriter
+
+	for _, h := range e.HuffmanWriters {
+		if h.Target == target && h.Identifier == identifier {
+			ret = h
+		}
+	}
+
+	return ret
+}
+
+func (e *Encoder) encodeScan() []byte {
+	s := NewScanWriter()
+
+	e.encodeScanTo(s)
+
+	s.Flush()
+
+	return s.Body
+}
+
+// Restart markers only matter for the bytes so a writer that isn't a ScanWriter just skips them
+type restartWriter interface {
+	WriteRestart(m int)
+}
+
+// Entropy code the scan, with a restart marker every RestartInterval MCUs
+func (e *Encoder) encodeScanTo(s huffman.BitWriter) {
+	predictions := make([]int, len(e.ScanComponents))
+
+	total := e.ScanMCUs()
+
+	for mcu := 0; mcu < total; mcu++ {
+		if e.RestartInterval > 0 && mcu > 0 && mcu%e.RestartInterval == 0 {
+			if r, ok := s.(restartWriter); ok {
+				r.WriteRestart(mcu/e.RestartInterval - 1)
+			}
+
+			for i := range predictions {
+				predictions[i] = 0
+			}
+		}
+
+		e.forEachMCUBlock(e.planes, mcu, func(ci int, p *componentPlane, index int) {
+			block := p.coefficients[index]
+
+			dcWriter := e.GetHuffmanWriter(huffman.TARGET_DC, p.component.Td)
+			acWriter := e.GetHuffmanWriter(huffman.TARGET_AC, p.component.Ta)
+
+			predictions[ci] = dcWriter.EncodeDC(s, block[0], predictions[ci])
+			acWriter.EncodeACCoefficients(s, block)
+		})
+	}
+}
+
+func writeSegment(w io.Writer, marker byte, body []byte) error {
+	if len(body)+2 > 0xFFFF {
+		return errors.New("marker segment too long")
+	}
+
+	header := []byte{0xFF, marker, byte((len(body) + 2) >> 8), byte(len(body) + 2)}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	_, err := w.Write(body)
+	return err
+}
+
+// JFIF 1.01, no units, square pixels, no thumbnail
+func (e *Encoder) jfifBody() []byte {
+	return append(append([]byte{}, jfifIdentifier...), 1, 1, 0, 0, 1, 0, 1, 0, 0)
+}
+
+// B.2.4.1
+func (e *Encoder) dqtBody() []byte {
+	body := make([]byte, 0)
+
+	ids := make([]int, 0)
+	for id := range e.QuantizationTables {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		body = append(body, byte(id)) // Pq of 0 is 8 bit values
+
+		for _, q := range e.QuantizationTables[id] {
+			body = append(body, byte(q))
+		}
+	}
+
+	return body
+}
+
+// B.2.2
+func (e *Encoder) sofBody() []byte {
+	body := []byte{8, byte(e.YLines >> 8), byte(e.YLines), byte(e.XLines >> 8), byte(e.XLines), byte(len(e.Components))}
+
+	for _, c := range e.Components {
+		body = append(body, byte(c.Id), byte(c.H<<4|c.V), byte(c.Tq))
+	}
+
+	return body
+}
+
+// B.2.4.2. Only the tables the scan uses are written
+func (e *Encoder) dhtBody() []byte {
+	body := make([]byte, 0)
+
+	for _, h := range e.HuffmanWriters {
+		used := false
+		for _, c := range e.ScanComponents {
+			if (h.Target == huffman.TARGET_DC && c.Td == h.Identifier) || (h.Target == huffman.TARGET_AC && c.Ta == h.Identifier) {
+				used = true
+			}
+		}
+
+		if !used {
+			continue
+		}
+
+		body = append(body, byte(h.Target<<4|h.Identifier))
+
+		for i := 1; i <= 16; i++ {
+			body = append(body, byte(h.Bits[i]))
+		}
+
+		for _, v := range h.HuffVal {
+			body = append(body, byte(v))
+		}
+	}
+
+	return body
+}
+
+// B.2.3. Baseline always covers the whole spectrum with no successive approximation
+func (e *Encoder) sosBody(spec *ScanSpec) []byte {
+	body := []byte{byte(len(e.ScanComponents))}
+
+	for _, c := range e.ScanComponents {
+		body = append(body, byte(c.Id), byte(c.Td<<4|c.Ta))
+	}
+
+	return append(body, byte(spec.Ss), byte(spec.Se), byte(spec.Ah<<4|spec.Al))
+}
+
+func (e *Encoder) Write(w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+
+	if _, err := buffered.Write([]byte{0xFF, MARKER_SOI}); err != nil {
+		return err
+	}
+
+	sof := MARKER_SOF0
+	if e.ScanScript != nil {
+		sof = MARKER_SOF2
+	}
+
+	segments := append([]*Section{NewSection(MARKER_JFIF, e.jfifBody())}, e.Segments...)
+	segments = append(segments, NewSection(MARKER_DQT, e.dqtBody()), NewSection(sof, e.sofBody()))
+
+	// Progressive tables are built scan by scan and written ahead of each one
+	if e.ScanScript == nil {
+		segments = append(segments, NewSection(MARKER_DHT, e.dhtBody()))
+	}
+
+	if e.RestartInterval > 0 {
+		segments = append(segments, NewSection(MARKER_DRI, []byte{byte(e.RestartInterval >> 8), byte(e.RestartInterval)}))
+	}
+
+	for _, s := range segments {
+		if err := writeSegment(buffered, s.Type, s.Body); err != nil {
+			return err
+		}
+	}
+
+	if e.ScanScript != nil {
+		if err := e.writeProgressiveScans(buffered); err != nil {
+			return err
+		}
+	} else {
+		if err := writeSegment(buffered, MARKER_SOS, e.sosBody(baselineScanSpec)); err != nil {
+			return err
+		}
+
+		if _, err := buffered.Write(e.encodeScan()); err != nil {
+			return err
+		}
+	}
+
+	if _, err := buffered.Write([]byte{0xFF, MARKER_EOI}); err != nil {
+		return err
+	}
+
+	return buffered.Flush()
+}
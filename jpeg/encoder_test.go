@@ -0,0 +1,140 @@
+package jpeg
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func testRGBA(img image.Image) *image.RGBA {
+	rgba := image.NewRGBA(img.Bounds())
+
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			rgba.Set(x, y, img.At(x, y))
+		}
+	}
+
+	return rgba
+}
+
+func TestEncodeQuality(t *testing.T) {
+	img := testImage(33, 17, false, 1)
+
+	if !bytes.Equal(testEncode(t, img, &EncodeOptions{}), testEncode(t, img, &EncodeOptions{Quality: DEFAULT_QUALITY})) {
+		t.Error("quality 0 doesn't encode like DEFAULT_QUALITY")
+	}
+
+	for _, quality := range []int{-1, 101} {
+		if err := Encode(&bytes.Buffer{}, img, &EncodeOptions{Quality: quality}); err == nil {
+			t.Errorf("quality %d encoded", quality)
+		}
+	}
+}
+
+// Encodes and decodes again. The PSNR floors are a couple of dB under what each case gets on the test image, whose
+// noise and hard edge keep colour well under what a photo would get. Restarts and optimized tables mustn't change
+// the pixels at all, so they share the floors
+func TestEncodeRoundTrip(t *testing.T) {
+	cases := []struct {
+		gray        bool
+		subsampling int
+		quality     int
+		minPSNR     float64
+	}{
+		{true, SUBSAMPLING_444, 50, 30},
+		{true, SUBSAMPLING_444, 95, 38},
+		{false, SUBSAMPLING_444, 50, 25},
+		{false, SUBSAMPLING_444, 95, 33},
+		{false, SUBSAMPLING_422, 75, 24},
+		{false, SUBSAMPLING_420, 75, 21},
+		{false, SUBSAMPLING_420, 100, 23},
+	}
+
+	for _, c := range cases {
+		for _, restart := range []int{0, 4} {
+			for _, optimize := range []bool{false, true} {
+				name := fmt.Sprintf("gray=%v/subsampling%d/q%d/restart%d/optimize=%v", c.gray, c.subsampling, c.quality, restart, optimize)
+
+				t.Run(name, func(t *testing.T) {
+					img := testImage(45, 29, c.gray, 7)
+					opts := &EncodeOptions{Quality: c.quality, Subsampling: c.subsampling, RestartInterval: restart, OptimizeHuffman: optimize}
+
+					decoded := testDecode(t, testEncode(t, img, opts))
+
+					psnr, err := PSNR(testRGBA(img), decoded)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if psnr < c.minPSNR {
+						t.Errorf("PSNR %.1f dB, under %.0f", psnr, c.minPSNR)
+					}
+				})
+			}
+		}
+	}
+}
+
+// Images that only hold grays get a single component, and come back as the same grays
+func TestEncodeGrayImages(t *testing.T) {
+	rect := image.Rect(0, 0, 16, 16)
+	grays := color.Palette{color.Gray{0}, color.Gray{100}, color.Gray{200}}
+
+	gray16 := image.NewGray16(rect)
+	paletted := image.NewPaletted(rect, grays)
+	colourPaletted := image.NewPaletted(rect, append(append(color.Palette{}, grays...), color.RGBA{255, 0, 0, 255}))
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio444)
+	colourYCbCr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio444)
+
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			v := uint8(x * 16)
+			gray16.SetGray16(x, y, color.Gray16{uint16(v)<<8 | 0x7F})
+			paletted.SetColorIndex(x, y, uint8(x/6))
+			colourPaletted.SetColorIndex(x, y, uint8(x/6))
+
+			i := ycbcr.YOffset(x, y)
+			ycbcr.Y[i], ycbcr.Cb[i], ycbcr.Cr[i] = v, 128, 128
+			colourYCbCr.Y[i], colourYCbCr.Cb[i], colourYCbCr.Cr[i] = v, 128, 128
+		}
+	}
+	colourYCbCr.Cr[colourYCbCr.COffset(5, 5)] = 200
+
+	cases := []struct {
+		name       string
+		img        image.Image
+		components int
+	}{
+		{"Gray16", gray16, 1},
+		{"gray palette", paletted, 1},
+		{"palette with a colour", colourPaletted, 3},
+		{"YCbCr without chroma", ycbcr, 1},
+		{"YCbCr with chroma", colourYCbCr, 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := testEncode(t, c.img, &EncodeOptions{Quality: 95})
+
+			j, err := NewJpegParserFromBytes(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(j.Components) != c.components {
+				t.Fatalf("got %d components", len(j.Components))
+			}
+
+			if c.components == 1 {
+				psnr, err := PSNR(testRGBA(c.img), testDecode(t, b))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if psnr < 35 {
+					t.Errorf("PSNR %.1f dB", psnr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package jpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// EXIF is a TIFF structure inside an APP1 segment. All offsets inside it are relative to the TIFF header, which
+// starts right after the "Exif\0\0" identifier. See the EXIF 2.3 spec section 4.5 and TIFF 6.0 section 2
+
+var exifIdentifier = []byte("Exif\x00\x00")
+
+const (
+	TIFF_TYPE_BYTE      int = 1
+	TIFF_TYPE_ASCII     int = 2
+	TIFF_TYPE_SHORT     int = 3
+	TIFF_TYPE_LONG      int = 4
+	TIFF_TYPE_RATIONAL  int = 5
+	TIFF_TYPE_UNDEFINED int = 7
+	TIFF_TYPE_SLONG     int = 9
+	TIFF_TYPE_SRATIONAL int = 10
+
+	TAG_COMPRESSION         int = 0x0103
+	TAG_ORIENTATION         int = 0x0112
+	TAG_JPEG_IF_OFFSET      int = 0x0201 // JPEGInterchangeFormat
+	TAG_JPEG_IF_LENGTH      int = 0x0202 // JPEGInterchangeFormatLength
+	TAG_EXIF_IFD_POINTER    int = 0x8769
+	TAG_GPS_IFD_POINTER     int = 0x8825
+	TAG_INTEROP_IFD_POINTER int = 0xA005
+)
+
+// Size in bytes of one value of each TIFF field type
+var tiffTypeSizes = map[int]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+type TiffReader struct {
+	Body  []byte
+	Order binary.ByteOrder
+}
+
+type IfdEntry struct {
+	Tag   int
+	Type  int
+	Count int
+	// Where the entry itself starts, relative to the TIFF header
+	EntryOffset int
+	// Where the value lives. Values of 4 bytes or less are stored in the entry, otherwise this is the
+	// offset the entry points at
+	ValueOffset int
+}
+
+func IsExif(body []byte) bool {
+	return bytes.HasPrefix(body, exifIdentifier)
+}
+
+// Takes the body of an APP1 segment, identifier included
+func NewExifReader(body []byte) (*TiffReader, error) {
+	if !IsExif(body) {
+		return nil, errors.New("not an EXIF segment")
+	}
+
+	return NewTiffReader(body[len(exifIdentifier):])
+}
+
+func NewTiffReader(body []byte) (*TiffReader, error) {
+	if len(body) < 8 {
+		return nil, errors.New("TIFF header too short")
+	}
+
+	t := &TiffReader{Body: body}
+
+	switch string(body[0:2]) {
+	case "II":
+		t.Order = binary.LittleEndian
+	case "MM":
+		t.Order = binary.BigEndian
+	default:
+		return nil, errors.New("unknown TIFF byte order")
+	}
+
+	if t.Order.Uint16(body[2:4]) != 42 {
+		return nil, errors.New("bad TIFF magic number")
+	}
+
+	return t, nil
+}
+
+func (t *TiffReader) FirstIFDOffset() int {
+	return int(t.Order.Uint32(t.Body[4:8]))
+}
+
+// Reads the IFD at offset and returns its entries and the offset of the next IFD, which is 0 on the last one
+func (t *TiffReader) ReadIFD(offset int) ([]*IfdEntry, int, error) {
+	if offset < 8 || offset+2 > len(t.Body) {
+		return nil, 0, errors.New("IFD offset out of range")
+	}
+
+	count := int(t.Order.Uint16(t.Body[offset:]))
+
+	end := offset + 2 + count*12
+	if end+4 > len(t.Body) {
+		return nil, 0, errors.New("IFD runs past end of segment")
+	}
+
+	entries := make([]*IfdEntry, 0, count)
+
+	for i := 0; i < count; i++ {
+		entryOffset := offset + 2 + i*12
+		e := &IfdEntry{
+			Tag:         int(t.Order.Uint16(t.Body[entryOffset:])),
+			Type:        int(t.Order.Uint16(t.Body[entryOffset+2:])),
+			Count:       int(t.Order.Uint32(t.Body[entryOffset+4:])),
+			EntryOffset: entryOffset,
+			ValueOffset: entryOffset + 8,
+		}
+
+		if e.Size() > 4 {
+			e.ValueOffset = int(t.Order.Uint32(t.Body[entryOffset+8:]))
+		}
+
+		entries = append(entries, e)
+	}
+
+	next := int(t.Order.Uint32(t.Body[end:]))
+
+	return entries, next, nil
+}
+
+// Total size of the value in bytes. Unknown types are treated as bytes
+func (e *IfdEntry) Size() int {
+	size, present := tiffTypeSizes[e.Type]
+	if !present {
+		size = 1
+	}
+
+	return size * e.Count
+}
+
+// First value of a BYTE, SHORT or LONG entry
+func (t *TiffReader) Uint(e *IfdEntry) (int, error) {
+	if e.ValueOffset+e.Size() > len(t.Body) || e.Count == 0 {
+		return 0, errors.New("IFD value out of range")
+	}
+
+	switch e.Type {
+	case TIFF_TYPE_BYTE, TIFF_TYPE_UNDEFINED:
+		return int(t.Body[e.ValueOffset]), nil
+	case TIFF_TYPE_SHORT:
+		return int(t.Order.Uint16(t.Body[e.ValueOffset:])), nil
+	case TIFF_TYPE_LONG:
+		return int(t.Order.Uint32(t.Body[e.ValueOffset:])), nil
+	}
+
+	return 0, errors.New("IFD entry isn't an unsigned integer")
+}
+
+func FindEntry(entries []*IfdEntry, tag int) *IfdEntry {
+	for _, e := range entries {
+		if e.Tag == tag {
+			return e
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package jpeg
+
+import (
+	"errors"
+)
+
+// In place edits to an EXIF segment. Values can be blanked or overwritten and entries removed, but nothing grows,
+// so every offset in the TIFF structure stays valid without rebuilding it
+
+const (
+	TAG_CAMERA_OWNER_NAME  int = 0xA430
+	TAG_BODY_SERIAL_NUMBER int = 0xA431
+	TAG_LENS_SERIAL_NUMBER int = 0xA435
+	TAG_IMAGE_UNIQUE_ID    int = 0xA420
+	TAG_MAKER_NOTE         int = 0x927C
+)
+
+type ExifEditor struct {
+	*TiffReader
+}
+
+// Takes the body of an APP1 segment, identifier included. Edits go straight into body
+func NewExifEditor(body []byte) (*ExifEditor, error) {
+	t, err := NewExifReader(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExifEditor{TiffReader: t}, nil
+}
+
+// Offsets of IFD0, IFD1 and the EXIF, GPS and interoperability IFDs that exist
+func (e *ExifEditor) IFDOffsets() ([]int, error) {
+	offsets := make([]int, 0)
+
+	offset := e.FirstIFDOffset()
+
+	// IFD0 then IFD1. Anything chained past that isn't part of EXIF
+	for i := 0; i < 2 && offset != 0; i++ {
+		entries, next, err := e.ReadIFD(offset)
+		if err != nil {
+			return nil, err
+		}
+
+		offsets = append(offsets, offset)
+
+		for _, tag := range []int{TAG_EXIF_IFD_POINTER, TAG_GPS_IFD_POINTER} {
+			pointer := FindEntry(entries, tag)
+			if pointer == nil {
+				continue
+			}
+
+			sub, err := e.Uint(pointer)
+			if err != nil {
+				return nil, err
+			}
+
+			offsets = append(offsets, sub)
+
+			if tag != TAG_EXIF_IFD_POINTER {
+				continue
+			}
+
+			subEntries, _, err := e.ReadIFD(sub)
+			if err != nil {
+				return nil, err
+			}
+
+			if interop := FindEntry(subEntries, TAG_INTEROP_IFD_POINTER); interop != nil {
+				interopOffset, err := e.Uint(interop)
+				if err != nil {
+					return nil, err
+				}
+
+				offsets = append(offsets, interopOffset)
+			}
+		}
+
+		offset = next
+	}
+
+	return offsets, nil
+}
+
+// The IFD offset and entry for tag, searching every IFD. Returns a nil entry if the tag isn't there
+func (e *ExifEditor) FindTag(tag int) (int, *IfdEntry, error) {
+	offsets, err := e.IFDOffsets()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, offset := range offsets {
+		entries, _, err := e.ReadIFD(offset)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if entry := FindEntry(entries, tag); entry != nil {
+			return offset, entry, nil
+		}
+	}
+
+	return 0, nil, nil
+}
+
+func (e *ExifEditor) valueBytes(entry *IfdEntry) ([]byte, error) {
+	if entry.ValueOffset < 0 || entry.ValueOffset+entry.Size() > len(e.Body) {
+		return nil, errors.New("IFD value out of range")
+	}
+
+	return e.Body[entry.ValueOffset : entry.ValueOffset+entry.Size()], nil
+}
+
+// Overwrites the value of tag. value can be shorter than the existing one, in which case the rest is zeroed, which
+// for ASCII just makes the string shorter. Returns false if the tag isn't there
+func (e *ExifEditor) SetValue(tag int, value []byte) (bool, error) {
+	_, entry, err := e.FindTag(tag)
+	if err != nil || entry == nil {
+		return false, err
+	}
+
+	existing, err := e.valueBytes(entry)
+	if err != nil {
+		return false, err
+	}
+
+	if len(value) > len(existing) {
+		return false, errors.New("new value is longer than the existing one")
+	}
+
+	copy(existing, value)
+	for i := len(value); i < len(existing); i++ {
+		existing[i] = 0
+	}
+
+	return true, nil
+}
+
+// Blanks the value of tag but keeps the entry
+func (e *ExifEditor) ZeroValue(tag int) (bool, error) {
+	return e.SetValue(tag, nil)
+}
+
+// Takes tag's entry out of its IFD and blanks its value. The entries after it move up and the IFD gets 12 bytes of
+// zeros at the end. Returns false if the tag isn't there
+func (e *ExifEditor) RemoveTag(tag int) (bool, error) {
+	offset, entry, err := e.FindTag(tag)
+	if err != nil || entry == nil {
+		return false, err
+	}
+
+	value, err := e.valueBytes(entry)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range value {
+		value[i] = 0
+	}
+
+	count := int(e.Order.Uint16(e.Body[offset:]))
+	end := offset + 2 + count*12
+
+	// Shift the later entries and the next IFD pointer up over the removed entry
+	copy(e.Body[entry.EntryOffset:end+4-12], e.Body[entry.EntryOffset+12:end+4])
+
+	for i := end + 4 - 12; i < end+4; i++ {
+		e.Body[i] = 0
+	}
+
+	e.Order.PutUint16(e.Body[offset:], uint16(count-1))
+
+	return true, nil
+}
+
+// Blanks every GPS value, empties the GPS IFD and drops the pointer to it from IFD0
+func (e *ExifEditor) ZeroGPS() (bool, error) {
+	_, pointer, err := e.FindTag(TAG_GPS_IFD_POINTER)
+	if err != nil || pointer == nil {
+		return false, err
+	}
+
+	offset, err := e.Uint(pointer)
+	if err != nil {
+		return false, err
+	}
+
+	entries, _, err := e.ReadIFD(offset)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range entries {
+		value, err := e.valueBytes(entry)
+		if err != nil {
+			return false, err
+		}
+
+		for i := range value {
+			value[i] = 0
+		}
+
+		for i := entry.EntryOffset; i < entry.EntryOffset+12; i++ {
+			e.Body[i] = 0
+		}
+	}
+
+	// No entries and no next IFD
+	e.Order.PutUint16(e.Body[offset:], 0)
+	e.Order.PutUint32(e.Body[offset+2:], 0)
+
+	return e.RemoveTag(TAG_GPS_IFD_POINTER)
+}
+
+// Blanks the owner name, the serial numbers and unique id, and the maker note, which is where most cameras keep
+// their own copy of the serial number
+func (e *ExifEditor) ZeroSerialNumbers() error {
+	for _, tag := range []int{TAG_CAMERA_OWNER_NAME, TAG_BODY_SERIAL_NUMBER, TAG_LENS_SERIAL_NUMBER, TAG_IMAGE_UNIQUE_ID, TAG_MAKER_NOTE} {
+		if _, err := e.ZeroValue(tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package jpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+const (
+	testTagMake        = 0x010F
+	testTagLatitudeRef = 0x0001
+	testTagLatitude    = 0x0002
+)
+
+// An APP1 body holding the IFDs
+func buildExif(order binary.ByteOrder, ifds [][]testIfdEntry) []byte {
+	return append(append([]byte{}, exifIdentifier...), buildTiff(order, ifds)...)
+}
+
+func testExif(order binary.ByteOrder) []byte {
+	latitude := make([]byte, 24)
+	for i, v := range []uint32{37, 1, 48, 1, 3000, 100} {
+		order.PutUint32(latitude[4*i:], v)
+	}
+
+	return buildExif(order, [][]testIfdEntry{
+		{
+			{tag: testTagMake, typ: TIFF_TYPE_ASCII, count: 10, value: []byte("TestMaker\x00")},
+			{tag: TAG_EXIF_IFD_POINTER, typ: TIFF_TYPE_LONG, count: 1, ifd: 1},
+			{tag: TAG_GPS_IFD_POINTER, typ: TIFF_TYPE_LONG, count: 1, ifd: 2},
+		},
+		{
+			{tag: TAG_CAMERA_OWNER_NAME, typ: TIFF_TYPE_ASCII, count: 3, value: []byte("Jo\x00")},
+			{tag: TAG_BODY_SERIAL_NUMBER, typ: TIFF_TYPE_ASCII, count: 9, value: []byte("SN123456\x00")},
+		},
+		{
+			{tag: testTagLatitudeRef, typ: TIFF_TYPE_ASCII, count: 2, value: []byte("N\x00")},
+			{tag: testTagLatitude, typ: TIFF_TYPE_RATIONAL, count: 3, value: latitude},
+		},
+	})
+}
+
+func testExifEditor(t *testing.T, body []byte) *ExifEditor {
+	e, err := NewExifEditor(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+// Whether each tag is still there
+func exifTags(t *testing.T, e *ExifEditor, tags ...int) string {
+	found := ""
+
+	for _, tag := range tags {
+		_, entry, err := e.FindTag(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found += fmt.Sprintf("%04x:%v ", tag, entry != nil)
+	}
+
+	return found
+}
+
+func TestExifEditor(t *testing.T) {
+	all := []int{testTagMake, TAG_EXIF_IFD_POINTER, TAG_GPS_IFD_POINTER, TAG_CAMERA_OWNER_NAME, TAG_BODY_SERIAL_NUMBER, testTagLatitudeRef, testTagLatitude}
+
+	cases := []struct {
+		name string
+		edit func(e *ExifEditor) (bool, error)
+		// What's left of all
+		left []bool
+		// Bytes that mustn't be anywhere in the segment afterwards, and ones that must still be
+		gone []string
+		kept []string
+	}{
+		{
+			"ZeroGPS",
+			func(e *ExifEditor) (bool, error) { return e.ZeroGPS() },
+			[]bool{true, true, false, true, true, false, false},
+			[]string{"N\x00", "\x00\x00\x00\x25", "\x25\x00\x00\x00"},
+			[]string{"TestMaker", "SN123456"},
+		},
+		{
+			"RemoveTag Make",
+			func(e *ExifEditor) (bool, error) { return e.RemoveTag(testTagMake) },
+			[]bool{false, true, true, true, true, true, true},
+			[]string{"TestMaker"},
+			[]string{"SN123456", "N\x00"},
+		},
+		{
+			"RemoveTag serial number",
+			func(e *ExifEditor) (bool, error) { return e.RemoveTag(TAG_BODY_SERIAL_NUMBER) },
+			[]bool{true, true, true, true, false, true, true},
+			[]string{"SN123456"},
+			[]string{"TestMaker", "Jo\x00"},
+		},
+		{
+			"RemoveTag owner name, held in the entry",
+			func(e *ExifEditor) (bool, error) { return e.RemoveTag(TAG_CAMERA_OWNER_NAME) },
+			[]bool{true, true, true, false, true, true, true},
+			[]string{"Jo\x00"},
+			[]string{"TestMaker", "SN123456"},
+		},
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, c := range cases {
+			t.Run(fmt.Sprintf("%v/%s", order, c.name), func(t *testing.T) {
+				body := testExif(order)
+				e := testExifEditor(t, body)
+
+				if done, err := c.edit(e); !done || err != nil {
+					t.Fatalf("got %v, %v", done, err)
+				}
+
+				// Read it again from the edited bytes, the way the rewritten file will be
+				e = testExifEditor(t, body)
+
+				want := ""
+				for i, tag := range all {
+					want += fmt.Sprintf("%04x:%v ", tag, c.left[i])
+				}
+				if got := exifTags(t, e, all...); got != want {
+					t.Errorf("got  %s\nwant %s", got, want)
+				}
+
+				for _, s := range c.gone {
+					if bytes.Contains(body, []byte(s)) {
+						t.Errorf("%q is still there", s)
+					}
+				}
+				for _, s := range c.kept {
+					if !bytes.Contains(body, []byte(s)) {
+						t.Errorf("%q is gone", s)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestExifEditorMissingTags(t *testing.T) {
+	body := buildExif(binary.BigEndian, [][]testIfdEntry{{{tag: testTagMake, typ: TIFF_TYPE_ASCII, count: 10, value: []byte("TestMaker\x00")}}})
+	e := testExifEditor(t, body)
+
+	if done, err := e.ZeroGPS(); done || err != nil {
+		t.Errorf("ZeroGPS without GPS got %v, %v", done, err)
+	}
+
+	if done, err := e.RemoveTag(TAG_BODY_SERIAL_NUMBER); done || err != nil {
+		t.Errorf("RemoveTag of a missing tag got %v, %v", done, err)
+	}
+
+	if _, err := e.SetValue(testTagMake, []byte("A much longer maker")); err == nil {
+		t.Error("SetValue grew the value")
+	}
+
+	if err := e.ZeroSerialNumbers(); err != nil {
+		t.Error(err)
+	}
+
+	if !bytes.Contains(body, []byte("TestMaker")) {
+		t.Error("the maker went")
+	}
+}
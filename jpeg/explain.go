@@ -0,0 +1,611 @@
+package jpeg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+
+	"dct"
+	"huffman"
+)
+
+// A single HTML file that walks through how a file decodes, for reading offline. It has the marker layout, the
+// tables, the DCT basis functions and the Huffman code trees, and the decoded image. Clicking an MCU of the image
+// shows the trace of each of its blocks. The page only lays out what the decoder worked out, apart from the
+// dequantization and level shift, which are single multiplies and adds
+
+type ExplainOptions struct {
+	// MCUs whose blocks can be clicked on. Empty means all of them, which for a large image makes a large file
+	MCUs []int
+}
+
+// What the page script gets, as JSON
+type explainData struct {
+	Info *FileInfo `json:"info"`
+	// The decode error, if the image is damaged
+	Warning string `json:"warning,omitempty"`
+	MCUCols int    `json:"mcu_cols"`
+	MCURows int    `json:"mcu_rows"`
+	// Pixels per MCU
+	MCUWidth  int `json:"mcu_width"`
+	MCUHeight int `json:"mcu_height"`
+	HMax      int `json:"h_max"`
+	VMax      int `json:"v_max"`
+	// Natural order index of each zig-zag position
+	ZigZag [64]int `json:"zigzag"`
+	// The blocks of each MCU that was traced
+	MCUs map[int][]explainBlock `json:"mcus"`
+}
+
+// A BlockTrace cut down to what the page can't work out itself, with short names since there's one per block
+type explainBlock struct {
+	Component  int             `json:"c"`
+	Row        int             `json:"row"`
+	Col        int             `json:"col"`
+	Table      int             `json:"q"`
+	Symbols    []explainSymbol `json:"symbols,omitempty"`
+	Prediction int             `json:"prediction"`
+	// Trailing zeros dropped
+	ZigZag []int `json:"zigzag"`
+	// Row by row
+	IDCT [64]int `json:"idct"`
+}
+
+// Written as [index, symbol, code, extra, value]
+type explainSymbol TraceSymbol
+
+func (s explainSymbol) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{s.Index, s.Symbol, s.Code, s.Extra, s.Value})
+}
+
+func (j *JpegParser) WriteExplainHTML(w io.Writer, name string, opts *ExplainOptions) error {
+	info := j.Info()
+
+	mcuCols := j.frameMCUCols()
+	mcuRows := j.frameMCURows()
+
+	mcus := opts.MCUs
+	if len(mcus) == 0 {
+		for m := 0; m < mcuCols*mcuRows; m++ {
+			mcus = append(mcus, m)
+		}
+	}
+
+	traces, img, decodeErr := j.trace(&TraceOptions{MCUs: mcus})
+	if traces == nil {
+		return decodeErr
+	}
+
+	data := &explainData{
+		Info:    info,
+		MCUCols: mcuCols,
+		MCURows: mcuRows,
+		HMax:    1,
+		VMax:    1,
+		ZigZag:  huffman.ZigZagOrder,
+		MCUs:    make(map[int][]explainBlock),
+	}
+	data.MCUWidth, data.MCUHeight = j.frameMCUSize()
+
+	// A single component frame has one block per MCU, whatever its sampling factors say
+	if len(j.Components) > 1 {
+		data.HMax, data.VMax = j.MaxSampling()
+	}
+
+	if decodeErr != nil {
+		data.Warning = decodeErr.Error()
+	}
+
+	for _, t := range traces {
+		b := explainBlock{Component: t.Component, Row: t.Row, Col: t.Col, Table: t.QuantizationTable, Prediction: t.Prediction}
+
+		for _, s := range t.Symbols {
+			b.Symbols = append(b.Symbols, explainSymbol(s))
+		}
+
+		last := 63
+		for last > 0 && t.ZigZag[last] == 0 {
+			last--
+		}
+		b.ZigZag = append([]int{}, t.ZigZag[:last+1]...)
+
+		for i := 0; i < 64; i++ {
+			b.IDCT[i] = t.IDCT[i/8][i%8]
+		}
+
+		data.MCUs[t.MCU] = append(data.MCUs[t.MCU], b)
+	}
+
+	imageURL, err := pngDataURL(img)
+	if err != nil {
+		return err
+	}
+
+	basisURL, err := pngDataURL(basisImage())
+	if err != nil {
+		return err
+	}
+
+	return explainTemplate.Execute(w, struct {
+		File  string
+		Image template.URL
+		Basis template.URL
+		Data  *explainData
+	}{name, imageURL, basisURL, data})
+}
+
+func pngDataURL(img image.Image) (template.URL, error) {
+	var b bytes.Buffer
+
+	if err := png.Encode(&b, img); err != nil {
+		return "", err
+	}
+
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(b.Bytes())), nil
+}
+
+// The 64 DCT basis functions (A.3.3) on an 8x8 grid, horizontal frequency increasing to the right and vertical
+// frequency downwards. Each one is the IDCT of a block with a single coefficient set, stretched to the full grey
+// range, with a line between them
+func basisImage() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 8*9+1, 8*9+1))
+
+	for i := range img.Pix {
+		img.Pix[i] = 96
+	}
+
+	dctTransformer := dct.NewTransformer()
+
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			in := [64]int{}
+			in[v*8+u] = 1024
+
+			out := dctTransformer.ArrayToArrayIDCT(in)
+
+			peak := 1
+			for _, e := range out {
+				if e > peak {
+					peak = e
+				}
+				if -e > peak {
+					peak = -e
+				}
+			}
+
+			for i, e := range out {
+				img.SetGray(u*9+1+i%8, v*9+1+i/8, color.Gray{uint8(128 + e*127/peak)})
+			}
+		}
+	}
+
+	return img
+}
+
+var explainTemplate = template.Must(template.New("explain").Parse(explainHTML))
+
+const explainHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.File}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h2 { margin-top: 2em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; font-family: monospace; }
+td, th { padding: 1px 6px; text-align: right; }
+th { background: #eee; }
+.left { text-align: left; }
+.note { color: #666; max-width: 60em; }
+.warning { color: #a00; }
+.layout { display: flex; height: 2.5em; border: 1px solid #888; }
+.layout div { min-width: 3px; overflow: hidden; font-size: 11px; border-right: 1px solid #fff; color: #fff; }
+.grids { display: flex; flex-wrap: wrap; gap: 1.5em; }
+.grid td { min-width: 3em; border: 1px solid #ddd; }
+.block { border: 1px solid #ccc; padding: 1em; margin: 1em 0; }
+.swatch { width: 1.2em; height: 1.2em; display: inline-block; border: 1px solid #888; vertical-align: middle; }
+#view { position: relative; display: inline-block; cursor: crosshair; }
+#view img { max-width: 100%; display: block; }
+#mark { position: absolute; border: 2px solid #f0f; pointer-events: none; display: none; }
+#basis { width: 438px; image-rendering: pixelated; }
+details { margin-left: 1.2em; font-family: monospace; }
+summary { cursor: pointer; }
+</style>
+</head>
+<body>
+<h1>{{.File}}</h1>
+<div id="summary"></div>
+
+<h2>Marker layout</h2>
+<p class="note">Every marker segment in file order, sized by its length. The entropy coded data after each SOS is shown as "data".</p>
+<div id="layout" class="layout"></div>
+<div id="segments"></div>
+
+<h2>Quantization tables</h2>
+<p class="note">In natural order, row by row. Each quantized coefficient is multiplied by the entry in the same place (F.2.1.4).</p>
+<div id="quantization" class="grids"></div>
+
+<h2>DCT basis functions</h2>
+<p class="note">Every 8x8 block is a weighted sum of these 64 patterns (A.3.3). Horizontal frequency increases to the right and vertical frequency downwards, so the top left is the DC and the coefficient matrix of a block gives the weight of each one.</p>
+<img id="basis" src="{{.Basis}}" alt="DCT basis functions">
+
+<h2>Huffman code trees</h2>
+<p class="note">The codes built from BITS and HUFFVAL by generateHuffCode (C.2). Each branch adds a bit, and the leaves are the symbols. A DC symbol is the size of the difference that follows, an AC symbol is a run of zeros in the high four bits and the size of the coefficient in the low four.</p>
+<div id="huffman"></div>
+
+<h2>Decoded image</h2>
+<p class="note">Click anywhere to see how that MCU was decoded.</p>
+<div id="view"><img id="image" src="{{.Image}}" alt="decoded image"><div id="mark"></div></div>
+<canvas id="pixels" style="display: none"></canvas>
+<div id="mcu"></div>
+
+<script>
+const report = {{.Data}};
+</script>
+<script>
+function el(tag, text, cls) {
+	const e = document.createElement(tag);
+	if (text !== undefined && text !== null) {
+		e.textContent = text;
+	}
+	if (cls) {
+		e.className = cls;
+	}
+	return e;
+}
+
+function hex(v, width) {
+	let s = v.toString(16);
+	while (s.length < width) {
+		s = "0" + s;
+	}
+	return s;
+}
+
+function heading(parent, tag, text) {
+	parent.appendChild(el(tag, text));
+}
+
+// values is 64 numbers row by row. shade turns a value into a background colour
+function grid(title, values, shade) {
+	const box = el("div");
+	heading(box, "h4", title);
+	const table = el("table", null, "grid");
+	for (let r = 0; r < 8; r++) {
+		const tr = el("tr");
+		for (let c = 0; c < 8; c++) {
+			const v = values[r * 8 + c];
+			const td = el("td", String(v));
+			if (shade) {
+				td.style.background = shade(v);
+			} else if (v !== 0) {
+				td.style.background = "#ffe9a8";
+			}
+			tr.appendChild(td);
+		}
+		table.appendChild(tr);
+	}
+	box.appendChild(table);
+	return box;
+}
+
+function gray(v) {
+	const g = Math.max(0, Math.min(255, v));
+	return "rgb(" + g + "," + g + "," + g + ")";
+}
+
+function grayText(v) {
+	return v < 128 ? "#fff" : "#000";
+}
+
+function clamp(v) {
+	return Math.max(0, Math.min(255, v));
+}
+
+function quantizationTable(id) {
+	for (const q of report.info.quantization_tables) {
+		if (q.id === id) {
+			return [].concat.apply([], q.table);
+		}
+	}
+	return null;
+}
+
+const info = report.info;
+
+function showSummary() {
+	const s = document.getElementById("summary");
+	const f = info.frame;
+	let text = info.size + " bytes, " + f.width + "x" + f.height + ", " + (f.progressive ? "progressive" : "sequential") + ", " + f.components.length + " components";
+	s.appendChild(el("p", text));
+
+	const table = el("table");
+	const head = el("tr");
+	for (const h of ["component", "H", "V", "quantization table"]) {
+		head.appendChild(el("th", h));
+	}
+	table.appendChild(head);
+	for (const c of f.components) {
+		const tr = el("tr");
+		for (const v of [c.id, c.h, c.v, c.tq]) {
+			tr.appendChild(el("td", String(v)));
+		}
+		table.appendChild(tr);
+	}
+	s.appendChild(table);
+
+	if (report.warning) {
+		s.appendChild(el("p", "The image is damaged: " + report.warning, "warning"));
+	}
+}
+
+function segmentColour(marker) {
+	const colours = {SOI: "#555", EOI: "#555", APP: "#2a7ab0", DQT: "#8a4fb0", DHT: "#b0622a", SOF: "#2a9a4f", SOS: "#b02a4a", data: "#d9a400", DRI: "#4f7a7a", COM: "#7a7a4f", trailer: "#999"};
+	for (const k in colours) {
+		if (marker.indexOf(k) === 0) {
+			return colours[k];
+		}
+	}
+	return "#444";
+}
+
+function showLayout() {
+	const bar = document.getElementById("layout");
+	const table = el("table");
+	const head = el("tr");
+	for (const h of ["offset", "marker", "length"]) {
+		head.appendChild(el("th", h));
+	}
+	table.appendChild(head);
+
+	for (const s of info.segments) {
+		const d = el("div", s.marker);
+		d.style.flexGrow = s.length;
+		d.style.background = segmentColour(s.marker);
+		d.title = s.marker + " at 0x" + hex(s.offset, 8) + ", " + s.length + " bytes";
+		bar.appendChild(d);
+
+		const tr = el("tr");
+		tr.appendChild(el("td", "0x" + hex(s.offset, 8)));
+		const name = el("td", s.marker, "left");
+		name.style.color = segmentColour(s.marker);
+		tr.appendChild(name);
+		tr.appendChild(el("td", String(s.length)));
+		table.appendChild(tr);
+	}
+
+	document.getElementById("segments").appendChild(table);
+}
+
+function showQuantization() {
+	const box = document.getElementById("quantization");
+	for (const q of info.quantization_tables) {
+		box.appendChild(grid("Table " + q.id, [].concat.apply([], q.table)));
+	}
+}
+
+function symbolMeaning(table, symbol) {
+	if (table === "DC") {
+		return symbol === 0 ? "difference 0" : "difference of " + symbol + " bits";
+	}
+	if (symbol === 0x00) {
+		return "EOB";
+	}
+	if (symbol === 0xF0) {
+		return "ZRL, 16 zeros";
+	}
+	return "run " + (symbol >> 4) + ", " + (symbol & 15) + " bits";
+}
+
+// Builds the binary tree of a table's codes and shows it as nested details, open for the first few levels
+function showHuffman() {
+	const box = document.getElementById("huffman");
+	for (const h of info.huffman_tables) {
+		const root = {};
+		for (const c of h.codes) {
+			let node = root;
+			for (const bit of c.code) {
+				node[bit] = node[bit] || {};
+				node = node[bit];
+			}
+			node.symbol = c.symbol;
+		}
+
+		heading(box, "h3", h.class + " table " + h.id + ", " + h.codes.length + " codes");
+		box.appendChild(el("p", "BITS " + h.bits.join(" "), "note"));
+		box.appendChild(treeNode(root, "", h.class));
+	}
+}
+
+function treeNode(node, prefix, table) {
+	if (node.symbol !== undefined) {
+		return el("div", prefix + "  → 0x" + hex(node.symbol, 2) + "  " + symbolMeaning(table, node.symbol));
+	}
+
+	const d = el("details");
+	d.open = prefix.length < 3;
+	d.appendChild(el("summary", prefix === "" ? "root" : prefix));
+	for (const bit of ["0", "1"]) {
+		if (node[bit]) {
+			d.appendChild(treeNode(node[bit], prefix + bit, table));
+		} else {
+			d.appendChild(el("div", prefix + bit + "  unused"));
+		}
+	}
+	return d;
+}
+
+// The sample a component gives a pixel, the same upsampling planesToImage does
+function sampleAt(blocks, c, x, y) {
+	const sx = Math.floor(x * c.h / report.h_max);
+	const sy = Math.floor(y * c.v / report.v_max);
+	for (const b of blocks) {
+		if (b.c === c.id && b.row === sy >> 3 && b.col === sx >> 3) {
+			return {block: b, value: clamp(b.idct[(sy & 7) * 8 + (sx & 7)] + 128)};
+		}
+	}
+	return null;
+}
+
+function showBlock(parent, b) {
+	const box = el("div", null, "block");
+	heading(box, "h3", "Component " + b.c + ", block row " + b.row + " column " + b.col);
+
+	const zz = [];
+	for (let k = 0; k < 64; k++) {
+		zz.push(k < b.zigzag.length ? b.zigzag[k] : 0);
+	}
+
+	if (b.symbols) {
+		heading(box, "h4", "Huffman symbols");
+		const table = el("table");
+		const head = el("tr");
+		for (const h of ["k", "table", "code", "extra bits", "symbol", "meaning", "value"]) {
+			head.appendChild(el("th", h));
+		}
+		table.appendChild(head);
+		b.symbols.forEach(function (s, i) {
+			const kind = i === 0 ? "DC" : "AC";
+			const tr = el("tr");
+			tr.appendChild(el("td", String(s[0])));
+			tr.appendChild(el("td", kind, "left"));
+			tr.appendChild(el("td", s[2], "left"));
+			tr.appendChild(el("td", s[3], "left"));
+			tr.appendChild(el("td", "0x" + hex(s[1], 2)));
+			tr.appendChild(el("td", symbolMeaning(kind, s[1]), "left"));
+			tr.appendChild(el("td", String(s[4])));
+			table.appendChild(tr);
+		});
+		box.appendChild(table);
+		box.appendChild(el("p", "DC = prediction " + b.prediction + " + difference " + (zz[0] - b.prediction) + " = " + zz[0] + " (F.2.2.1)"));
+	} else {
+		box.appendChild(el("p", "Progressive files build each block over several scans, so there are no symbols to show.", "note"));
+	}
+
+	const q = quantizationTable(b.q);
+	const zigzagGrid = [];
+	const dequantized = new Array(64).fill(0);
+	for (let k = 0; k < 64; k++) {
+		zigzagGrid.push(zz[k]);
+		dequantized[report.zigzag[k]] = zz[k] * q[report.zigzag[k]];
+	}
+
+	const grids = el("div", null, "grids");
+	grids.appendChild(grid("Coefficients in zig-zag order", zigzagGrid));
+	grids.appendChild(grid("Quantization table " + b.q, q, function () { return ""; }));
+	grids.appendChild(grid("Dequantized and de-zigzagged", dequantized));
+	grids.appendChild(grid("IDCT", b.idct, function () { return ""; }));
+
+	const samples = b.idct.map(function (v) { return clamp(v + 128); });
+	const sampleGrid = grid("Samples, + 128 and clamped", samples, gray);
+	for (const td of sampleGrid.querySelectorAll("td")) {
+		td.style.color = grayText(Number(td.textContent));
+	}
+	grids.appendChild(sampleGrid);
+
+	box.appendChild(grids);
+	parent.appendChild(box);
+}
+
+function showColour(parent, blocks, x, y) {
+	const box = el("div", null, "block");
+	heading(box, "h3", "Colour conversion at " + x + "," + y);
+
+	const comps = info.frame.components;
+	const values = [];
+	for (const c of comps) {
+		const s = sampleAt(blocks, c, x, y);
+		if (!s) {
+			return;
+		}
+		values.push(s);
+		box.appendChild(el("p", "Component " + c.id + ": sample " + s.value + " from block row " + s.block.row + " column " + s.block.col));
+	}
+
+	const canvas = document.getElementById("pixels");
+	const p = canvas.getContext("2d").getImageData(x, y, 1, 1).data;
+
+	if (values.length === 3) {
+		const yy = values[0].value, cb = values[1].value, cr = values[2].value;
+		const lines = [
+			"R = Y + 1.402 (Cr - 128) = " + yy + " + 1.402 * " + (cr - 128) + " = " + (yy + 1.402 * (cr - 128)).toFixed(2),
+			"G = Y - 0.34414 (Cb - 128) - 0.71414 (Cr - 128) = " + (yy - 0.34414 * (cb - 128) - 0.71414 * (cr - 128)).toFixed(2),
+			"B = Y + 1.772 (Cb - 128) = " + (yy + 1.772 * (cb - 128)).toFixed(2),
+		];
+		box.appendChild(el("p", "JFIF YCbCr to RGB, each truncated and clamped to 0-255:", "note"));
+		for (const l of lines) {
+			box.appendChild(el("div", l));
+		}
+	} else {
+		box.appendChild(el("p", "A single component is grey, so R, G and B are all the sample."));
+	}
+
+	const result = el("p", "Decoded pixel: " + p[0] + ", " + p[1] + ", " + p[2] + " ");
+	const swatch = el("span", null, "swatch");
+	swatch.style.background = "rgb(" + p[0] + "," + p[1] + "," + p[2] + ")";
+	result.appendChild(swatch);
+	box.appendChild(result);
+
+	parent.appendChild(box);
+}
+
+function showMCU(x, y) {
+	const col = Math.floor(x / report.mcu_width);
+	const row = Math.floor(y / report.mcu_height);
+	const m = row * report.mcu_cols + col;
+
+	const img = document.getElementById("image");
+	const scale = img.clientWidth / img.naturalWidth;
+	const mark = document.getElementById("mark");
+	mark.style.display = "block";
+	mark.style.left = (col * report.mcu_width * scale - 2) + "px";
+	mark.style.top = (row * report.mcu_height * scale - 2) + "px";
+	mark.style.width = (report.mcu_width * scale) + "px";
+	mark.style.height = (report.mcu_height * scale) + "px";
+
+	const box = document.getElementById("mcu");
+	box.textContent = "";
+	heading(box, "h2", "MCU " + m + ", row " + row + " column " + col + ", pixels " + col * report.mcu_width + "," + row * report.mcu_height);
+
+	const blocks = report.mcus[m];
+	if (!blocks) {
+		box.appendChild(el("p", "This MCU wasn't included in the report.", "note"));
+		return;
+	}
+
+	showColour(box, blocks, x, y);
+	for (const b of blocks) {
+		showBlock(box, b);
+	}
+}
+
+showSummary();
+showLayout();
+showQuantization();
+showHuffman();
+
+const image = document.getElementById("image");
+image.addEventListener("load", function () {
+	const canvas = document.getElementById("pixels");
+	canvas.width = image.naturalWidth;
+	canvas.height = image.naturalHeight;
+	canvas.getContext("2d").drawImage(image, 0, 0);
+});
+if (image.complete) {
+	image.dispatchEvent(new Event("load"));
+}
+
+image.addEventListener("click", function (e) {
+	const x = Math.floor(e.offsetX * image.naturalWidth / image.clientWidth);
+	const y = Math.floor(e.offsetY * image.naturalHeight / image.clientHeight);
+	showMCU(x, y);
+});
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,56 @@
+package jpeg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Linear light, three float32s per pixel with 1.0 being SDR white
+type FloatImage struct {
+	Width  int
+	Height int
+	Pix    []float32
+}
+
+func NewFloatImage(width int, height int) *FloatImage {
+	return &FloatImage{Width: width, Height: height, Pix: make([]float32, width*height*3)}
+}
+
+func (f *FloatImage) Set(x int, y int, r float64, g float64, b float64) {
+	i := (y*f.Width + x) * 3
+	f.Pix[i] = float32(r)
+	f.Pix[i+1] = float32(g)
+	f.Pix[i+2] = float32(b)
+}
+
+func (f *FloatImage) At(x int, y int) (float64, float64, float64) {
+	i := (y*f.Width + x) * 3
+	return float64(f.Pix[i]), float64(f.Pix[i+1]), float64(f.Pix[i+2])
+}
+
+// Portable float map. A text header then little endian float32 RGB, with rows going bottom to top. The negative
+// scale in the header is what marks the data as little endian
+func (f *FloatImage) WritePFM(w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+
+	if _, err := fmt.Fprintf(buffered, "PF\n%d %d\n-1.0\n", f.Width, f.Height); err != nil {
+		return err
+	}
+
+	row := make([]byte, f.Width*3*4)
+
+	for y := f.Height - 1; y >= 0; y-- {
+		for i, v := range f.Pix[y*f.Width*3 : (y+1)*f.Width*3] {
+			binary.LittleEndian.PutUint32(row[i*4:], math.Float32bits(v))
+		}
+
+		if _, err := buffered.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return buffered.Flush()
+}
@@ -0,0 +1,102 @@
+package jpeg
+
+// What the frame and scan headers say about the image's geometry. Shared by the parser and the encoder
+type Frame struct {
+	XLines     int
+	YLines     int
+	Components []*Component
+	// The components in the scan, in scan order. These are the same pointers as in Components
+	ScanComponents []*Component
+}
+
+func (f *Frame) GetComponent(id int) *Component {
+	for _, c := range f.Components {
+		if c.Id == id {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// Largest horizontal and vertical sampling factors in the frame. An MCU is 8*HMax by 8*VMax pixels
+func (f *Frame) MaxSampling() (int, int) {
+	hMax := 1
+	vMax := 1
+
+	for _, c := range f.Components {
+		if c.H > hMax {
+			hMax = c.H
+		}
+		if c.V > vMax {
+			vMax = c.V
+		}
+	}
+
+	return hMax, vMax
+}
+
+func (f *Frame) MCUCols() int {
+	hMax, _ := f.MaxSampling()
+
+	return ceilDiv(f.XLines, 8*hMax)
+}
+
+func (f *Frame) MCURows() int {
+	_, vMax := f.MaxSampling()
+
+	return ceilDiv(f.YLines, 8*vMax)
+}
+
+// Number of blocks per line and per column that actually hold image data for a component (A.1.1). In an
+// interleaved scan the component is padded out to whole MCUs beyond this
+func (f *Frame) ComponentBlocks(c *Component) (int, int) {
+	hMax, vMax := f.MaxSampling()
+
+	x := ceilDiv(f.XLines*c.H, hMax)
+	y := ceilDiv(f.YLines*c.V, vMax)
+
+	return ceilDiv(x, 8), ceilDiv(y, 8)
+}
+
+// A scan with a single component isn't interleaved and each of its MCUs is a single block
+func (f *Frame) ScanMCUs() int {
+	if len(f.ScanComponents) == 1 {
+		cols, rows := f.ComponentBlocks(f.ScanComponents[0])
+		return cols * rows
+	}
+
+	return f.MCUCols() * f.MCURows()
+}
+
+// MCUs across and down the frame. A single component frame has one block per MCU whatever its sampling factors say
+func (f *Frame) frameMCUCols() int {
+	if len(f.Components) == 1 {
+		return ceilDiv(f.XLines, 8)
+	}
+
+	return f.MCUCols()
+}
+
+func (f *Frame) frameMCURows() int {
+	if len(f.Components) == 1 {
+		return ceilDiv(f.YLines, 8)
+	}
+
+	return f.MCURows()
+}
+
+// Pixels across and down a frame MCU
+func (f *Frame) frameMCUSize() (int, int) {
+	if len(f.Components) == 1 {
+		return 8, 8
+	}
+
+	hMax, vMax := f.MaxSampling()
+
+	return 8 * hMax, 8 * vMax
+}
+
+func ceilDiv(a int, b int) int {
+	return (a + b - 1) / b
+}
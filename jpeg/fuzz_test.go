@@ -0,0 +1,97 @@
+package jpeg
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+// The parser panics on malformed data by design. What the fuzzers look for are runtime errors, an index out of
+// range or a nil dereference, which mean some input got past the checks
+
+// Keeps decodes small enough that the fuzzers spend their time on parsing rather than the IDCT
+var fuzzLimits = DecodeLimits{MaxPixels: 256 * 256, MaxScans: 64, MaxMemory: 16 << 20}
+
+func failOnRuntimeError(t *testing.T) {
+	if r := recover(); r != nil {
+		if err, isRuntime := r.(runtime.Error); isRuntime {
+			t.Fatalf("%v\n%s", err, debug.Stack())
+		}
+	}
+}
+
+// spec.jpg and a small crop of it written baseline, baseline with restart markers and progressive
+func addSeeds(f *testing.F) {
+	spec, err := ioutil.ReadFile("../spec.jpg")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(spec)
+
+	j, err := NewJpegParserFromBytes(spec)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	c, err := j.DecodeCoefficients()
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	cropped, err := c.Crop(image.Rect(0, 0, 40, 24))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, opts := range []*EncodeOptions{{}, {RestartInterval: 2}, {Progressive: true}} {
+		e, err := NewEncoderFromCoefficients(cropped, opts)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := e.Write(&buf); err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(buf.Bytes())
+	}
+}
+
+func FuzzJpegParser(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		defer failOnRuntimeError(t)
+
+		NewJpegParserWithLimits(b, &fuzzLimits)
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	addSeeds(f)
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		defer failOnRuntimeError(t)
+
+		j, err := NewJpegParserWithLimits(b, &fuzzLimits)
+		if err != nil {
+			return
+		}
+
+		j.Decode()
+	})
+}
+
+// testdata/fuzz/FuzzJpegParser/49839d265506f88c: an SOS whose length doesn't cover its own length bytes
+func TestFuzzSegmentLengthTooShort(t *testing.T) {
+	defer failOnRuntimeError(t)
+
+	if _, err := NewJpegParserWithLimits([]byte("\xff\xda\x00\x00"), &fuzzLimits); err == nil {
+		t.Error("no error")
+	}
+}
@@ -0,0 +1,309 @@
+package jpeg
+
+import (
+	"errors"
+	"image"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Ultra HDR (Android) and Adobe gain map JPEGs. The primary image is an ordinary SDR JPEG. A second JPEG, found through
+// MPF, holds a gain map: per pixel log2 ratios between the HDR and SDR renditions. The gain map's XMP carries the
+// hdrgm metadata needed to turn its samples back into ratios. See the Adobe gain map spec 1.0 and the Android
+// Ultra HDR image format 1.0
+
+const hdrgmNamespace = "http://ns.adobe.com/hdr-gain-map/1.0/"
+
+// Values are per channel. Files with a single value have it copied to all three. The min, max and capacity values
+// are log2 as stored in the XMP
+type GainMapMetadata struct {
+	Version            string
+	GainMapMin         [3]float64
+	GainMapMax         [3]float64
+	Gamma              [3]float64
+	OffsetSDR          [3]float64
+	OffsetHDR          [3]float64
+	HDRCapacityMin     float64
+	HDRCapacityMax     float64
+	BaseRenditionIsHDR bool
+}
+
+type UltraHdr struct {
+	Primary  *MpImage
+	GainMap  *MpImage
+	Metadata *GainMapMetadata
+}
+
+// Finds the gain map in an MPF file and reads its metadata. Returns nil if the file isn't a gain map JPEG
+func ReadUltraHdr(b []byte) (*UltraHdr, error) {
+	images, err := ReadMPF(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(images) < 2 {
+		return nil, nil
+	}
+
+	// The gain map is the first secondary image that has hdrgm metadata
+	for _, m := range images[1:] {
+		x, err := ReadXMP(m.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		if x == nil || !strings.Contains(string(x.Packet), hdrgmNamespace) {
+			continue
+		}
+
+		metadata, err := ParseGainMapMetadata(x.Packet)
+		if err != nil {
+			return nil, err
+		}
+
+		return &UltraHdr{Primary: images[0], GainMap: m, Metadata: metadata}, nil
+	}
+
+	return nil, nil
+}
+
+// hdrgm values show up either as attributes, in either kind of quotes, as plain elements or as an rdf:Seq of one
+// value per channel. RE2 has no backreferences, so each kind of quote gets its own group
+func xmpHdrgmValues(packet string, name string) []string {
+	attribute := regexp.MustCompile(`hdrgm:` + name + `=(?:"([^"]*)"|'([^']*)')`)
+	if m := attribute.FindStringSubmatch(packet); m != nil {
+		return []string{m[1] + m[2]}
+	}
+
+	element := regexp.MustCompile(`(?s)<hdrgm:` + name + `>(.*?)</hdrgm:` + name + `>`)
+	m := element.FindStringSubmatch(packet)
+	if m == nil {
+		return nil
+	}
+
+	items := regexp.MustCompile(`<rdf:li>([^<]*)</rdf:li>`).FindAllStringSubmatch(m[1], -1)
+	if items == nil {
+		return []string{strings.TrimSpace(m[1])}
+	}
+
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		values = append(values, strings.TrimSpace(item[1]))
+	}
+
+	return values
+}
+
+func xmpHdrgmFloats(packet string, name string, defaultValue float64, required bool) ([3]float64, error) {
+	out := [3]float64{defaultValue, defaultValue, defaultValue}
+
+	values := xmpHdrgmValues(packet, name)
+
+	if values == nil {
+		if required {
+			return out, errors.New("gain map metadata is missing hdrgm:" + name)
+		}
+		return out, nil
+	}
+
+	if len(values) != 1 && len(values) != 3 {
+		return out, errors.New("hdrgm:" + name + " needs one or three values")
+	}
+
+	for i := 0; i < 3; i++ {
+		v, err := strconv.ParseFloat(values[i%len(values)], 64)
+		if err != nil {
+			return out, err
+		}
+		out[i] = v
+	}
+
+	return out, nil
+}
+
+// Defaults are from table 2 of the Adobe gain map spec
+func ParseGainMapMetadata(packet []byte) (*GainMapMetadata, error) {
+	p := string(packet)
+
+	version := xmpHdrgmValues(p, "Version")
+	if version == nil {
+		return nil, errors.New("gain map metadata is missing hdrgm:Version")
+	}
+
+	m := &GainMapMetadata{Version: version[0]}
+
+	var err error
+	var capacityMin, capacityMax [3]float64
+
+	fields := []struct {
+		name         string
+		target       *[3]float64
+		defaultValue float64
+		required     bool
+	}{
+		{"GainMapMin", &m.GainMapMin, 0, false},
+		{"GainMapMax", &m.GainMapMax, 0, true},
+		{"Gamma", &m.Gamma, 1, false},
+		{"OffsetSDR", &m.OffsetSDR, 1.0 / 64, false},
+		{"OffsetHDR", &m.OffsetHDR, 1.0 / 64, false},
+		{"HDRCapacityMin", &capacityMin, 0, false},
+		{"HDRCapacityMax", &capacityMax, 0, true},
+	}
+
+	for _, f := range fields {
+		*f.target, err = xmpHdrgmFloats(p, f.name, f.defaultValue, f.required)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Gamma divides the exponent the gain map samples are raised to
+	for _, g := range m.Gamma {
+		if !(g > 0) || math.IsInf(g, 1) {
+			return nil, errors.New("hdrgm:Gamma has to be positive")
+		}
+	}
+
+	m.HDRCapacityMin = capacityMin[0]
+	m.HDRCapacityMax = capacityMax[0]
+
+	if base := xmpHdrgmValues(p, "BaseRenditionIsHDR"); base != nil {
+		m.BaseRenditionIsHDR = strings.EqualFold(base[0], "True")
+	}
+
+	return m, nil
+}
+
+// The sRGB EOTF, taking an 8 bit code value to linear light
+func srgbToLinear(v uint8) float64 {
+	c := float64(v) / 255
+
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// How much of the gain map to apply for a display that can show displayBoost times SDR white (section 3.2.2)
+func (m *GainMapMetadata) Weight(displayBoost float64) float64 {
+	if m.HDRCapacityMax <= m.HDRCapacityMin {
+		if math.Log2(displayBoost) >= m.HDRCapacityMax {
+			return 1
+		}
+		return 0
+	}
+
+	w := (math.Log2(displayBoost) - m.HDRCapacityMin) / (m.HDRCapacityMax - m.HDRCapacityMin)
+
+	return math.Max(0, math.Min(1, w))
+}
+
+// Decodes both images and applies the gain map to the SDR primary to get the HDR rendition for a display with the
+// given boost. A boost of 1 gives the SDR image back in linear light
+func (u *UltraHdr) DecodeHDR(displayBoost float64) (*FloatImage, error) {
+	if u.Metadata.BaseRenditionIsHDR {
+		return nil, errors.New("gain maps on an HDR base rendition aren't supported")
+	}
+
+	primary, err := u.Primary.Parser()
+	if err != nil {
+		return nil, err
+	}
+
+	sdr, err := primary.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	gainMapParser, err := u.GainMap.Parser()
+	if err != nil {
+		return nil, err
+	}
+
+	gainMap, err := gainMapParser.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	return ApplyGainMap(sdr, gainMap, u.Metadata, displayBoost), nil
+}
+
+func ApplyGainMap(sdr *image.RGBA, gainMap *image.RGBA, m *GainMapMetadata, displayBoost float64) *FloatImage {
+	width := sdr.Bounds().Dx()
+	height := sdr.Bounds().Dy()
+
+	out := NewFloatImage(width, height)
+
+	weight := m.Weight(displayBoost)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := sdr.RGBAAt(x, y)
+			sdrValues := [3]float64{srgbToLinear(pixel.R), srgbToLinear(pixel.G), srgbToLinear(pixel.B)}
+
+			gains := sampleGainMap(gainMap, x, y, width, height)
+
+			hdr := [3]float64{}
+
+			for c := 0; c < 3; c++ {
+				recovery := math.Pow(gains[c], 1/m.Gamma[c])
+				logBoost := m.GainMapMin[c]*(1-recovery) + m.GainMapMax[c]*recovery
+
+				hdr[c] = (sdrValues[c]+m.OffsetSDR[c])*math.Exp2(logBoost*weight) - m.OffsetHDR[c]
+			}
+
+			out.Set(x, y, hdr[0], hdr[1], hdr[2])
+		}
+	}
+
+	return out
+}
+
+// The gain map is usually smaller than the primary so it's upsampled bilinearly. Grayscale gain maps come out of the
+// decoder with equal channels so they need no special case
+func sampleGainMap(gainMap *image.RGBA, x int, y int, width int, height int) [3]float64 {
+	gw := gainMap.Bounds().Dx()
+	gh := gainMap.Bounds().Dy()
+
+	gx := math.Max(0, (float64(x)+0.5)*float64(gw)/float64(width)-0.5)
+	gy := math.Max(0, (float64(y)+0.5)*float64(gh)/float64(height)-0.5)
+
+	x0 := int(gx)
+	y0 := int(gy)
+	x1 := x0 + 1
+	y1 := y0 + 1
+
+	if x1 >= gw {
+		x1 = gw - 1
+	}
+	if y1 >= gh {
+		y1 = gh - 1
+	}
+
+	fx := gx - float64(x0)
+	fy := gy - float64(y0)
+
+	out := [3]float64{}
+
+	corners := [4]struct {
+		x, y   int
+		weight float64
+	}{
+		{x0, y0, (1 - fx) * (1 - fy)},
+		{x1, y0, fx * (1 - fy)},
+		{x0, y1, (1 - fx) * fy},
+		{x1, y1, fx * fy},
+	}
+
+	for _, corner := range corners {
+		p := gainMap.RGBAAt(corner.x, corner.y)
+		out[0] += corner.weight * float64(p.R) / 255
+		out[1] += corner.weight * float64(p.G) / 255
+		out[2] += corner.weight * float64(p.B) / 255
+	}
+
+	return out
+}
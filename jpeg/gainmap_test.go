@@ -0,0 +1,121 @@
+package jpeg
+
+import (
+	"encoding/binary"
+	"image"
+	"math"
+	"strings"
+	"testing"
+)
+
+const testGainMapXmp = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:hdrgm="http://ns.adobe.com/hdr-gain-map/1.0/" hdrgm:Version='1.0' hdrgm:GainMapMax="2"
+ hdrgm:OffsetSDR='0' hdrgm:OffsetHDR="0" %s>
+<hdrgm:HDRCapacityMax>2</hdrgm:HDRCapacityMax>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+// A flat white primary with a flat gain map after it, found through MPF
+func testUltraHdr(t *testing.T, gain uint8, extra string) []byte {
+	flat := func(size int, value uint8) image.Image {
+		img := image.NewGray(image.Rect(0, 0, size, size))
+		for i := range img.Pix {
+			img.Pix[i] = value
+		}
+		return img
+	}
+
+	primary := testEncode(t, flat(16, 255), &EncodeOptions{Quality: 100})
+	gainMap := testEncode(t, flat(8, gain), &EncodeOptions{Quality: 100})
+
+	// Quality 100 keeps flat images exact, which the values below rely on
+	if p := testDecode(t, gainMap).RGBAAt(3, 3); p.R != gain || testDecode(t, primary).RGBAAt(3, 3).R != 255 {
+		t.Fatalf("gain map decodes to %v", p)
+	}
+
+	xmp := append(append([]byte{}, xmpIdentifier...), strings.Replace(testGainMapXmp, "%s", extra, 1)...)
+	gainMap = append(append(append([]byte{}, gainMap[:2]...), testSegment(MARKER_EXIF, xmp)...), gainMap[2:]...)
+
+	start := len(buildMpf(binary.BigEndian, primary, make([]testMpEntry, 2)))
+
+	return buildMpf(binary.BigEndian, primary, []testMpEntry{
+		{MP_TYPE_BASELINE_PRIMARY, start, 0},
+		{MP_TYPE_UNDEFINED, len(gainMap), start - testMpfBase},
+	}, gainMap)
+}
+
+// With no offsets, white is 1.0 in linear light and comes out as 1.0 * 2^(logBoost * weight)
+func TestUltraHdrLinearOutput(t *testing.T) {
+	cases := []struct {
+		name  string
+		gain  uint8
+		extra string
+		boost float64
+		want  float64
+	}{
+		// GainMapMax of 2 and a full weight quadruple it
+		{"full gain", 255, "", 4, 4},
+		// The display only takes half of HDRCapacityMax, so half the log boost
+		{"half weight", 255, "", 2, 2},
+		{"SDR display", 255, "", 1, 1},
+		{"no gain", 0, "", 4, 1},
+		// A gain of 64/255 with a gamma of 0.5 is squared first
+		{"gamma", 64, `hdrgm:Gamma='0.5'`, 4, math.Exp2(2 * (64.0 / 255) * (64.0 / 255))},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			u, err := ReadUltraHdr(testUltraHdr(t, c.gain, c.extra))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u == nil {
+				t.Fatal("no gain map found")
+			}
+
+			hdr, err := u.DecodeHDR(c.boost)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if hdr.Width != 16 || hdr.Height != 16 {
+				t.Fatalf("got %dx%d", hdr.Width, hdr.Height)
+			}
+
+			for _, p := range []image.Point{{0, 0}, {7, 9}, {15, 15}} {
+				r, g, b := hdr.At(p.X, p.Y)
+				for _, v := range []float64{r, g, b} {
+					if math.Abs(v-c.want) > 1e-5 {
+						t.Fatalf("%v is %v %v %v, want %v", p, r, g, b, c.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGainMapMetadataGamma(t *testing.T) {
+	cases := []struct {
+		extra string
+		err   bool
+	}{
+		{`hdrgm:Gamma="1.5"`, false},
+		{`hdrgm:Gamma='0'`, true},
+		{`hdrgm:Gamma="-1"`, true},
+		{`hdrgm:Gamma="NaN"`, true},
+		{`hdrgm:Gamma="+Inf"`, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.extra, func(t *testing.T) {
+			m, err := ParseGainMapMetadata([]byte(strings.Replace(testGainMapXmp, "%s", c.extra, 1)))
+			if (err != nil) != c.err {
+				t.Fatalf("got %v", err)
+			}
+
+			// The single quoted attributes are read too
+			if err == nil && (m.Version != "1.0" || m.Gamma[1] != 1.5 || m.GainMapMax[2] != 2 || m.HDRCapacityMax != 2) {
+				t.Errorf("got %+v", m)
+			}
+		})
+	}
+}
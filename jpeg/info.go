@@ -0,0 +1,291 @@
+package jpeg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"huffman"
+)
+
+// A description of everything in a file short of the pixels: the marker layout, the tables as the parser built
+// them and how each scan's data splits into restart intervals. The JSON field names are what the info command
+// prints
+
+type FileInfo struct {
+	Size     int           `json:"size"`
+	Segments []SegmentInfo `json:"segments"`
+	// nil when there's no JFIF segment
+	JFIF               *JfifHeader             `json:"jfif,omitempty"`
+	Frame              FrameInfo               `json:"frame"`
+	QuantizationTables []QuantizationTableInfo `json:"quantization_tables"`
+	HuffmanTables      []HuffmanTableInfo      `json:"huffman_tables"`
+	Scans              []ScanInfo              `json:"scans"`
+}
+
+type SegmentInfo struct {
+	// Table B.1 name, or "data" for the entropy coded data after an SOS and "trailer" for anything after EOI
+	Marker string `json:"marker"`
+	Offset int    `json:"offset"`
+	// The whole segment, marker and length included
+	Length int `json:"length"`
+}
+
+type FrameInfo struct {
+	Width       int             `json:"width"`
+	Height      int             `json:"height"`
+	Progressive bool            `json:"progressive"`
+	MCUCols     int             `json:"mcu_cols"`
+	MCURows     int             `json:"mcu_rows"`
+	Components  []ComponentInfo `json:"components"`
+}
+
+type ComponentInfo struct {
+	Id int `json:"id"`
+	H  int `json:"h"`
+	V  int `json:"v"`
+	Tq int `json:"tq"`
+}
+
+type QuantizationTableInfo struct {
+	Id int `json:"id"`
+	// Natural order, row by row
+	Table [8][8]int `json:"table"`
+}
+
+type HuffmanTableInfo struct {
+	Class   string        `json:"class"`
+	Id      int           `json:"id"`
+	Bits    [16]int       `json:"bits"`
+	HuffVal []int         `json:"huffval"`
+	Codes   []HuffmanCode `json:"codes"`
+}
+
+// One entry of the code built by figures C.1 and C.2
+type HuffmanCode struct {
+	Symbol int `json:"symbol"`
+	// The code as bits, most significant first
+	Code string `json:"code"`
+}
+
+type ScanInfo struct {
+	Offset          int                 `json:"offset"`
+	Components      []ScanComponentInfo `json:"components"`
+	Ss              int                 `json:"ss"`
+	Se              int                 `json:"se"`
+	Ah              int                 `json:"ah"`
+	Al              int                 `json:"al"`
+	DataOffset      int                 `json:"data_offset"`
+	DataLength      int                 `json:"data_length"`
+	MCUs            int                 `json:"mcus"`
+	RestartInterval int                 `json:"restart_interval"`
+	Intervals       []IntervalInfo      `json:"intervals"`
+}
+
+type ScanComponentInfo struct {
+	Id int `json:"id"`
+	Td int `json:"td"`
+	Ta int `json:"ta"`
+}
+
+type IntervalInfo struct {
+	MCUOffset int `json:"mcu_offset"`
+	MCUs      int `json:"mcus"`
+	// Bytes of entropy coded data, stuffing included and the restart marker left out
+	Length  int  `json:"length"`
+	Damaged bool `json:"damaged,omitempty"`
+}
+
+func (j *JpegParser) Info() *FileInfo {
+	info := &FileInfo{
+		Size:               int(j.ByteReader.Size()),
+		Segments:           make([]SegmentInfo, 0),
+		JFIF:               j.JFIF,
+		QuantizationTables: make([]QuantizationTableInfo, 0),
+		HuffmanTables:      make([]HuffmanTableInfo, 0),
+		Scans:              make([]ScanInfo, 0),
+	}
+
+	info.Segments = append(info.Segments, SegmentInfo{Marker: "SOI", Offset: 0, Length: 2})
+
+	scanIndex := 0
+
+	for _, s := range j.Segments {
+		info.Segments = append(info.Segments, SegmentInfo{Marker: MarkerName(s.Type), Offset: s.Offset, Length: len(s.Body) + 4})
+
+		if s.Type == MARKER_SOS && scanIndex < len(j.Scans) {
+			scan := j.Scans[scanIndex]
+			info.Segments = append(info.Segments, SegmentInfo{Marker: "data", Offset: scanDataOffset(scan), Length: len(scan.Body)})
+			scanIndex++
+		}
+	}
+
+	if !j.Truncated {
+		rawBytes := make([]byte, j.ByteReader.Size())
+		j.ByteReader.ReadAt(rawBytes, 0)
+
+		// The parser has already been through the file so this only fails on something it let past
+		if l, err := ReadSegmentList(rawBytes); err == nil {
+			eoi := len(rawBytes) - len(l.Trailer) - 2
+			info.Segments = append(info.Segments, SegmentInfo{Marker: "EOI", Offset: eoi, Length: 2})
+
+			if len(l.Trailer) > 0 {
+				info.Segments = append(info.Segments, SegmentInfo{Marker: "trailer", Offset: eoi + 2, Length: len(l.Trailer)})
+			}
+		}
+	}
+
+	info.Frame = FrameInfo{
+		Width:       j.XLines,
+		Height:      j.YLines,
+		Progressive: j.Progressive,
+		MCUCols:     j.MCUCols(),
+		MCURows:     j.MCURows(),
+		Components:  make([]ComponentInfo, 0),
+	}
+
+	for _, c := range j.Components {
+		info.Frame.Components = append(info.Frame.Components, ComponentInfo{Id: c.Id, H: c.H, V: c.V, Tq: c.Tq})
+	}
+
+	for id := 0; id < 16; id++ {
+		table, present := j.QuantizationTables[id]
+		if !present {
+			continue
+		}
+
+		t := QuantizationTableInfo{Id: id}
+		for i, q := range naturalOrder(table) {
+			t.Table[i/8][i%8] = q
+		}
+
+		info.QuantizationTables = append(info.QuantizationTables, t)
+	}
+
+	for _, h := range j.HuffmanReaders {
+		info.HuffmanTables = append(info.HuffmanTables, huffmanTableInfo(h))
+	}
+
+	for _, scan := range j.Scans {
+		info.Scans = append(info.Scans, j.scanInfo(scan))
+	}
+
+	return info
+}
+
+// The data starts after the SOS marker, its length and its body
+func scanDataOffset(scan *Scan) int {
+	return scan.Offset + 4 + len(scan.Header)
+}
+
+func huffmanTableInfo(h *huffman.HuffmanReader) HuffmanTableInfo {
+	t := HuffmanTableInfo{Class: tableClassName(h.Target), Id: h.Identifier, HuffVal: h.HuffVal, Codes: make([]HuffmanCode, 0)}
+
+	for i := 1; i <= 16; i++ {
+		t.Bits[i-1] = h.Bits[i]
+	}
+
+	for k, symbol := range h.HuffVal {
+		code := fmt.Sprintf("%0*b", h.HuffSize[k], h.HuffCode[k])
+		t.Codes = append(t.Codes, HuffmanCode{Symbol: symbol, Code: code})
+	}
+
+	return t
+}
+
+func (j *JpegParser) scanInfo(scan *Scan) ScanInfo {
+	j.ActivateScan(scan)
+
+	s := ScanInfo{
+		Offset:          scan.Offset,
+		Components:      make([]ScanComponentInfo, 0),
+		Ss:              scan.Ss,
+		Se:              scan.Se,
+		Ah:              scan.Ah,
+		Al:              scan.Al,
+		DataOffset:      scanDataOffset(scan),
+		DataLength:      len(scan.Body),
+		MCUs:            j.ScanMCUs(),
+		RestartInterval: scan.RestartInterval,
+		Intervals:       make([]IntervalInfo, 0),
+	}
+
+	for i, c := range scan.Components {
+		s.Components = append(s.Components, ScanComponentInfo{Id: c.Id, Td: scan.Td[i], Ta: scan.Ta[i]})
+	}
+
+	for _, interval := range scan.Intervals {
+		s.Intervals = append(s.Intervals, IntervalInfo{MCUOffset: interval.MCUOffset, MCUs: interval.MCUs, Length: len(interval.Body), Damaged: interval.Damaged})
+	}
+
+	return s
+}
+
+func (info *FileInfo) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(info)
+}
+
+// The same tree as the JSON, indented
+func (info *FileInfo) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%d bytes\n", info.Size)
+
+	fmt.Fprintf(&b, "Segments\n")
+	for _, s := range info.Segments {
+		fmt.Fprintf(&b, "  0x%08x  %-7s %d bytes\n", s.Offset, s.Marker, s.Length)
+	}
+
+	if info.JFIF != nil {
+		fmt.Fprintf(&b, "JFIF %d.%02d, units %d, density %dx%d\n", info.JFIF.MajorVersion, info.JFIF.MinorVersion, info.JFIF.Units, info.JFIF.XDensity, info.JFIF.YDensity)
+	}
+
+	f := info.Frame
+	fmt.Fprintf(&b, "Frame %dx%d, progressive %v, %dx%d MCUs\n", f.Width, f.Height, f.Progressive, f.MCUCols, f.MCURows)
+	for _, c := range f.Components {
+		fmt.Fprintf(&b, "  component %d: sampling %dx%d, quantization table %d\n", c.Id, c.H, c.V, c.Tq)
+	}
+
+	for _, q := range info.QuantizationTables {
+		fmt.Fprintf(&b, "Quantization table %d\n", q.Id)
+		for _, row := range q.Table {
+			b.WriteString(" ")
+			for _, v := range row {
+				fmt.Fprintf(&b, " %3d", v)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	for _, h := range info.HuffmanTables {
+		fmt.Fprintf(&b, "Huffman table %s %d\n", h.Class, h.Id)
+		fmt.Fprintf(&b, "  BITS %v\n", h.Bits)
+		for _, c := range h.Codes {
+			fmt.Fprintf(&b, "  0x%02x  %s\n", c.Symbol, c.Code)
+		}
+	}
+
+	for i, s := range info.Scans {
+		fmt.Fprintf(&b, "Scan %d at 0x%08x: Ss %d, Se %d, Ah %d, Al %d\n", i, s.Offset, s.Ss, s.Se, s.Ah, s.Al)
+		for _, c := range s.Components {
+			fmt.Fprintf(&b, "  component %d: DC table %d, AC table %d\n", c.Id, c.Td, c.Ta)
+		}
+
+		fmt.Fprintf(&b, "  %d bytes of data at 0x%08x, %d MCUs, restart interval %d\n", s.DataLength, s.DataOffset, s.MCUs, s.RestartInterval)
+		for _, interval := range s.Intervals {
+			damaged := ""
+			if interval.Damaged {
+				damaged = ", damaged"
+			}
+			fmt.Fprintf(&b, "  interval MCUs %d-%d: %d bytes%s\n", interval.MCUOffset, interval.MCUOffset+interval.MCUs-1, interval.Length, damaged)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
@@ -0,0 +1,97 @@
+package jpeg
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	b, _ := testRestartFile(t)
+	b = append(b, "trailer"...)
+
+	j, err := NewJpegParserFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := j.Info()
+
+	var buf bytes.Buffer
+	if err := info.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded FileInfo
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(&decoded, info) {
+		t.Errorf("the JSON reads back as\n%+v\nnot\n%+v", decoded, *info)
+	}
+
+	// The segments cover the whole file with no gaps, and each starts with the marker it's named for
+	offset := 0
+	var data, trailer *SegmentInfo
+	for i, s := range info.Segments {
+		if s.Offset != offset {
+			t.Fatalf("%s segment at 0x%x, the one before ends at 0x%x", s.Marker, s.Offset, offset)
+		}
+		offset += s.Length
+
+		switch s.Marker {
+		case "data":
+			data = &info.Segments[i]
+		case "trailer":
+			trailer = &info.Segments[i]
+		default:
+			if b[s.Offset] != 0xFF || MarkerName(b[s.Offset+1]) != s.Marker {
+				t.Errorf("%s segment at 0x%x starts with % x", s.Marker, s.Offset, b[s.Offset:s.Offset+2])
+			}
+		}
+	}
+
+	if offset != len(b) || info.Size != len(b) {
+		t.Errorf("segments end at %d of %d bytes, size %d", offset, len(b), info.Size)
+	}
+	if trailer == nil || trailer.Length != len("trailer") {
+		t.Errorf("trailer is %+v", trailer)
+	}
+
+	scan := info.Scans[0]
+	if data == nil || scan.DataOffset != data.Offset || scan.DataLength != data.Length {
+		t.Errorf("scan data at 0x%x for %d bytes, the segments have %+v", scan.DataOffset, scan.DataLength, data)
+	}
+	if scan.MCUs != 64 || scan.RestartInterval != 8 || len(scan.Intervals) != 8 || scan.Intervals[7].MCUOffset != 56 {
+		t.Errorf("scan is %+v", scan)
+	}
+
+	want := naturalOrder(ScaleQuantizationTable(AnnexKLuminanceQuantization, DEFAULT_QUALITY))
+	for i, q := range want {
+		if info.QuantizationTables[0].Table[i/8][i%8] != q {
+			t.Fatalf("quantization table 0 is %v", info.QuantizationTables[0].Table)
+		}
+	}
+
+	// Table K.3
+	dcCodes := []string{"00", "010", "011", "100", "101", "110", "1110", "11110", "111110", "1111110", "11111110", "111111110"}
+
+	var dc *HuffmanTableInfo
+	for i, h := range info.HuffmanTables {
+		if h.Class == "DC" && h.Id == 0 {
+			dc = &info.HuffmanTables[i]
+		}
+	}
+
+	if dc == nil || len(dc.Codes) != len(dcCodes) {
+		t.Fatalf("DC table 0 is %+v", dc)
+	}
+
+	for _, c := range dc.Codes {
+		if c.Code != dcCodes[c.Symbol] {
+			t.Errorf("category %d has code %s, not %s", c.Symbol, c.Code, dcCodes[c.Symbol])
+		}
+	}
+}
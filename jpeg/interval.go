@@ -9,8 +9,16 @@ import (
 type Interval struct {
 	Body []byte
 	// MCU offset
-	MCUOffset   int
-	MCUs        int
+	MCUOffset int
+	MCUs      int
+	// Set when the restart markers say this interval should be here but its data couldn't be found
+	Damaged bool
+	// Where Body starts in the scan's entropy coded data
+	DataOffset int
+	// How many MCUs the last decode got through
+	decodedMCUs int
+	// Bits handed out since the start of the interval, stuffed zero bytes left out
+	bitsRead    int
 	byteOffset  int
 	bitCount    int
 	workingByte byte
@@ -20,6 +28,37 @@ func NewInterval(b []byte, o int, m int) *Interval {
 	return &Interval{Body: b, MCUOffset: o, MCUs: m, byteOffset: -1}
 }
 
+func newDamagedInterval(o int, m int) *Interval {
+	i := NewInterval(nil, o, m)
+	i.Damaged = true
+	return i
+}
+
+// Back to the first bit so the interval can be decoded again
+func (i *Interval) Reset() {
+	i.byteOffset = -1
+	i.bitCount = 0
+	i.workingByte = 0
+	i.decodedMCUs = 0
+	i.bitsRead = 0
+}
+
+// How many bits the decode has used so far. The difference across a block is what it cost
+func (i *Interval) BitsRead() int {
+	return i.bitsRead
+}
+
+// What's left once the MCUs are decoded: whole bytes not read at all, and the unread low bits of the current byte
+// along with how many of them there are
+func (i *Interval) leftover() (int, byte, int) {
+	return len(i.Body) - 1 - i.byteOffset, i.workingByte >> uint(8-i.bitCount), i.bitCount
+}
+
+// Where in Body the decode has got to. The byte the last bit came from, or 0 before anything is read
+func (i *Interval) position() int {
+	return maxInt(i.byteOffset, 0)
+}
+
 // Figure F.18
 
 func (i *Interval) NextBit() (byte, error) {
@@ -53,6 +92,7 @@ func (i *Interval) NextBit() (byte, error) {
 
 	bit := i.workingByte >> 7
 	i.bitCount -= 1
+	i.bitsRead += 1
 	i.workingByte <<= 1
 
 	//fmt.Printf("end of nextbit and returning %d\n", bit)
@@ -76,3 +116,7 @@ func (i *Interval) NextBits(numBits int) int {
 	return ret
 
 }
+
+func (i *Interval) PrintDebug() {
+	fmt.Printf("byteOffset: %d, bitCount: %d, workingByte: %08b\n", i.byteOffset, i.bitCount, i.workingByte)
+}
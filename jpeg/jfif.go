@@ -0,0 +1,167 @@
+package jpeg
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// JFIF density units
+	JFIF_UNITS_NONE int = 0 // X and Y density only give the pixel aspect ratio
+	JFIF_UNITS_INCH int = 1
+	JFIF_UNITS_CM   int = 2
+)
+
+// The fixed part of the JFIF APP0 segment from the JFIF 1.02 spec. The thumbnail that can follow is handled in
+// thumbnail.go
+type JfifHeader struct {
+	MajorVersion int `json:"major_version"`
+	MinorVersion int `json:"minor_version"`
+	Units        int `json:"units"`
+	XDensity     int `json:"x_density"`
+	YDensity     int `json:"y_density"`
+}
+
+// What DecodeConfig reports. The embedded image.Config is what the standard library's DecodeConfig would give
+type Config struct {
+	image.Config
+	Components int
+	// nil when there isn't a JFIF segment
+	JFIF *JfifHeader
+}
+
+func ParseJfifHeader(body []byte) (*JfifHeader, error) {
+	if !bytes.HasPrefix(body, jfifIdentifier) {
+		return nil, errors.New("not a JFIF segment")
+	}
+
+	offset := len(jfifIdentifier)
+
+	if len(body) < offset+7 {
+		return nil, errors.New("JFIF segment too short")
+	}
+
+	return &JfifHeader{
+		MajorVersion: int(body[offset]),
+		MinorVersion: int(body[offset+1]),
+		Units:        int(body[offset+2]),
+		XDensity:     int(body[offset+3])<<8 | int(body[offset+4]),
+		YDensity:     int(body[offset+5])<<8 | int(body[offset+6]),
+	}, nil
+}
+
+// Density converted to dots per inch. ok is false when the header only carries an aspect ratio
+func (h *JfifHeader) DPI() (x float64, y float64, ok bool) {
+	switch h.Units {
+	case JFIF_UNITS_INCH:
+		return float64(h.XDensity), float64(h.YDensity), true
+	case JFIF_UNITS_CM:
+		return float64(h.XDensity) * 2.54, float64(h.YDensity) * 2.54, true
+	}
+
+	return 0, 0, false
+}
+
+// Width of a pixel divided by its height. Densities are pixels per unit so a lower X density means wider pixels
+func (h *JfifHeader) PixelAspectRatio() float64 {
+	if h.XDensity == 0 || h.YDensity == 0 {
+		return 1
+	}
+
+	return float64(h.YDensity) / float64(h.XDensity)
+}
+
+func findJfifHeader(segments []*Section) (*JfifHeader, error) {
+	for _, s := range segments {
+		if s.Type == MARKER_JFIF && bytes.HasPrefix(s.Body, jfifIdentifier) {
+			return ParseJfifHeader(s.Body)
+		}
+	}
+
+	return nil, nil
+}
+
+// A JFIF segment that's too short to read leaves JFIF nil, the same as a file without one, since nothing else
+// in the file depends on it. JFIFError says why
+func (j *JpegParser) ParseJfif() {
+	j.JFIF, j.JFIFError = findJfifHeader(j.Segments)
+}
+
+// Reads only the marker segments ahead of the scan, so the image size and JFIF fields are available without
+// decoding anything
+func DecodeConfig(r io.Reader) (Config, error) {
+	rawBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Config{}, err
+	}
+
+	segments, err := ReadHeaderSegments(rawBytes)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config := Config{}
+	config.ColorModel = color.RGBAModel
+
+	sofFound := false
+
+	for _, s := range segments {
+		if s.Type != MARKER_SOF0 && s.Type != MARKER_SOF1 && s.Type != MARKER_SOF2 {
+			continue
+		}
+
+		if len(s.Body) < 6 {
+			return Config{}, errors.New("SOF segment too short")
+		}
+
+		config.Height = int(s.Body[1])<<8 | int(s.Body[2])
+		config.Width = int(s.Body[3])<<8 | int(s.Body[4])
+		config.Components = int(s.Body[5])
+		sofFound = true
+	}
+
+	if !sofFound {
+		return Config{}, errors.New("no start of frame before the scan")
+	}
+
+	// A broken JFIF segment doesn't stop a decode, so it doesn't stop this either
+	config.JFIF, _ = findJfifHeader(segments)
+
+	return config, nil
+}
+
+// Stretches the image so its pixels come out square. The axis with the lower density is the one that gets
+// stretched, so nothing is thrown away. Nearest neighbour, like the chroma upsampling
+func applyAspectRatio(img *image.RGBA, header *JfifHeader) *image.RGBA {
+	ratio := header.PixelAspectRatio()
+
+	if ratio == 1 {
+		return img
+	}
+
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+
+	outWidth := width
+	outHeight := height
+
+	if ratio > 1 {
+		outWidth = int(float64(width)*ratio + 0.5)
+	} else {
+		outHeight = int(float64(height)/ratio + 0.5)
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, outWidth, outHeight))
+
+	for y := 0; y < outHeight; y++ {
+		for x := 0; x < outWidth; x++ {
+			out.Set(x, y, img.At(x*width/outWidth, y*height/outHeight))
+		}
+	}
+
+	return out
+}
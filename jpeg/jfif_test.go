@@ -0,0 +1,65 @@
+package jpeg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// spec.jpg with its JFIF APP0 swapped for one with the given body
+func withJfifBody(t *testing.T, body []byte) []byte {
+	spec, err := ioutil.ReadFile("../spec.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if spec[2] != 0xFF || spec[3] != MARKER_JFIF {
+		t.Fatal("spec.jpg doesn't start with APP0")
+	}
+
+	rest := 4 + (int(spec[4])<<8 | int(spec[5]))
+
+	b := append([]byte{0xFF, MARKER_SOI}, testSegment(MARKER_JFIF, body)...)
+
+	return append(b, spec[rest:]...)
+}
+
+func TestParseJfif(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   []byte
+		header *JfifHeader
+	}{
+		{"valid", []byte("JFIF\x00\x01\x02\x01\x00\x48\x00\x60\x00\x00"), &JfifHeader{1, 2, JFIF_UNITS_INCH, 72, 96}},
+		{"no thumbnail size", []byte("JFIF\x00\x01\x02\x02\x00\x48\x00\x60"), &JfifHeader{1, 2, JFIF_UNITS_CM, 72, 96}},
+		{"identifier only", []byte("JFIF\x00"), nil},
+		{"cut off in the density", []byte("JFIF\x00\x01\x02\x01\x00\x48\x00"), nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := withJfifBody(t, c.body)
+
+			j, err := NewJpegParserFromBytes(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.header == nil {
+				if j.JFIF != nil || j.JFIFError == nil {
+					t.Errorf("got %+v and error %v", j.JFIF, j.JFIFError)
+				}
+			} else if j.JFIF == nil || *j.JFIF != *c.header {
+				t.Errorf("got %+v, not %+v", j.JFIF, c.header)
+			}
+
+			config, err := DecodeConfig(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (config.JFIF == nil) != (c.header == nil) {
+				t.Errorf("DecodeConfig got %+v", config.JFIF)
+			}
+		})
+	}
+}
@@ -2,86 +2,111 @@ package jpeg
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"huffman"
+	"io"
 	"io/ioutil"
 )
 
 type JpegParser struct {
-	XLines             int
-	YLines             int
+	Frame
 	QuantizationTables map[int][64]int
 	ByteReader         *bytes.Reader
 	Sections           map[byte]*Section
-	HuffmanReaders     []*huffman.HuffmanReader
-	RestartInterval    int
-	Intervals          []*Interval
+	// Every marker segment in file order. Sections merges duplicates by marker type, which loses the
+	// boundaries between e.g. EXIF and XMP segments that both use APP1
+	Segments        []*Section
+	HuffmanReaders  []*huffman.HuffmanReader
+	RestartInterval int
+	Intervals       []*Interval
+	// nil when the file has no JFIF segment, or one too short to read
+	JFIF *JfifHeader
+	// Why a JFIF segment couldn't be read
+	JFIFError error
+	// SOF2. Coefficients are spread over several scans
+	Progressive bool
+	Scans       []*Scan
+	// The scan RestartInterval, Intervals, ScanComponents and GetHuffmanReader refer to
+	CurrentScan *Scan
+	// The file ended before EOI. Decoding stops where the data runs out
+	Truncated bool
+	// Set while Trace runs
+	tracer *tracer
+	// Set while Stats runs
+	bitCounter *bitCounter
+	// Bytes after EOI
+	trailerSize int
 }
 
-func NewJpegParser(filename string) *JpegParser {
-	j := &JpegParser{}
-	j.Sections = make(map[byte]*Section)
-	j.QuantizationTables = make(map[int][64]int)
-	j.HuffmanReaders = make([]*huffman.HuffmanReader, 0)
+// Returned by the constructors for a file that ends, or reaches EOI, before its first SOS
+var ErrNoScan = errors.New("the file ends before its first scan")
 
+// Parses with DefaultDecodeLimits
+func NewJpegParser(filename string) (*JpegParser, error) {
 	rawBytes, err := ioutil.ReadFile(filename)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	j.ByteReader = bytes.NewReader(rawBytes)
-
-	j.ParseSections()
-	j.ReadHuffmanTables()
-	j.ReadQuantizationTables()
-	j.ParseStartOfFrame()
-	j.ParseRestart()
+	return NewJpegParserFromBytes(rawBytes)
+}
 
-	return j
+// Parses with DefaultDecodeLimits
+func NewJpegParserFromBytes(rawBytes []byte) (*JpegParser, error) {
+	return NewJpegParserWithLimits(rawBytes, &DefaultDecodeLimits)
 }
 
-func (j *JpegParser) MCUCols() int {
+// The limits are checked as the file is parsed, so a header that asks for too much returns a *LimitError before
+// anything its size is allocated. Malformed data still panics
+func NewJpegParserWithLimits(rawBytes []byte, limits *DecodeLimits) (*JpegParser, error) {
+	j := &JpegParser{}
+	j.Sections = make(map[byte]*Section)
+	j.Segments = make([]*Section, 0)
+	j.QuantizationTables = make(map[int][64]int)
+	j.HuffmanReaders = make([]*huffman.HuffmanReader, 0)
 
-	ret := j.XLines / 16
+	j.ByteReader = bytes.NewReader(rawBytes)
 
-	if j.XLines%16 > 0 {
-		ret++
+	if err := j.ParseSections(limits); err != nil {
+		return nil, err
 	}
+	j.ParseJfif()
+	j.ReadQuantizationTables()
+	j.ParseStartOfFrame()
 
-	return ret
-}
-
-func (j *JpegParser) MCURows() int {
+	// Before the intervals are split, since their number follows from the frame size
+	if err := j.checkLimits(limits); err != nil {
+		return nil, err
+	}
 
-	ret := j.YLines / 16
+	j.ParseStartOfScan()
+	j.ParseRestart()
 
-	if j.YLines%16 > 0 {
-		ret++
-	}
+	// Everything that only knows about a single scan sees the first one
+	j.ActivateScan(j.Scans[0])
 
-	return ret
+	return j, nil
 }
 
-// This method is crying for a rewrite. Also the look ahead reader on marker byte is poorly done. Should be only single
-// read call
-func (j *JpegParser) ParseSections() {
+// Walks the marker segments in file order. Each SOS is followed by entropy coded data, which runs until the next
+// marker that isn't a restart marker, after which segment parsing picks up again. Huffman tables and the restart
+// interval are read as they come so each scan gets the ones in effect when it starts. The scan count, metadata
+// and trailer limits are checked as each one is read
+func (j *JpegParser) ParseSections(limits *DecodeLimits) error {
 	var readError error
 	var b byte
 
-	frameStart := 0
-	inFrame := false
-
 	offset := 0
-
-	offsetOfEOI := 0
-
-	readError = nil
+	metadata := 0
 
 	//Named break. Redo
 reader:
 	for {
 		b, readError = j.ByteReader.ReadByte()
 		if readError != nil {
+			// Out of bytes without an EOI
+			j.Truncated = true
 			break
 		}
 		offset += 1
@@ -89,8 +114,8 @@ reader:
 		if b == 0xFF {
 
 			if j.ByteReader.Len() == 0 {
-				fmt.Printf("no more bytes in buffer\n")
 				// EOF marker so continue
+				j.Truncated = true
 				break
 			}
 
@@ -104,16 +129,17 @@ reader:
 
 			var markerType byte
 
-			if inFrame && nextByte != MARKER_EOI {
-				continue // the frame has numerous things that look like markers that aren't
-			}
-
 			switch {
 			// In one of the cases we have to mask nextByte instead of just checking equality
 			case nextByte == MARKER_SOI:
 				markerType = MARKER_SOI
 				// SOI is only a marker. It doesn't have a section that follows
 				continue
+			case nextByte == 0xFF:
+				// Fill byte ahead of a marker (B.1.1.2). Step back so the next 0xFF is read as the marker start
+				j.ByteReader.UnreadByte()
+				offset -= 1
+				continue
 			case nextByte == MARKER_DHT:
 				markerType = MARKER_DHT
 			case nextByte == MARKER_DRI:
@@ -127,19 +153,26 @@ reader:
 				markerType = MARKER_JFIF
 			case nextByte == MARKER_SOF0:
 				markerType = MARKER_SOF0
+			case nextByte == MARKER_SOF1:
+				// Extended sequential with 8 bit samples decodes just like baseline
+				markerType = MARKER_SOF0
+			case nextByte == MARKER_SOF2:
+				markerType = MARKER_SOF0
+				j.Progressive = true
 			case nextByte == MARKER_SOS:
 				markerType = MARKER_SOS
 			case nextByte&MARKER_UNKNOWN_EXTENSION_MASK == MARKER_UNKNOWN_EXTENSION_MASK:
 				//fmt.Printf("Marker is unknown extension and byte is %d\n", nextByte)
 				markerType = MARKER_UNKNOWN_EXTENSION
 			case nextByte == MARKER_EOI:
-				// only applicable inside frame
-				if !inFrame {
-					panic("EOI found and we're not in frame")
+				if len(j.Scans) == 0 {
+					return ErrNoScan
+				}
+
+				j.trailerSize = j.ByteReader.Len()
+				if limits.MaxTrailerSize > 0 && j.trailerSize > limits.MaxTrailerSize {
+					return &LimitError{Limit: "trailer size", Value: j.trailerSize, Max: limits.MaxTrailerSize}
 				}
-				markerType = MARKER_EOI
-				//fmt.Printf("setting lastEOI to %d\n", offset)
-				offsetOfEOI = offset - 2
 
 				break reader // and we need to skip over the rest of the loop here to prevent a read beyond the EOI.
 				// Some writers (Adobe photoshop being the one in the tests)  put info beyond the EOI that we must ignore
@@ -154,20 +187,23 @@ reader:
 
 			r, err := j.ByteReader.Read(lenSectionAsBytes)
 
+			// A file cut off in the middle of a segment after the first scan still has something to show. Before
+			// it there's no scan, which ends up as ErrNoScan
 			if r != 2 || err != nil {
-				panic("malformed on marker read")
+				j.Truncated = true
+				break
 			}
 
 			offset += 2
 
 			sectionLength := (int(lenSectionAsBytes[0]) << 8) | int(lenSectionAsBytes[1])
 
-			//fmt.Printf("for markerType: %d we read numBytes as: %d\n", markerType, sectionLength)
-
-			//fmt.Printf("found a %v with length %v\n", markerType, sectionLength)
-
 			sectionLength -= 2 // As stored, includes length bytes
 
+			if sectionLength < 0 {
+				return errors.New("marker segment length is too short")
+			}
+
 			offset += sectionLength
 
 			sectionBody := make([]byte, sectionLength)
@@ -175,165 +211,288 @@ reader:
 			r, err = j.ByteReader.Read(sectionBody)
 
 			if r != sectionLength || err != nil {
-				panic(r)
-				//panic("malformed on section body read")
+				j.Truncated = true
+				break
+			}
+
+			// Segments keeps the real marker, Sections lumps the unknown extensions together
+			segment := NewSection(nextByte, sectionBody)
+			segment.Offset = offset - sectionLength - 4
+			j.Segments = append(j.Segments, segment)
+
+			if segment.IsApp() || segment.Type == MARKER_COM {
+				metadata += sectionLength
+				if limits.MaxMetadataSize > 0 && metadata > limits.MaxMetadataSize {
+					return &LimitError{Limit: "metadata size", Value: metadata, Max: limits.MaxMetadataSize}
+				}
+			}
+
+			switch markerType {
+			case MARKER_DHT:
+				if err := j.ReadHuffmanTables(segment); err != nil {
+					return err
+				}
+			case MARKER_DRI:
+				if len(sectionBody) < 2 {
+					panic("DRI segment is too short")
+				}
+				j.RestartInterval = int(sectionBody[0])<<8 | int(sectionBody[1])
+			case MARKER_SOS:
+				// Copying the slice header freezes the list of tables for this scan
+				scan := NewScan(sectionBody, nil, segment.Offset, j.HuffmanReaders[:len(j.HuffmanReaders):len(j.HuffmanReaders)], j.RestartInterval)
+				scan.Body = j.readEntropyCodedData()
+				offset += len(scan.Body)
+				j.Scans = append(j.Scans, scan)
+
+				if limits.MaxScans > 0 && len(j.Scans) > limits.MaxScans {
+					return &LimitError{Limit: "scans", Value: len(j.Scans), Max: limits.MaxScans}
+				}
+			}
+
+			// XMP shares APP1 with EXIF. Merging it in would put XML in the middle of the TIFF structure, so
+			// anything in APP1 that isn't EXIF is only kept in Segments
+			if markerType == MARKER_EXIF && !IsExif(sectionBody) {
+				continue
 			}
 
 			// Some jpeg writers duplicate sections instead of having a single. So we check if the section already exists
-			// and if so we append the bytes. Otherwise we create a new section
+			// and if so we append the bytes. Otherwise we create a new section. Scans are kept in Scans instead so
+			// Sections only has the first one
 
 			existingSection, present := j.Sections[markerType]
 
-			if present {
+			if present && markerType != MARKER_SOS {
 				existingSection.Body = append(existingSection.Body, sectionBody...) // The ... syntax is wacky
-			} else {
-				section := NewSection(markerType, sectionBody)
+			} else if !present {
+				section := NewSection(markerType, append([]byte{}, sectionBody...))
+				section.Offset = segment.Offset
 				j.Sections[markerType] = section
 			}
+		}
+	}
 
-			// Dirty but seemingly no marker on frame start
+	if len(j.Scans) == 0 {
+		return ErrNoScan
+	}
 
-			if markerType == MARKER_SOS {
-				if present {
-					// marker SOS should never be duped so panic if it does as a precaution
-					panic("Marker SOS duped")
-				}
-				// we will read from the end of it to get the frame
-				//fmt.Printf("We think frameStart is %d\n", offset)
-				frameStart = offset
-				inFrame = true
-			}
+	j.Sections[MARKER_FRAME] = NewSection(MARKER_FRAME, j.Scans[0].Body)
+
+	return nil
+}
 
+// Reads from just after an SOS segment up to the next marker with a segment, leaving the reader on that marker.
+// 0xFF 0x00 is a stuffed data byte, not a marker (F.1.2.3), and RSTn and damaged markers stay in the data
+func (j *JpegParser) readEntropyCodedData() []byte {
+	start := int(j.ByteReader.Size()) - j.ByteReader.Len()
+	end := start
+
+	for {
+		b, err := j.ByteReader.ReadByte()
+		if err != nil {
+			// Ran off the end without an EOI. Take what there is
+			end = int(j.ByteReader.Size())
+			break
 		}
-	}
 
-	if _, p := j.Sections[MARKER_SOS]; !p {
-		panic("got to the end of parsing without a scan start")
-	}
-	// We are done but we need to read behind us to get the frame
+		if b != 0xFF {
+			continue
+		}
 
-	//fmt.Printf("offsetOfEOI: %d, frameStart: %d\n", offsetOfEOI, frameStart)
-	frameLength := offsetOfEOI - frameStart
+		next, err := j.ByteReader.ReadByte()
+		if err != nil {
+			end = int(j.ByteReader.Size())
+			break
+		}
 
-	frameBody := make([]byte, frameLength)
+		if next == 0x00 || (next >= MARKER_RST0 && next <= MARKER_RST7) {
+			continue
+		}
 
-	r, err := j.ByteReader.ReadAt(frameBody, int64(frameStart))
+		// The second 0xFF could be the start of the marker
+		if next == 0xFF {
+			j.ByteReader.UnreadByte()
+			continue
+		}
 
-	if r != len(frameBody) || err != nil {
-		panic(r)
-	}
+		// Anything ParseSections has no use for can only be damage to the data, which the decode runs into and
+		// a tolerant one picks up again after at the next RSTn
+		if !isSegmentMarker(next) {
+			continue
+		}
 
-	frameMarkerType := MARKER_FRAME
+		// A real marker. Put both bytes back for ParseSections, along with any fill bytes ahead of it
+		end = int(j.ByteReader.Size()) - j.ByteReader.Len() - 2
+		for end > start && j.byteAt(end-1) == 0xFF {
+			end--
+		}
+		j.ByteReader.Seek(int64(end), io.SeekStart)
+		break
+	}
 
-	section := NewSection(frameMarkerType, frameBody)
+	body := make([]byte, end-start)
+	j.ByteReader.ReadAt(body, int64(start))
 
-	j.Sections[frameMarkerType] = section
+	return body
 }
 
-func (j *JpegParser) ParseRestart() {
-	sec, present := j.Sections[MARKER_DRI]
+func (j *JpegParser) byteAt(offset int) byte {
+	b := make([]byte, 1)
+	j.ByteReader.ReadAt(b, int64(offset))
 
-	frame := j.Sections[MARKER_FRAME]
-
-	// We need to write a final interval regardless of whether this frame uses restarts. It could be
-	// the final interval in a series or the only interval in the entire frame
+	return b[0]
+}
 
-	XMCUs := j.XLines / 16
-	// Round up if it's not a multiple of 16
-	if j.XLines%16 != 0 {
-		XMCUs++
+// The markers ParseSections reads, apart from SOI which only starts the file. Keep in step with its switch
+func isSegmentMarker(marker byte) bool {
+	switch marker {
+	case MARKER_DHT, MARKER_DRI, MARKER_DQT, MARKER_SOF0, MARKER_SOF1, MARKER_SOF2, MARKER_SOS, MARKER_EOI:
+		return true
 	}
 
-	YMCUs := j.YLines / 16
+	return marker != 0xFF && marker&MARKER_UNKNOWN_EXTENSION_MASK == MARKER_UNKNOWN_EXTENSION_MASK
+}
 
-	if j.YLines%16 != 0 {
-		YMCUs++
+// Splits every scan's data into intervals at the restart markers
+func (j *JpegParser) ParseRestart() {
+	for _, scan := range j.Scans {
+		j.ActivateScan(scan)
+		scan.Intervals = j.splitIntervals(scan.Body, scan.RestartInterval, j.ScanMCUs())
 	}
+}
 
-	totalMCUsExpected := XMCUs * YMCUs
+// Cuts the scan data at its restart markers. Markers are expected to count 0 to 7 and wrap. When one jumps ahead
+// and the next marker agrees, the intervals it skipped over are added as Damaged with no data, and so are any MCUs left over when
+// the markers run out early. Only a tolerant decode can do anything with those
+func (j *JpegParser) splitIntervals(body []byte, restartInterval int, totalMCUsExpected int) []*Interval {
+
+	// We need to write a final interval regardless of whether this scan uses restarts. It could be
+	// the final interval in a series or the only interval in the entire scan
+
+	if restartInterval == 0 {
+		return []*Interval{NewInterval(body, 0, totalMCUsExpected)}
+	}
 
 	// Below is done only if there are restart markers. Otherwise the remainder math below this
 	// will make a single interval out of the "remainder" of the file (which may be a single, giant interval)
 
 	markerCount := 0
 
-	if present {
-		j.RestartInterval = int(sec.Body[0]<<8) | int(sec.Body[1])
-		j.Intervals = make([]*Interval, 0)
+	intervals := make([]*Interval, 0)
 
-		frameLength := len(frame.Body)
+	prevByteFF := false
+	intervalStart := 0
+	intervalEnd := 0
 
-		prevByteFF := false
-		intervalStart := 0
-		intervalEnd := 0
+	for byteIndex := 0; byteIndex < len(body); byteIndex++ {
+		restartIndex := markerCount % 8
 
-		for byteIndex := 0; byteIndex < frameLength; byteIndex++ {
-			restartIndex := markerCount % 8
+		b := body[byteIndex]
 
-			b := frame.Body[byteIndex]
+		if b == 0xFF {
+			prevByteFF = true
+			continue
+		}
 
-			if b == 0xFF {
-				prevByteFF = true
+		if prevByteFF {
+			prevByteFF = false
+			if b == 0x00 {
+				// This is a padding byte so continue
 				continue
 			}
 
-			if prevByteFF {
-				prevByteFF = false
-				if b == 0x00 {
-					// This is a padding byte so continue
-					continue
-				}
-
-				// Marker bytes are 0xffd0 - 0xffd7. 0xd0 in decimal is 208 so subtract by 208 so we can compare with 0 - 7
+			// Any other marker in here is damage. It stays in the interval it's in for the decode to trip over
+			if b < MARKER_RST0 || b > MARKER_RST7 {
+				continue
+			}
 
-				if int(b)-208 == restartIndex {
-					// Here's the interesting part:
-					// byteIndex - 2 is the end of the last interval
-					// byteIndex + 1 is the start of the next interval (but we need to copy out the bytes before we reset it)
+			// Marker bytes are 0xffd0 - 0xffd7. 0xd0 in decimal is 208 so subtract by 208 so we can compare with 0 - 7
 
-					intervalEnd = byteIndex - 2
-					// Go slice subsetting isn't inclusive so add 1
-					interval := NewInterval(frame.Body[intervalStart:intervalEnd+1], markerCount*j.RestartInterval, j.RestartInterval)
+			// Here's the interesting part:
+			// byteIndex - 2 is the end of the last interval
+			// byteIndex + 1 is the start of the next interval (but we need to copy out the bytes before we reset it)
 
-					j.Intervals = append(j.Intervals, interval)
+			intervalEnd = byteIndex - 2
+			// Go slice subsetting isn't inclusive so add 1
+			interval := NewInterval(body[intervalStart:intervalEnd+1], markerCount*restartInterval, restartInterval)
+			interval.DataOffset = intervalStart
 
-					intervalStart = byteIndex + 1
-					markerCount++
+			intervals = append(intervals, interval)
 
-				} else {
-					fmt.Printf("byte found: %d\n", b)
-					panic("Found a marker in the frame body that we don't expect")
-				}
+			intervalStart = byteIndex + 1
+			markerCount++
 
+			// Markers that went missing leave a gap in the numbering. The gap is only believed when the marker
+			// after this one carries on from it. Otherwise it's this marker's number that got damaged, or swapped
+			// with another's, and where it sits in the data says more than its number does
+			skipped := (int(b) - 208 - restartIndex + 8) % 8
+			next := nextRestartNumber(body, byteIndex+1)
+			if skipped > 0 && next >= 0 && next != (int(b)-208+1)%8 {
+				skipped = 0
 			}
 
+			for i := 0; i < skipped; i++ {
+				intervals = append(intervals, newDamagedInterval(markerCount*restartInterval, restartInterval))
+				markerCount++
+			}
 		}
 
-		remainder := totalMCUsExpected - markerCount*j.RestartInterval
+	}
 
-		// Panic if the math is wrong since it's unrecoverable. Note this only applies to situations where there
-		// is a restart interval defined. Otherwise the restart interval will be 0 and the entire frame is the
-		// remainder
+	remainder := totalMCUsExpected - markerCount*restartInterval
 
-		if j.RestartInterval != 0 {
+	// Too few markers. The last interval can only hold restartInterval MCUs and the rest can't be placed
+	missing := 0
+	if remainder > restartInterval {
+		missing = remainder - restartInterval
+		remainder = restartInterval
+	}
 
-			if remainder > j.RestartInterval {
-				panic("Math on MCUs in this image is wrong - unrecoverable")
-			}
+	if remainder > 0 {
+		interval := NewInterval(body[intervalStart:], markerCount*restartInterval, remainder)
+		interval.DataOffset = intervalStart
+		intervals = append(intervals, interval)
+	}
+
+	// One damaged interval covers them all, however many restart intervals that is. A header can ask for millions
+	if missing > 0 {
+		intervals = append(intervals, newDamagedInterval(totalMCUsExpected-missing, missing))
+	}
+
+	// Too many markers. Anything past the end of the scan is dropped
+	kept := make([]*Interval, 0, len(intervals))
+	for _, interval := range intervals {
+		if interval.MCUOffset >= totalMCUsExpected {
+			continue
 		}
 
-		interval := NewInterval(frame.Body[intervalStart:len(frame.Body)], markerCount*j.RestartInterval, remainder)
-		j.Intervals = append(j.Intervals, interval)
+		interval.MCUs = minInt(interval.MCUs, totalMCUsExpected-interval.MCUOffset)
+		kept = append(kept, interval)
+	}
 
-	} else {
-		j.Intervals = []*Interval{NewInterval(frame.Body, 0, totalMCUsExpected)}
+	return kept
+}
+
+// The number of the first RST marker at or after from, or -1 if there isn't one
+func nextRestartNumber(body []byte, from int) int {
+	for i := from; i+1 < len(body); i++ {
+		if body[i] == 0xFF && body[i+1] >= MARKER_RST0 && body[i+1] <= MARKER_RST7 {
+			return int(body[i+1] - MARKER_RST0)
+		}
 	}
 
+	return -1
 }
 
 func (j *JpegParser) ParseStartOfFrame() {
 
-	sof := j.Sections[MARKER_SOF0]
+	sof, present := j.Sections[MARKER_SOF0]
+	if !present {
+		panic("no start of frame")
+	}
+
+	if len(sof.Body) < 6 || len(sof.Body) < 6+3*int(sof.Body[5]) {
+		panic("SOF segment is too short")
+	}
 
 	offset := 1 // skip precision byte
 
@@ -344,22 +503,93 @@ func (j *JpegParser) ParseStartOfFrame() {
 	xLines := int(sof.Body[offset])<<8 | int(sof.Body[offset+1])
 
 	offset += 2
-	//skip rest since they're standard
 
 	j.XLines = xLines
 	j.YLines = yLines
 
-	//skip component data
+	numComponents := int(sof.Body[offset])
+
+	if numComponents == 0 {
+		panic("frame has no components")
+	}
+
+	offset += 1
 
-	//numComponents := int(sof.Body[offset])
+	j.Components = make([]*Component, 0, numComponents)
 
-	//fmt.Println(numComponents)
+	for i := 0; i < numComponents; i++ {
+		id := int(sof.Body[offset])
+		h := int(sof.Body[offset+1] >> 4)
+		v := int(sof.Body[offset+1] & 0x0F)
+		tq := int(sof.Body[offset+2])
+
+		// B.2.2
+		if h < 1 || h > 4 || v < 1 || v > 4 {
+			panic("sampling factors must be 1 to 4")
+		}
+
+		j.Components = append(j.Components, NewComponent(id, h, v, tq))
+		offset += 3
+	}
+
+}
+
+// B.2.3, for every scan
+func (j *JpegParser) ParseStartOfScan() {
+
+	for _, scan := range j.Scans {
+		sos := scan.Header
+
+		if len(sos) < 1 || len(sos) < 4+2*int(sos[0]) {
+			panic("SOS segment is too short")
+		}
+
+		numComponents := int(sos[0])
+
+		if numComponents == 0 {
+			panic("scan has no components")
+		}
+
+		offset := 1
+
+		scan.Components = make([]*Component, 0, numComponents)
+
+		for i := 0; i < numComponents; i++ {
+			c := j.GetComponent(int(sos[offset]))
+			if c == nil {
+				panic("scan references a component that isn't in the frame")
+			}
+
+			for _, existing := range scan.Components {
+				if existing == c {
+					panic("scan references a component twice")
+				}
+			}
+
+			scan.Components = append(scan.Components, c)
+			scan.Td = append(scan.Td, int(sos[offset+1]>>4))
+			scan.Ta = append(scan.Ta, int(sos[offset+1]&0x0F))
+			offset += 2
+		}
+
+		scan.Ss = int(sos[offset])
+		scan.Se = int(sos[offset+1])
+		scan.Ah = int(sos[offset+2] >> 4)
+		scan.Al = int(sos[offset+2] & 0x0F)
+
+		if scan.Se > 63 || scan.Ss > scan.Se {
+			panic("spectral selection is out of range")
+		}
+	}
 
 }
 
 func (j *JpegParser) ReadQuantizationTables() {
 
-	dqt := j.Sections[MARKER_DQT]
+	dqt, present := j.Sections[MARKER_DQT]
+	if !present {
+		panic("no quantization tables")
+	}
 
 	offset := 0
 
@@ -370,6 +600,10 @@ func (j *JpegParser) ReadQuantizationTables() {
 
 		offset += 1
 
+		if offset+64 > len(dqt.Body) {
+			panic("DQT segment is too short")
+		}
+
 		byteSlice := dqt.Body[offset : offset+64]
 
 		intArray := [64]int{}
@@ -385,9 +619,9 @@ func (j *JpegParser) ReadQuantizationTables() {
 
 }
 
-func (j *JpegParser) ReadHuffmanTables() {
-
-	dht := j.Sections[MARKER_DHT]
+// Adds the tables of a DHT segment to HuffmanReaders. Returns an error for a segment that's cut short or a table
+// that can't be a Huffman code
+func (j *JpegParser) ReadHuffmanTables(dht *Section) error {
 
 	offset := 0
 
@@ -400,6 +634,10 @@ func (j *JpegParser) ReadHuffmanTables() {
 
 		offset += 1
 
+		if offset+16 > len(dht.Body) {
+			return errors.New("DHT segment is too short")
+		}
+
 		counter := 1
 
 		bits := make(map[int]int)
@@ -416,6 +654,10 @@ func (j *JpegParser) ReadHuffmanTables() {
 			totalVals += v
 		}
 
+		if offset+totalVals > len(dht.Body) {
+			return errors.New("DHT segment is too short")
+		}
+
 		huffVal := make([]int, totalVals)
 
 		for i := 0; i < totalVals; i += 1 {
@@ -423,24 +665,39 @@ func (j *JpegParser) ReadHuffmanTables() {
 			offset += 1
 		}
 
-		huffmanReader := huffman.NewHuffmanReader(target, identifier, bits, huffVal)
+		huffmanReader, err := huffman.NewHuffmanReader(target, identifier, bits, huffVal)
+		if err != nil {
+			return fmt.Errorf("DHT table %d/%d: %v", target, identifier, err)
+		}
 
 		//fmt.Printf("Adding a huffman reader with huffval length: %d\n", len(huffVal))
 		// Handle Huffman reader init
 		j.HuffmanReaders = append(j.HuffmanReaders, huffmanReader)
 	}
 
+	return nil
 }
 
+// The table in effect for the current scan. A table can be defined more than once, in which case the last
+// definition before the scan is the one it uses
 func (j *JpegParser) GetHuffmanReader(target int, identifier int) *huffman.HuffmanReader {
 	var ret *huffman.HuffmanReader
 
-	for _, e := range j.HuffmanReaders {
+	readers := j.HuffmanReaders
+	if j.CurrentScan != nil {
+		readers = j.CurrentScan.HuffmanReaders
+	}
+
+	for _, e := range readers {
 		if e.Target == target && e.Identifier == identifier {
 			ret = e
 		}
 	}
 
+	if ret == nil {
+		panic("scan uses a Huffman table that isn't defined")
+	}
+
 	return ret
 
 }
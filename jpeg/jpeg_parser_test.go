@@ -0,0 +1,67 @@
+package jpeg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// An empty scan with a restart interval of 1 is missing every interval after its first. With no limits the
+// 3,999,999 MCUs it can't place have to come back as a single damaged interval
+func TestMissingIntervalsAreOneDamagedInterval(t *testing.T) {
+	j, err := NewJpegParserWithLimits(testLimitsFile(16000, 16000, nil, 1, 0), &DecodeLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(j.Intervals) != 2 {
+		t.Fatalf("got %d intervals", len(j.Intervals))
+	}
+
+	interval := j.Intervals[1]
+	if !interval.Damaged || interval.MCUOffset != 1 || interval.MCUs != 2000*2000-1 {
+		t.Errorf("got damaged %v at MCU %d for %d MCUs", interval.Damaged, interval.MCUOffset, interval.MCUs)
+	}
+}
+
+// Files that end, or reach EOI, before the first SOS have no scan to decode
+func TestNoScanIsAnError(t *testing.T) {
+	spec, err := ioutil.ReadFile("../spec.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sos := bytes.Index(spec, []byte{0xFF, MARKER_SOS})
+
+	cases := map[string][]byte{
+		"empty":               {},
+		"SOI":                 spec[:2],
+		"in a segment header": spec[:5],
+		"in a segment body":   spec[:40],
+		"at the SOS":          spec[:sos],
+		"in the SOS header":   spec[:sos+6],
+		"EOI":                 {0xFF, MARKER_SOI, 0xFF, MARKER_EOI},
+	}
+
+	for name, b := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewJpegParserFromBytes(b); err != ErrNoScan {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}
+
+// A DHT whose 2 bit codes take up the whole code space, ending in 11, comes back as an error from the constructor
+func TestAllOnesHuffmanCodeIsAnError(t *testing.T) {
+	b := testEncode(t, testImage(16, 16, true, 1), &EncodeOptions{})
+
+	dht := []byte{0x00, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4}
+	b = append(append(append([]byte{}, b[:2]...), testSegment(MARKER_DHT, dht)...), b[2:]...)
+
+	j, err := NewJpegParserFromBytes(b)
+	if j != nil || err == nil || !strings.Contains(err.Error(), "all ones") {
+		t.Errorf("got %v", err)
+	}
+}
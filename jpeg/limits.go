@@ -0,0 +1,86 @@
+package jpeg
+
+import (
+	"fmt"
+)
+
+// Bounds on what a decode may cost, for files that can't be trusted. The header alone decides how big the
+// output is, so a few hundred bytes can ask for gigabytes, and a progressive file can repeat scans over the whole
+// image as often as it likes. Zero means no limit
+type DecodeLimits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int
+	MaxScans  int
+	// All APPn and COM segments together, in bytes
+	MaxMetadataSize int
+	// Bytes after EOI. MPF puts its other images there, so this has to allow for a few full size JPEGs
+	MaxTrailerSize int
+	// In bytes. Covers the coefficient and sample planes and the RGBA output
+	MaxMemory int
+}
+
+// Generous enough for any real photo. A 100 megapixel image needs about 1.2 GB to decode here
+var DefaultDecodeLimits = DecodeLimits{
+	MaxWidth:        65535,
+	MaxHeight:       65535,
+	MaxPixels:       100000000,
+	MaxScans:        1000,
+	MaxMetadataSize: 16 << 20,
+	MaxTrailerSize:  256 << 20,
+	MaxMemory:       2 << 30,
+}
+
+// Returned when a file asks for more than the DecodeLimits allow
+type LimitError struct {
+	// Which limit: width, height, pixels, scans, metadata size, trailer size or memory
+	Limit string
+	Value int
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s of %d is over the limit of %d", e.Limit, e.Value, e.Max)
+}
+
+// Checked by the constructors once the frame header is in, before anything the size of the image is allocated,
+// and again by a decode with limits of its own
+func (j *JpegParser) checkLimits(l *DecodeLimits) error {
+	metadata := 0
+
+	for _, s := range j.Segments {
+		if s.IsApp() || s.Type == MARKER_COM {
+			metadata += len(s.Body)
+		}
+	}
+
+	checks := []LimitError{
+		{"width", j.XLines, l.MaxWidth},
+		{"height", j.YLines, l.MaxHeight},
+		{"pixels", j.XLines * j.YLines, l.MaxPixels},
+		{"scans", len(j.Scans), l.MaxScans},
+		{"metadata size", metadata, l.MaxMetadataSize},
+		{"trailer size", j.trailerSize, l.MaxTrailerSize},
+		{"memory", j.decodeMemory(), l.MaxMemory},
+	}
+
+	for _, c := range checks {
+		if c.Max > 0 && c.Value > c.Max {
+			return &LimitError{Limit: c.Limit, Value: c.Value, Max: c.Max}
+		}
+	}
+
+	return nil
+}
+
+// Roughly what DecodeWithOptions allocates. Each block has 64 coefficients and 64 samples as ints, and each
+// pixel 4 bytes of RGBA
+func (j *JpegParser) decodeMemory() int {
+	blocks := 0
+
+	for _, p := range j.planeSizes() {
+		blocks += p[0] * p[1]
+	}
+
+	return blocks*64*2*8 + j.XLines*j.YLines*4
+}
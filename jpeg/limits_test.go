@@ -0,0 +1,85 @@
+package jpeg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testSegment(marker byte, body []byte) []byte {
+	length := len(body) + 2
+	return append([]byte{0xFF, marker, byte(length >> 8), byte(length)}, body...)
+}
+
+// A single component frame of the given size with one empty scan, and whatever extra goes before the scan, any
+// scans after it and a trailer after EOI
+func testLimitsFile(width int, height int, extra []byte, scans int, trailer int) []byte {
+	var b bytes.Buffer
+
+	b.Write([]byte{0xFF, MARKER_SOI})
+	b.Write(testSegment(MARKER_DQT, append([]byte{0}, bytes.Repeat([]byte{1}, 64)...)))
+	b.Write(testSegment(MARKER_SOF0, []byte{8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), 1, 1, 0x11, 0}))
+	b.Write(testSegment(MARKER_DHT, append([]byte{0x00, 1}, make([]byte, 16)...)))
+	b.Write(testSegment(MARKER_DHT, append([]byte{0x10, 1}, make([]byte, 16)...)))
+	b.Write(testSegment(MARKER_DRI, []byte{0, 1}))
+	b.Write(extra)
+
+	for i := 0; i < scans; i++ {
+		b.Write(testSegment(MARKER_SOS, []byte{1, 1, 0, 0, 63, 0}))
+	}
+
+	b.Write([]byte{0xFF, MARKER_EOI})
+	b.Write(make([]byte, trailer))
+
+	return b.Bytes()
+}
+
+// Every limit has to stop the constructor, before the intervals of a frame that size are split
+func TestLimitsCheckedWhileParsing(t *testing.T) {
+	limits := DecodeLimits{MaxWidth: 1000, MaxHeight: 1000, MaxPixels: 500000, MaxScans: 3, MaxMetadataSize: 100, MaxTrailerSize: 10, MaxMemory: 1 << 20}
+	comment := testSegment(MARKER_COM, make([]byte, 101))
+
+	cases := []struct {
+		name  string
+		b     []byte
+		limit string
+	}{
+		{"width", testLimitsFile(16000, 8, nil, 1, 0), "width"},
+		{"height", testLimitsFile(8, 16000, nil, 1, 0), "height"},
+		{"pixels", testLimitsFile(1000, 1000, nil, 1, 0), "pixels"},
+		{"scans", testLimitsFile(8, 8, nil, 4, 0), "scans"},
+		{"metadata", testLimitsFile(8, 8, comment, 1, 0), "metadata size"},
+		{"trailer", testLimitsFile(8, 8, nil, 1, 11), "trailer size"},
+		{"memory", testLimitsFile(500, 500, nil, 1, 0), "memory"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			j, err := NewJpegParserWithLimits(c.b, &limits)
+			if j != nil {
+				t.Fatal("got a parser back")
+			}
+
+			e, isLimit := err.(*LimitError)
+			if !isLimit {
+				t.Fatalf("got %v, not a *LimitError", err)
+			}
+			if e.Limit != c.limit {
+				t.Errorf("%s limit hit, not %s", e.Limit, c.limit)
+			}
+		})
+	}
+
+	if _, err := NewJpegParserWithLimits(testLimitsFile(8, 8, nil, 1, 10), &limits); err != nil {
+		t.Errorf("a file inside the limits: %v", err)
+	}
+}
+
+// The 16000x16000 frame with a restart interval of 1 from a 146 byte file, which used to split 4,000,000
+// intervals before the limits were looked at
+func TestDefaultLimitsStopRestartIntervalBomb(t *testing.T) {
+	_, err := NewJpegParserFromBytes(testLimitsFile(16000, 16000, nil, 1, 0))
+
+	if e, isLimit := err.(*LimitError); !isLimit || e.Limit != "pixels" {
+		t.Fatalf("got %v, not the pixels limit", err)
+	}
+}
@@ -0,0 +1,721 @@
+package jpeg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"huffman"
+)
+
+// Checking a file against the spec and reporting everything wrong with it, rather than stopping at the first
+// problem the way the parser does. The marker segments are walked without the parser so a broken segment doesn't
+// hide the ones after it, then the parser decodes each restart interval on its own to check the entropy coded data
+
+const (
+	// The file breaks the spec in a way that stops it decoding, or decoding correctly
+	LINT_ERROR = "error"
+	// Against the spec, or something the decoder doesn't support, but there's an image to be had
+	LINT_WARNING = "warning"
+	// Allowed, but unusual enough to mention
+	LINT_INFO = "info"
+)
+
+type LintIssue struct {
+	Severity string `json:"severity"`
+	// Where in the file the problem is, or -1 for the file as a whole
+	Offset  int    `json:"offset"`
+	Message string `json:"message"`
+}
+
+type LintReport struct {
+	File   string      `json:"file,omitempty"`
+	Issues []LintIssue `json:"issues"`
+}
+
+func (r *LintReport) add(severity string, offset int, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, LintIssue{Severity: severity, Offset: offset, Message: fmt.Sprintf(format, args...)})
+}
+
+// How many issues have the severity
+func (r *LintReport) Count(severity string) int {
+	count := 0
+
+	for _, issue := range r.Issues {
+		if issue.Severity == severity {
+			count++
+		}
+	}
+
+	return count
+}
+
+// One issue per line, with the offset in hex
+func (r *LintReport) WriteText(w io.Writer) error {
+	for _, issue := range r.Issues {
+		offset := "-"
+		if issue.Offset >= 0 {
+			offset = fmt.Sprintf("0x%08x", issue.Offset)
+		}
+
+		if _, err := fmt.Fprintf(w, "%s: %-7s %s  %s\n", r.File, issue.Severity, offset, issue.Message); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%s: %d errors, %d warnings\n", r.File, r.Count(LINT_ERROR), r.Count(LINT_WARNING))
+
+	return err
+}
+
+func (r *LintReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(r)
+}
+
+// What the walk has seen so far
+type linter struct {
+	report *LintReport
+	b      []byte
+	// nil until the SOF
+	frame       *Frame
+	frameMarker byte
+	// Table ids defined so far. Huffman tables are keyed by class << 4 | id, the same as the DHT Tc/Th byte
+	quantization    map[int]bool
+	huffmanTables   map[int]bool
+	restartInterval int
+	scans           int
+	mpf             bool
+	// Where the walk found markers that can only be damage inside entropy coded data
+	damagedMarkers []int
+	// Scans, by index, that use a Huffman table that isn't defined
+	missingTables map[int]bool
+}
+
+func Lint(b []byte) *LintReport {
+	l := &linter{
+		report:        &LintReport{Issues: make([]LintIssue, 0)},
+		b:             b,
+		quantization:  make(map[int]bool),
+		huffmanTables: make(map[int]bool),
+		missingTables: make(map[int]bool),
+	}
+
+	if l.walk() {
+		l.checkEntropyCodedData()
+	}
+
+	return l.report
+}
+
+// Checks every marker segment in order. Returns false if the file is too broken to be worth decoding
+func (l *linter) walk() bool {
+	b := l.b
+
+	if len(b) < 2 || b[0] != 0xFF || b[1] != MARKER_SOI {
+		l.report.add(LINT_ERROR, 0, "file doesn't start with SOI")
+		return false
+	}
+
+	offset := 2
+
+	for {
+		if offset >= len(b) {
+			l.report.add(LINT_ERROR, len(b), "file ends without an EOI")
+			break
+		}
+
+		if b[offset] != 0xFF {
+			next := nextSegmentMarker(b, offset)
+
+			l.report.add(LINT_ERROR, offset, "%d bytes that aren't part of any segment", next-offset)
+			offset = next
+			continue
+		}
+
+		// B.1.1.2 allows any number of 0xFF fill bytes ahead of a marker
+		fill := 0
+		for offset+1 < len(b) && b[offset+1] == 0xFF {
+			offset++
+			fill++
+		}
+
+		if fill > 0 {
+			l.report.add(LINT_INFO, offset-fill, "%d fill bytes ahead of a marker", fill)
+		}
+
+		if offset+1 >= len(b) {
+			l.report.add(LINT_ERROR, offset, "file ends in the middle of a marker")
+			break
+		}
+
+		marker := b[offset+1]
+		name := MarkerName(marker)
+
+		if marker == MARKER_EOI {
+			l.checkTrailer(offset + 2)
+			break
+		}
+
+		// The markers that stand alone without a length
+		switch {
+		case marker == 0x00:
+			l.report.add(LINT_ERROR, offset, "stuffed 0x00 outside entropy coded data")
+			offset += 2
+			continue
+		case marker == MARKER_SOI:
+			l.report.add(LINT_ERROR, offset, "second SOI")
+			offset += 2
+			continue
+		case marker >= MARKER_RST0 && marker <= MARKER_RST7:
+			l.report.add(LINT_WARNING, offset, "%s outside entropy coded data", name)
+			offset += 2
+			continue
+		case marker == 0x01:
+			l.report.add(LINT_WARNING, offset, "TEM marker, which is only meant for private use")
+			offset += 2
+			continue
+		case name == "RES":
+			// Its length can't be trusted, so pick the walk up again at the next marker that means something
+			next := nextSegmentMarker(b, offset+2)
+			l.report.add(LINT_ERROR, offset, "unknown marker 0x%02x, skipped %d bytes to the next marker", marker, next-offset)
+			offset = next
+			continue
+		}
+
+		if offset+4 > len(b) {
+			l.report.add(LINT_ERROR, offset, "%s segment runs past end of file", name)
+			break
+		}
+
+		length := int(b[offset+2])<<8 | int(b[offset+3])
+
+		if length < 2 {
+			l.report.add(LINT_ERROR, offset, "%s segment length of %d is too short to hold the length", name, length)
+			break
+		}
+
+		if offset+2+length > len(b) {
+			l.report.add(LINT_ERROR, offset, "%s segment of %d bytes runs past end of file", name, length)
+			break
+		}
+
+		segment := NewSection(marker, b[offset+4:offset+2+length])
+		segment.Offset = offset
+
+		offset += 2 + length
+
+		l.checkSegment(segment, name)
+
+		if marker == MARKER_SOS {
+			end := entropyCodedDataEnd(b, offset)
+			l.checkRestartMarkers(offset, end)
+			offset = end
+		}
+	}
+
+	if l.frame == nil {
+		l.report.add(LINT_ERROR, -1, "no SOF")
+		return false
+	}
+
+	if l.scans == 0 {
+		l.report.add(LINT_ERROR, -1, "no SOS")
+		return false
+	}
+
+	return true
+}
+
+// Where the next marker that starts a segment, or EOI, is from offset on. The end of the file if there isn't one
+func nextSegmentMarker(b []byte, offset int) int {
+	for i := offset; i+1 < len(b); i++ {
+		m := b[i+1]
+
+		if b[i] == 0xFF && ((m >= 0xc0 && m <= 0xcf) || (m >= MARKER_EOI && m <= MARKER_COM)) {
+			return i
+		}
+	}
+
+	return len(b)
+}
+
+func (l *linter) checkTrailer(offset int) {
+	trailer := len(l.b) - offset
+
+	if trailer == 0 {
+		return
+	}
+
+	// Multi-Picture files keep their other images after the first EOI
+	if l.mpf {
+		l.report.add(LINT_INFO, offset, "%d bytes after EOI, which the MPF segment accounts for", trailer)
+		return
+	}
+
+	l.report.add(LINT_WARNING, offset, "%d bytes after EOI", trailer)
+}
+
+func (l *linter) checkSegment(s *Section, name string) {
+	switch {
+	case s.Type == MARKER_DHT:
+		l.checkHuffmanTables(s)
+	case s.Type == MARKER_DQT:
+		l.checkQuantizationTables(s)
+	case s.Type == MARKER_DRI:
+		if len(s.Body) != 2 {
+			l.report.add(LINT_ERROR, s.Offset, "DRI segment should be 4 bytes long, not %d", len(s.Body)+2)
+			return
+		}
+		l.restartInterval = int(s.Body[0])<<8 | int(s.Body[1])
+	case s.Type == MARKER_SOS:
+		l.checkScanHeader(s)
+	case s.Type == 0xcc:
+		l.report.add(LINT_WARNING, s.Offset, "DAC segment, but arithmetic coding isn't supported")
+	case s.Type == 0xdc:
+		l.report.add(LINT_WARNING, s.Offset, "DNL segment, which isn't supported")
+	case strings.HasPrefix(name, "SOF"):
+		l.checkFrameHeader(s, name)
+	case strings.HasPrefix(name, "JPG"):
+		l.report.add(LINT_WARNING, s.Offset, "%s segment, which is reserved for JPEG extensions", name)
+	case s.Type == MARKER_APP2 && bytes.HasPrefix(s.Body, mpfIdentifier):
+		l.mpf = true
+	}
+}
+
+// B.2.2
+func (l *linter) checkFrameHeader(s *Section, name string) {
+	if l.frame != nil {
+		l.report.add(LINT_ERROR, s.Offset, "second frame header %s", name)
+		return
+	}
+
+	if s.Type != MARKER_SOF0 && s.Type != MARKER_SOF1 && s.Type != MARKER_SOF2 {
+		l.report.add(LINT_ERROR, s.Offset, "%s frames aren't supported, only baseline, extended and progressive Huffman", name)
+	}
+
+	if len(s.Body) < 6 || len(s.Body) != 6+3*int(s.Body[5]) {
+		l.report.add(LINT_ERROR, s.Offset, "%s segment length doesn't match its component count", name)
+		return
+	}
+
+	if s.Body[0] != 8 {
+		l.report.add(LINT_ERROR, s.Offset, "%d bit samples aren't supported", s.Body[0])
+	}
+
+	f := &Frame{
+		YLines: int(s.Body[1])<<8 | int(s.Body[2]),
+		XLines: int(s.Body[3])<<8 | int(s.Body[4]),
+	}
+
+	if f.YLines == 0 {
+		l.report.add(LINT_ERROR, s.Offset, "height of 0 means a DNL segment gives it, which isn't supported")
+	}
+
+	if f.XLines == 0 {
+		l.report.add(LINT_ERROR, s.Offset, "width of 0")
+	}
+
+	n := int(s.Body[5])
+
+	if n != 1 && n != 3 {
+		l.report.add(LINT_WARNING, s.Offset, "%d components. Only grayscale and YCbCr decode", n)
+	}
+
+	for i := 0; i < n; i++ {
+		c := s.Body[6+3*i:]
+		h := int(c[1] >> 4)
+		v := int(c[1] & 0x0F)
+
+		if f.GetComponent(int(c[0])) != nil {
+			l.report.add(LINT_ERROR, s.Offset, "component id %d is used twice", c[0])
+		}
+
+		if h < 1 || h > 4 || v < 1 || v > 4 {
+			l.report.add(LINT_ERROR, s.Offset, "component %d has sampling factors %dx%d, they must be 1 to 4", c[0], h, v)
+			return
+		}
+
+		if c[2] > 3 {
+			l.report.add(LINT_ERROR, s.Offset, "component %d uses quantization table %d, ids only go to 3", c[0], c[2])
+		}
+
+		f.Components = append(f.Components, NewComponent(int(c[0]), h, v, int(c[2])))
+	}
+
+	l.frame = f
+	l.frameMarker = s.Type
+}
+
+// B.2.4.1
+func (l *linter) checkQuantizationTables(s *Section) {
+	for offset := 0; offset < len(s.Body); {
+		pq := int(s.Body[offset] >> 4)
+		tq := int(s.Body[offset] & 0x0F)
+
+		size := 64
+		if pq == 1 {
+			size = 128
+		}
+
+		switch {
+		case pq > 1:
+			l.report.add(LINT_ERROR, s.Offset, "quantization table %d has precision %d, it must be 0 or 1", tq, pq)
+			return
+		case pq == 1 && l.frameMarker == MARKER_SOF0:
+			l.report.add(LINT_ERROR, s.Offset, "quantization table %d has 16 bit entries, which baseline doesn't allow", tq)
+		case pq == 1:
+			l.report.add(LINT_WARNING, s.Offset, "quantization table %d has 16 bit entries, which the decoder doesn't read", tq)
+		}
+
+		if tq > 3 {
+			l.report.add(LINT_ERROR, s.Offset, "quantization table id %d, ids only go to 3", tq)
+		}
+
+		if offset+1+size > len(s.Body) {
+			l.report.add(LINT_ERROR, s.Offset, "DQT segment ends in the middle of table %d", tq)
+			return
+		}
+
+		// 16 bit entries are big endian (B.2.4.1)
+		for k := 0; k < 64; k++ {
+			q := int(s.Body[offset+1+k])
+			if pq == 1 {
+				q = int(s.Body[offset+1+2*k])<<8 | int(s.Body[offset+2+2*k])
+			}
+
+			if q == 0 {
+				l.report.add(LINT_ERROR, s.Offset, "quantization table %d has a step of 0", tq)
+				break
+			}
+		}
+
+		l.quantization[tq] = true
+		offset += 1 + size
+	}
+}
+
+// B.2.4.2
+func (l *linter) checkHuffmanTables(s *Section) {
+	for offset := 0; offset < len(s.Body); {
+		class := int(s.Body[offset] >> 4)
+		id := int(s.Body[offset] & 0x0F)
+
+		if class > 1 || id > 3 {
+			l.report.add(LINT_ERROR, s.Offset, "Huffman table class %d id %d, class must be 0 or 1 and id 0 to 3", class, id)
+		}
+
+		if offset+17 > len(s.Body) {
+			l.report.add(LINT_ERROR, s.Offset, "DHT segment ends in the middle of a table's BITS")
+			return
+		}
+
+		bits := make(map[int]int)
+		total := 0
+		for i := 1; i <= 16; i++ {
+			bits[i] = int(s.Body[offset+i])
+			total += bits[i]
+		}
+
+		if offset+17+total > len(s.Body) {
+			l.report.add(LINT_ERROR, s.Offset, "DHT segment ends in the middle of a table's HUFFVAL")
+			return
+		}
+
+		huffVal := make([]int, total)
+		for i := range huffVal {
+			huffVal[i] = int(s.Body[offset+17+i])
+		}
+
+		if err := huffman.ValidateTable(bits, huffVal); err != nil {
+			l.report.add(LINT_ERROR, s.Offset, "%s table %d: %v", tableClassName(class), id, err)
+		}
+
+		l.huffmanTables[class<<4|id] = true
+		offset += 17 + total
+	}
+}
+
+func tableClassName(class int) string {
+	if class == huffman.TARGET_DC {
+		return "DC"
+	}
+
+	return "AC"
+}
+
+// B.2.3, and G.1.1.1 for progressive scans
+func (l *linter) checkScanHeader(s *Section) {
+	l.scans++
+
+	if l.frame == nil {
+		l.report.add(LINT_ERROR, s.Offset, "SOS before any SOF")
+		return
+	}
+
+	if len(s.Body) < 1 || len(s.Body) != 4+2*int(s.Body[0]) {
+		l.report.add(LINT_ERROR, s.Offset, "SOS segment length doesn't match its component count")
+		return
+	}
+
+	n := int(s.Body[0])
+	if n < 1 || n > 4 {
+		l.report.add(LINT_ERROR, s.Offset, "scan has %d components, it must have 1 to 4", n)
+		return
+	}
+
+	ss := int(s.Body[1+2*n])
+	se := int(s.Body[2+2*n])
+	ah := int(s.Body[3+2*n] >> 4)
+	al := int(s.Body[3+2*n] & 0x0F)
+
+	progressive := l.frameMarker == MARKER_SOF2
+
+	if !progressive && (ss != 0 || se != 63 || ah != 0 || al != 0) {
+		l.report.add(LINT_WARNING, s.Offset, "sequential scan has Ss %d, Se %d, Ah %d, Al %d instead of 0, 63, 0, 0", ss, se, ah, al)
+	}
+
+	if progressive {
+		switch {
+		case ss == 0 && se != 0:
+			l.report.add(LINT_ERROR, s.Offset, "DC scan has Se %d, it must be 0", se)
+		case ss > 0 && (se < ss || se > 63):
+			l.report.add(LINT_ERROR, s.Offset, "AC scan has Ss %d and Se %d", ss, se)
+		case ss > 0 && n != 1:
+			l.report.add(LINT_ERROR, s.Offset, "AC scan has %d components, it can only have one", n)
+		}
+
+		if ah != 0 && al != ah-1 {
+			l.report.add(LINT_ERROR, s.Offset, "refinement scan has Ah %d and Al %d, Al must be Ah - 1", ah, al)
+		}
+	}
+
+	blocks := 0
+	scanComponents := make([]*Component, 0, n)
+
+	for i := 0; i < n; i++ {
+		id := int(s.Body[1+2*i])
+		td := int(s.Body[2+2*i] >> 4)
+		ta := int(s.Body[2+2*i] & 0x0F)
+
+		c := l.frame.GetComponent(id)
+		if c == nil {
+			l.report.add(LINT_ERROR, s.Offset, "scan references component %d, which isn't in the frame", id)
+			continue
+		}
+
+		scanComponents = append(scanComponents, c)
+		blocks += c.H * c.V
+
+		if !l.quantization[c.Tq] {
+			l.report.add(LINT_ERROR, s.Offset, "component %d uses quantization table %d, which isn't defined", id, c.Tq)
+		}
+
+		if l.frameMarker == MARKER_SOF0 && (td > 1 || ta > 1) {
+			l.report.add(LINT_ERROR, s.Offset, "component %d uses Huffman tables %d and %d, baseline only has 0 and 1", id, td, ta)
+		}
+
+		// Progressive DC refinement needs no tables and each kind of scan needs only one
+		needsDC := ss == 0 && !(progressive && ah != 0)
+		needsAC := se > 0
+
+		if needsDC && !l.huffmanTables[huffman.TARGET_DC<<4|td] {
+			l.report.add(LINT_ERROR, s.Offset, "component %d uses DC table %d, which isn't defined", id, td)
+			l.missingTables[l.scans-1] = true
+		}
+
+		if needsAC && !l.huffmanTables[huffman.TARGET_AC<<4|ta] {
+			l.report.add(LINT_ERROR, s.Offset, "component %d uses AC table %d, which isn't defined", id, ta)
+			l.missingTables[l.scans-1] = true
+		}
+	}
+
+	if n > 1 && blocks > 10 {
+		l.report.add(LINT_ERROR, s.Offset, "interleaved scan has %d blocks per MCU, at most 10 are allowed", blocks)
+	}
+
+	l.frame.ScanComponents = scanComponents
+}
+
+// Restart markers count 0 to 7 and wrap, and there's one between each pair of intervals (F.1.2.3)
+func (l *linter) checkRestartMarkers(start int, end int) {
+	if l.frame == nil || len(l.frame.ScanComponents) == 0 {
+		return
+	}
+
+	// Which interval the next marker ends, counting the ones that went missing
+	position := 0
+
+	for i := start; i+1 < end; i++ {
+		if l.b[i] != 0xFF {
+			continue
+		}
+
+		next := l.b[i+1]
+		i++
+
+		// Fill bytes ahead of the marker that ends the scan
+		if next == 0x00 || next == 0xFF {
+			continue
+		}
+
+		// The scan only ends at a marker with a segment, so anything else is damage in the middle of it
+		if next < MARKER_RST0 || next > MARKER_RST7 {
+			l.report.add(LINT_ERROR, i-1, "unknown marker 0x%02x in the entropy coded data", next)
+			l.damagedMarkers = append(l.damagedMarkers, i-1)
+			continue
+		}
+
+		number := int(next - MARKER_RST0)
+
+		if l.restartInterval == 0 {
+			l.report.add(LINT_ERROR, i-1, "%s in a scan without a restart interval", MarkerName(next))
+		} else if number != position%8 {
+			// Pick the count back up from this marker the same way the parser does, unless the marker after it
+			// doesn't carry on from it, when it's only this marker's number that's wrong
+			skipped := (number - position%8 + 8) % 8
+			if following := nextRestartNumber(l.b[:end], i+1); following >= 0 && following != (number+1)%8 {
+				skipped = 0
+				l.report.add(LINT_ERROR, i-1, "%s where RST%d should be", MarkerName(next), position%8)
+			} else {
+				l.report.add(LINT_ERROR, i-1, "%s where RST%d should be, %d restart intervals are missing", MarkerName(next), position%8, skipped)
+			}
+
+			position += skipped
+		}
+
+		position++
+	}
+
+	if l.restartInterval == 0 {
+		return
+	}
+
+	expected := ceilDiv(l.frame.ScanMCUs(), l.restartInterval) - 1
+
+	if position != expected {
+		l.report.add(LINT_ERROR, start, "scan has %d restart intervals, its %d MCUs in intervals of %d need %d", position+1, l.frame.ScanMCUs(), l.restartInterval, expected+1)
+	}
+}
+
+// Decodes each interval on its own, which shows which parts of the data are bad and whether each interval holds
+// the number of MCUs it should
+func (l *linter) checkEntropyCodedData() {
+	j, err := parseRecovering(l.b)
+	if _, isLimit := err.(*LimitError); isLimit {
+		l.report.add(LINT_WARNING, -1, "entropy coded data not checked: %v", err)
+		return
+	}
+	// Whatever the parser stops at, the walk has already reported as an error
+	if err != nil && l.report.Count(LINT_ERROR) > 0 {
+		l.report.add(LINT_WARNING, -1, "entropy coded data not checked, the parser stops: %v", err)
+		return
+	}
+	if err != nil {
+		l.report.add(LINT_ERROR, -1, "the parser stops: %v", err)
+		return
+	}
+
+	planes := j.newComponentPlanes()
+
+	for si, scan := range j.Scans {
+		// Every interval would stop at its first block for want of the table, which the walk has reported
+		if l.missingTables[si] {
+			continue
+		}
+
+		j.ActivateScan(scan)
+
+		// The scan data starts after the SOS marker, its length and its body
+		dataOffset := scan.Offset + 4 + len(scan.Header)
+
+		for _, interval := range j.Intervals {
+			// Damaged intervals are missing restart markers, which the walk has already reported
+			if interval.Damaged {
+				continue
+			}
+
+			interval.Reset()
+
+			start := dataOffset + interval.DataOffset
+
+			// A damaged marker stops the decode, and the walk has already reported it
+			if l.hasDamagedMarker(start, start+len(interval.Body)) {
+				continue
+			}
+
+			if err := j.decodeRecovering(scan, interval, planes); err != nil {
+				l.report.add(LINT_ERROR, start+interval.position(), "scan %d stops decoding after MCU %d of the interval starting at MCU %d: %v", si, interval.MCUOffset+interval.decodedMCUs, interval.MCUOffset, err)
+				continue
+			}
+
+			l.checkBitsPastEOB(si, interval, start)
+		}
+	}
+}
+
+func (l *linter) hasDamagedMarker(start int, end int) bool {
+	for _, offset := range l.damagedMarkers {
+		if offset >= start && offset < end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// What follows the last block of an interval should only be the 1 bits that pad out its last byte (F.1.2.3).
+// Whole bytes after that are data the MCU count has no room for
+func (l *linter) checkBitsPastEOB(si int, interval *Interval, start int) {
+	leftover, padding, paddingBits := interval.leftover()
+	end := start + interval.position()
+
+	if int(padding) != 1<<uint(paddingBits)-1 {
+		l.report.add(LINT_WARNING, end, "scan %d interval starting at MCU %d has 0 bits after its last MCU where padding should be all 1s", si, interval.MCUOffset)
+	}
+
+	if leftover == 0 {
+		return
+	}
+
+	rest := interval.Body[len(interval.Body)-leftover:]
+
+	for i, b := range rest {
+		if b != 0 {
+			l.report.add(LINT_ERROR, end+1+i, "scan %d interval starting at MCU %d has non zero bits past the EOB of its last MCU, %d bytes of data its %d MCUs don't account for", si, interval.MCUOffset, leftover, interval.MCUs)
+			return
+		}
+	}
+
+	l.report.add(LINT_WARNING, end+1, "scan %d interval starting at MCU %d has %d zero bytes after its %d MCUs", si, interval.MCUOffset, leftover, interval.MCUs)
+}
+
+func parseRecovering(b []byte) (j *JpegParser, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	return NewJpegParserFromBytes(b)
+}
+
+func (j *JpegParser) decodeRecovering(scan *Scan, interval *Interval, planes []*componentPlane) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	if j.Progressive {
+		j.decodeProgressiveInterval(scan, interval, planes)
+	} else {
+		j.decodeInterval(interval, planes)
+	}
+
+	return nil
+}
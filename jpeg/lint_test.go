@@ -0,0 +1,174 @@
+package jpeg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func lintIssue(r *LintReport, severity string, message string) *LintIssue {
+	for i, issue := range r.Issues {
+		if issue.Severity == severity && strings.Contains(issue.Message, message) {
+			return &r.Issues[i]
+		}
+	}
+
+	return nil
+}
+
+// A 16 bit entry is only a step of 0 when both of its bytes are
+func TestLintQuantizationStepOfZero(t *testing.T) {
+	table16 := func(zeroAt int) []byte {
+		body := []byte{0x10}
+		for k := 0; k < 64; k++ {
+			if k == zeroAt {
+				body = append(body, 0, 0)
+			} else {
+				body = append(body, 1, 0)
+			}
+		}
+		return body
+	}
+
+	table8 := append([]byte{0x00}, bytes.Repeat([]byte{1}, 64)...)
+	table8[40] = 0
+
+	cases := []struct {
+		name string
+		body []byte
+		zero bool
+	}{
+		{"16 bit, low bytes 0", table16(-1), false},
+		{"16 bit, first entry 0", table16(0), true},
+		{"16 bit, last entry 0", table16(63), true},
+		{"8 bit, an entry 0", table8, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := &linter{report: &LintReport{}, quantization: make(map[int]bool)}
+			l.checkQuantizationTables(NewSection(MARKER_DQT, c.body))
+
+			if zero := lintIssue(l.report, LINT_ERROR, "step of 0") != nil; zero != c.zero {
+				t.Errorf("step of 0 reported %v, %+v", zero, l.report.Issues)
+			}
+		})
+	}
+}
+
+// Bytes slipped in ahead of the first restart marker are reported where they are, as an error when they have any
+// bits set
+func TestLintBitsPastEOB(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, testImage(32, 16, true, 3), &EncodeOptions{RestartInterval: 1}); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	if report := Lint(b); len(report.Issues) != 0 {
+		t.Fatalf("the encoder's file has issues: %+v", report.Issues)
+	}
+
+	rst := bytes.Index(b, []byte{0xFF, MARKER_RST0})
+
+	cases := []struct {
+		name     string
+		inserted []byte
+		severity string
+		message  string
+	}{
+		{"zero bytes", []byte{0, 0}, LINT_WARNING, "2 zero bytes after its 1 MCUs"},
+		{"data", []byte{0, 0x5A}, LINT_ERROR, "non zero bits past the EOB"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			damaged := append(append(append([]byte{}, b[:rst]...), c.inserted...), b[rst:]...)
+
+			issue := lintIssue(Lint(damaged), c.severity, c.message)
+			if issue == nil {
+				t.Fatalf("no %s with %q", c.severity, c.message)
+			}
+
+			// The zero byte ahead of the data is skipped over
+			want := rst
+			if c.severity == LINT_ERROR {
+				want = rst + 1
+			}
+			if issue.Offset != want {
+				t.Errorf("reported at 0x%x, not 0x%x", issue.Offset, want)
+			}
+		})
+	}
+}
+
+func TestLintDamage(t *testing.T) {
+	b, markers := testRestartFile(t)
+
+	if report := Lint(b); len(report.Issues) != 0 {
+		t.Fatalf("the encoder's file has issues: %+v", report.Issues)
+	}
+
+	edit := func(f func(c []byte) []byte) []byte {
+		return f(append([]byte{}, b...))
+	}
+
+	insert := func(at int, inserted ...byte) []byte {
+		return edit(func(c []byte) []byte {
+			return append(c[:at], append(inserted, c[at:]...)...)
+		})
+	}
+
+	withoutSegments := func(marker byte) []byte {
+		l := testSegmentList(t, b)
+		l.Filter(func(s *Section) bool { return s.Type != marker })
+
+		out, err := l.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	jfifEnd := 4 + (int(b[4])<<8 | int(b[5]))
+
+	cases := []struct {
+		name     string
+		file     []byte
+		severity string
+		message  string
+		// Issues of that severity, so the damage is only reported once
+		count int
+	}{
+		{"data after EOI", append(append([]byte{}, b...), "trailer"...), LINT_WARNING, "7 bytes after EOI", 1},
+		{"no DQT", withoutSegments(MARKER_DQT), LINT_ERROR, "component 1 uses quantization table 0, which isn't defined", 1},
+		// One for each of its tables
+		{"no DHT", withoutSegments(MARKER_DHT), LINT_ERROR, "component 1 uses DC table 0, which isn't defined", 2},
+		// Interval 2 holds the data of interval 3 as well, which its MCUs don't account for
+		{"deleted RST2", edit(func(c []byte) []byte {
+			return append(c[:markers[2]], c[markers[2]+2:]...)
+		}), LINT_ERROR, "RST3 where RST2 should be, 1 restart intervals are missing", 2},
+		{"swapped RST2 and RST3", edit(func(c []byte) []byte {
+			c[markers[2]+1], c[markers[3]+1] = c[markers[3]+1], c[markers[2]+1]
+			return c
+		}), LINT_ERROR, "RST3 where RST2 should be", 2},
+		// The last interval holds the data of two, so there are bits its MCUs don't account for as well
+		{"deleted RST6", edit(func(c []byte) []byte {
+			return append(c[:markers[6]], c[markers[6]+2:]...)
+		}), LINT_ERROR, "scan has 7 restart intervals, its 64 MCUs in intervals of 8 need 8", 2},
+		{"unknown marker in the scan", insert((markers[3]+markers[4])/2, 0xFF, 0x13), LINT_ERROR, "unknown marker 0x13 in the entropy coded data", 1},
+		{"unknown marker after the scan", insert(len(b)-2, 0xFF, 0x13, 0x00, 0x02), LINT_ERROR, "unknown marker 0x13 in the entropy coded data", 1},
+		{"unknown marker between segments", insert(jfifEnd, 0xFF, 0x13, 0x00, 0x40, 0xFF, 0x00, 0x12), LINT_ERROR, "unknown marker 0x13, skipped 7 bytes", 1},
+		{"bytes between segments", insert(jfifEnd, 0x12, 0xFF, 0x00, 0x34), LINT_ERROR, "4 bytes that aren't part of any segment", 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			report := Lint(c.file)
+
+			if lintIssue(report, c.severity, c.message) == nil || report.Count(c.severity) != c.count {
+				t.Errorf("no %s with %q, or not %d of them: %+v", c.severity, c.message, c.count, report.Issues)
+			}
+		})
+	}
+}
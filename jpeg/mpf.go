@@ -0,0 +1,181 @@
+package jpeg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Multi-Picture Format, CIPA DC-007. The first image carries an APP2 "MPF\0" segment holding a TIFF structure whose
+// first IFD is the MP Index IFD. Its MP Entry tag lists every image in the file with an offset that is relative to
+// the TIFF header in that segment. The other images are complete JPEGs that follow the first one's EOI
+
+var mpfIdentifier = []byte("MPF\x00")
+
+const (
+	TAG_MPF_VERSION      int = 0xB000
+	TAG_NUMBER_OF_IMAGES int = 0xB001
+	TAG_MP_ENTRY         int = 0xB002
+
+	// MP type codes from table 4 of DC-007
+	MP_TYPE_UNDEFINED         int = 0x000000
+	MP_TYPE_LARGE_THUMBNAIL_1 int = 0x010001
+	MP_TYPE_LARGE_THUMBNAIL_2 int = 0x010002
+	MP_TYPE_PANORAMA          int = 0x020001
+	MP_TYPE_DISPARITY         int = 0x020002
+	MP_TYPE_MULTI_ANGLE       int = 0x020003
+	MP_TYPE_BASELINE_PRIMARY  int = 0x030000
+
+	mpEntrySize = 16
+)
+
+var mpTypeNames = map[int]string{
+	MP_TYPE_UNDEFINED:         "undefined",
+	MP_TYPE_LARGE_THUMBNAIL_1: "large thumbnail (VGA)",
+	MP_TYPE_LARGE_THUMBNAIL_2: "large thumbnail (full HD)",
+	MP_TYPE_PANORAMA:          "multi-frame panorama",
+	MP_TYPE_DISPARITY:         "multi-frame disparity",
+	MP_TYPE_MULTI_ANGLE:       "multi-frame multi-angle",
+	MP_TYPE_BASELINE_PRIMARY:  "baseline MP primary",
+}
+
+// One entry of the MP Index IFD
+type MpImage struct {
+	Index int
+	// Individual image attribute flags
+	DependentParent bool
+	DependentChild  bool
+	Representative  bool
+	// 0 is JPEG, nothing else is defined
+	Format int
+	Type   int
+	// Absolute offset into the file and size in bytes
+	Offset int
+	Size   int
+	// 1 based entry numbers of dependent images, 0 if none
+	DependentImage1 int
+	DependentImage2 int
+	// The whole JPEG stream of this image, SOI to EOI
+	Data []byte
+}
+
+func IsMpf(body []byte) bool {
+	return bytes.HasPrefix(body, mpfIdentifier)
+}
+
+func (m *MpImage) TypeName() string {
+	name, present := mpTypeNames[m.Type]
+	if !present {
+		return fmt.Sprintf("unknown (0x%06x)", m.Type)
+	}
+
+	return name
+}
+
+// Parses the image on its own so it can be decoded with this decoder
+func (m *MpImage) Parser() (*JpegParser, error) {
+	return NewJpegParserFromBytes(m.Data)
+}
+
+// Reads the MP Index IFD from the first image's header segments. The file's raw bytes are needed since the other
+// images live after the first one's EOI. Returns nil if the file isn't MPF
+func ReadMPF(b []byte) ([]*MpImage, error) {
+	segments, err := ReadHeaderSegments(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return mpImagesFromSegments(segments, b)
+}
+
+func (j *JpegParser) MPImages() ([]*MpImage, error) {
+	rawBytes := make([]byte, j.ByteReader.Size())
+
+	if _, err := j.ByteReader.ReadAt(rawBytes, 0); err != nil {
+		return nil, err
+	}
+
+	return mpImagesFromSegments(j.Segments, rawBytes)
+}
+
+func mpImagesFromSegments(segments []*Section, b []byte) ([]*MpImage, error) {
+	for _, s := range segments {
+		if s.Type == MARKER_APP2 && IsMpf(s.Body) {
+			// Marker, length and identifier come before the TIFF header
+			base := s.Offset + 4 + len(mpfIdentifier)
+			return readMpIndex(s.Body[len(mpfIdentifier):], base, b)
+		}
+	}
+
+	return nil, nil
+}
+
+func readMpIndex(body []byte, base int, b []byte) ([]*MpImage, error) {
+	t, err := NewTiffReader(body)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, _, err := t.ReadIFD(t.FirstIFDOffset())
+	if err != nil {
+		return nil, err
+	}
+
+	countEntry := FindEntry(entries, TAG_NUMBER_OF_IMAGES)
+	mpEntry := FindEntry(entries, TAG_MP_ENTRY)
+
+	if countEntry == nil || mpEntry == nil {
+		return nil, errors.New("MP Index IFD is missing NumberOfImages or MPEntry")
+	}
+
+	count, err := t.Uint(countEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	if mpEntry.Size() != count*mpEntrySize || mpEntry.ValueOffset+mpEntry.Size() > len(t.Body) {
+		return nil, errors.New("MPEntry doesn't match NumberOfImages")
+	}
+
+	images := make([]*MpImage, 0, count)
+
+	for i := 0; i < count; i++ {
+		e := t.Body[mpEntry.ValueOffset+i*mpEntrySize:]
+
+		attribute := int(t.Order.Uint32(e[0:]))
+
+		m := &MpImage{
+			Index:           i,
+			DependentParent: attribute&(1<<31) != 0,
+			DependentChild:  attribute&(1<<30) != 0,
+			Representative:  attribute&(1<<29) != 0,
+			Format:          (attribute >> 24) & 0x07,
+			Type:            attribute & 0xFFFFFF,
+			Size:            int(t.Order.Uint32(e[4:])),
+			DependentImage1: int(t.Order.Uint16(e[12:])),
+			DependentImage2: int(t.Order.Uint16(e[14:])),
+		}
+
+		// The first image always has an offset of 0 since it's the one holding this segment
+		offset := int(t.Order.Uint32(e[8:]))
+		if i == 0 {
+			m.Offset = 0
+		} else {
+			m.Offset = base + offset
+		}
+
+		if m.Offset+m.Size > len(b) {
+			return nil, fmt.Errorf("MP image %d runs past the end of the file", i)
+		}
+
+		m.Data = b[m.Offset : m.Offset+m.Size]
+
+		if len(m.Data) < 2 || m.Data[0] != 0xFF || m.Data[1] != MARKER_SOI {
+			return nil, fmt.Errorf("MP image %d doesn't start with SOI", i)
+		}
+
+		images = append(images, m)
+	}
+
+	return images, nil
+}
@@ -0,0 +1,211 @@
+package jpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type testIfdEntry struct {
+	tag   int
+	typ   int
+	count int
+	value []byte
+	// Index of the IFD a pointer entry points at, when value is nil
+	ifd int
+}
+
+// A TIFF structure with the IFDs one after another, each followed by the values that don't fit in its entries
+func buildTiff(order binary.ByteOrder, ifds [][]testIfdEntry) []byte {
+	offsets := make([]int, len(ifds))
+	offset := 8
+
+	for i, entries := range ifds {
+		offsets[i] = offset
+		offset += 2 + 12*len(entries) + 4
+
+		for _, e := range entries {
+			if len(e.value) > 4 {
+				offset += len(e.value)
+			}
+		}
+	}
+
+	tiff := make([]byte, offset)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], uint32(offsets[0]))
+
+	for i, entries := range ifds {
+		order.PutUint16(tiff[offsets[i]:], uint16(len(entries)))
+		data := offsets[i] + 2 + 12*len(entries) + 4
+
+		for k, e := range entries {
+			entry := tiff[offsets[i]+2+12*k:]
+			order.PutUint16(entry, uint16(e.tag))
+			order.PutUint16(entry[2:], uint16(e.typ))
+			order.PutUint32(entry[4:], uint32(e.count))
+
+			switch {
+			case e.value == nil:
+				order.PutUint32(entry[8:], uint32(offsets[e.ifd]))
+			case len(e.value) > 4:
+				order.PutUint32(entry[8:], uint32(data))
+				data += copy(tiff[data:], e.value)
+			default:
+				copy(entry[8:], e.value)
+			}
+		}
+	}
+
+	return tiff
+}
+
+type testMpEntry struct {
+	attribute int
+	size      int
+	offset    int
+}
+
+// The primary image with an APP2 MPF segment listing entries straight after SOI, and then the rest of the images
+func buildMpf(order binary.ByteOrder, primary []byte, entries []testMpEntry, rest ...[]byte) []byte {
+	value := make([]byte, 0, len(entries)*mpEntrySize)
+	for _, e := range entries {
+		entry := make([]byte, mpEntrySize)
+		order.PutUint32(entry, uint32(e.attribute))
+		order.PutUint32(entry[4:], uint32(e.size))
+		order.PutUint32(entry[8:], uint32(e.offset))
+		value = append(value, entry...)
+	}
+
+	count := make([]byte, 4)
+	order.PutUint32(count, uint32(len(entries)))
+
+	tiff := buildTiff(order, [][]testIfdEntry{{
+		{tag: TAG_MPF_VERSION, typ: TIFF_TYPE_UNDEFINED, count: 4, value: []byte("0100")},
+		{tag: TAG_NUMBER_OF_IMAGES, typ: TIFF_TYPE_LONG, count: 1, value: count},
+		{tag: TAG_MP_ENTRY, typ: TIFF_TYPE_UNDEFINED, count: len(value), value: value},
+	}})
+
+	length := 2 + len(mpfIdentifier) + len(tiff)
+
+	b := append([]byte{}, primary[:2]...)
+	b = append(b, 0xFF, MARKER_APP2, byte(length>>8), byte(length))
+	b = append(b, mpfIdentifier...)
+	b = append(b, tiff...)
+	b = append(b, primary[2:]...)
+
+	for _, r := range rest {
+		b = append(b, r...)
+	}
+
+	return b
+}
+
+// Offsets of the other images count from the TIFF header inside the MPF segment, which is after SOI, the marker,
+// its length and the identifier
+const testMpfBase = 2 + 4 + 4
+
+// spec.jpg twice over, the second copy as a large thumbnail
+func testMpfImages(t *testing.T) ([]byte, []byte, int) {
+	spec, err := ioutil.ReadFile("../spec.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Where the second image starts doesn't depend on the entries' values
+	start := len(buildMpf(binary.BigEndian, spec, make([]testMpEntry, 2)))
+
+	return spec, spec, start
+}
+
+func TestMPF(t *testing.T) {
+	primary, second, start := testMpfImages(t)
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(fmt.Sprint(order), func(t *testing.T) {
+			entries := []testMpEntry{
+				// The primary's offset is always read as 0, whatever the file says
+				{1<<29 | MP_TYPE_BASELINE_PRIMARY, start, 1234},
+				{MP_TYPE_LARGE_THUMBNAIL_1, len(second), start - testMpfBase},
+			}
+			b := buildMpf(order, primary, entries, second)
+
+			images, err := ReadMPF(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			j, err := NewJpegParserFromBytes(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromParser, err := j.MPImages()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(images) != 2 || len(fromParser) != 2 {
+				t.Fatalf("got %d and %d images", len(images), len(fromParser))
+			}
+
+			got := fmt.Sprintf("%d %d %v %s / %d %d %v %s",
+				images[0].Offset, images[0].Size, images[0].Representative, images[0].TypeName(),
+				images[1].Offset, images[1].Size, images[1].Representative, images[1].TypeName())
+			want := fmt.Sprintf("0 %d true baseline MP primary / %d %d false large thumbnail (VGA)", start, start, len(second))
+			if got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+
+			for i := range images {
+				if images[i].Offset != fromParser[i].Offset || images[i].Size != fromParser[i].Size {
+					t.Errorf("image %d differs between ReadMPF and MPImages", i)
+				}
+			}
+
+			if !bytes.Equal(images[1].Data, second) {
+				t.Fatal("second image's data isn't the image that was appended")
+			}
+
+			p, err := images[1].Parser()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.XLines != 1676 || p.YLines != 866 {
+				t.Errorf("second image is %dx%d", p.XLines, p.YLines)
+			}
+		})
+	}
+}
+
+func TestMPFBadOffsets(t *testing.T) {
+	primary, second, start := testMpfImages(t)
+
+	cases := []struct {
+		name   string
+		second testMpEntry
+		err    string
+	}{
+		// Treating the offset as absolute lands 10 bytes early
+		{"absolute offset", testMpEntry{MP_TYPE_LARGE_THUMBNAIL_1, len(second), start}, "past the end"},
+		{"offset short of the image", testMpEntry{MP_TYPE_LARGE_THUMBNAIL_1, len(second), start - testMpfBase - 1}, "SOI"},
+		{"size past the end", testMpEntry{MP_TYPE_LARGE_THUMBNAIL_1, len(second) + 1, start - testMpfBase}, "past the end"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := buildMpf(binary.BigEndian, primary, []testMpEntry{{MP_TYPE_BASELINE_PRIMARY, start, 0}, c.second}, second)
+
+			if _, err := ReadMPF(b); err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("got %v, want an error with %q", err, c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package jpeg
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// A copy of the decoded image with the structure of the file drawn over it, for working out where a decode went
+// wrong. Restart intervals of the first scan are tinted in turn green, orange, cyan and yellow, damaged MCUs are
+// red, the MCU grid is magenta, and luma blocks with a DC and nothing else get a blue dot. Only luma is marked since
+// a chroma block's dot would sit on top of the luma ones it covers
+
+var overlayIntervalColours = []color.RGBA{
+	{0, 200, 0, 255},
+	{255, 160, 0, 255},
+	{0, 200, 220, 255},
+	{230, 230, 0, 255},
+}
+
+var (
+	overlayDamageColour = color.RGBA{255, 0, 0, 255}
+	overlayGridColour   = color.RGBA{255, 0, 255, 255}
+	overlayDCColour     = color.RGBA{0, 0, 255, 255}
+)
+
+// DecodeWithOptions, also returning the overlay. The damaged MCUs are the ones in the error, so they only show up
+// when the file is truncated or the decode is tolerant, since otherwise corrupt data stops the decode
+func (j *JpegParser) DecodeWithOverlay(opts *DecodeOptions) (*image.RGBA, *image.RGBA, error) {
+	img, planes, decodeErr := j.decode(opts)
+	if img == nil {
+		return nil, nil, decodeErr
+	}
+
+	overlay := image.NewRGBA(img.Bounds())
+	draw.Draw(overlay, overlay.Bounds(), img, image.Point{}, draw.Src)
+
+	j.drawIntervals(overlay)
+	j.drawDamage(overlay, decodeErr)
+	j.drawMCUGrid(overlay)
+	j.drawDCOnlyBlocks(overlay, planes[0])
+
+	if opts.ApplyAspectRatio && j.JFIF != nil {
+		img = applyAspectRatio(img, j.JFIF)
+		overlay = applyAspectRatio(overlay, j.JFIF)
+	}
+
+	return img, overlay, decodeErr
+}
+
+// A sequential file usually has a single scan. The restart interval can change from scan to scan, but the first
+// one is enough to see where each interval starts
+func (j *JpegParser) drawIntervals(img *image.RGBA) {
+	if len(j.Scans) == 0 {
+		return
+	}
+
+	j.ActivateScan(j.Scans[0])
+
+	for i, interval := range j.Intervals {
+		for m := interval.MCUOffset; m < interval.MCUOffset+interval.MCUs; m++ {
+			blend(img, j.scanMCURect(m), overlayIntervalColours[i%len(overlayIntervalColours)], 0.3)
+		}
+	}
+}
+
+func (j *JpegParser) drawDamage(img *image.RGBA, decodeErr error) {
+	switch e := decodeErr.(type) {
+	case *CorruptDataError:
+		for _, r := range e.Damaged {
+			j.ActivateScan(j.Scans[r.Scan])
+
+			for m := r.First; m < r.First+r.Count; m++ {
+				blend(img, j.scanMCURect(m), overlayDamageColour, 0.5)
+			}
+		}
+	case *TruncatedError:
+		_, mcuHeight := j.frameMCUSize()
+		blend(img, image.Rect(0, e.ValidMCURows*mcuHeight, j.XLines, j.YLines), overlayDamageColour, 0.5)
+	}
+}
+
+func (j *JpegParser) drawMCUGrid(img *image.RGBA) {
+	mcuWidth, mcuHeight := j.frameMCUSize()
+
+	for col := 1; col < j.frameMCUCols(); col++ {
+		blend(img, image.Rect(col*mcuWidth, 0, col*mcuWidth+1, j.YLines), overlayGridColour, 0.6)
+	}
+
+	for row := 1; row < j.frameMCURows(); row++ {
+		blend(img, image.Rect(0, row*mcuHeight, j.XLines, row*mcuHeight+1), overlayGridColour, 0.6)
+	}
+}
+
+// A 2x2 dot in the middle of each block of the first component whose AC coefficients are all zero. A block that's
+// all zero, DC included, is left alone
+func (j *JpegParser) drawDCOnlyBlocks(img *image.RGBA, p *componentPlane) {
+	cols, rows := j.ComponentBlocks(p.component)
+	width, height := j.blockSize(p.component)
+
+	for index, coefficients := range p.coefficients {
+		row := index / p.blockCols
+		col := index % p.blockCols
+
+		if row >= rows || col >= cols {
+			continue
+		}
+
+		dcOnly := coefficients[0] != 0
+		for k := 1; k < 64; k++ {
+			if coefficients[k] != 0 {
+				dcOnly = false
+				break
+			}
+		}
+
+		if dcOnly {
+			x := col*width + width/2 - 1
+			y := row*height + height/2 - 1
+			blend(img, image.Rect(x, y, x+2, y+2), overlayDCColour, 1)
+		}
+	}
+}
+
+// Pixels across and down one block of a component once it's upsampled
+func (f *Frame) blockSize(c *Component) (int, int) {
+	if len(f.Components) == 1 {
+		return 8, 8
+	}
+
+	hMax, vMax := f.MaxSampling()
+
+	return 8 * hMax / c.H, 8 * vMax / c.V
+}
+
+// The pixels MCU m of the active scan covers. A scan with one component has an MCU per block of it (A.2.2)
+func (j *JpegParser) scanMCURect(m int) image.Rectangle {
+	var width, height, cols int
+
+	if len(j.ScanComponents) == 1 {
+		c := j.ScanComponents[0]
+		cols, _ = j.ComponentBlocks(c)
+		width, height = j.blockSize(c)
+	} else {
+		cols = j.frameMCUCols()
+		width, height = j.frameMCUSize()
+	}
+
+	x := (m % cols) * width
+	y := (m / cols) * height
+
+	return image.Rect(x, y, x+width, y+height)
+}
+
+// Mixes c into every pixel of r that's inside the image, alpha of the way
+func blend(img *image.RGBA, r image.Rectangle, c color.RGBA, alpha float64) {
+	r = r.Intersect(img.Bounds())
+
+	mix := func(a uint8, b uint8) uint8 {
+		return uint8(float64(a)*(1-alpha) + float64(b)*alpha + 0.5)
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p := img.RGBAAt(x, y)
+			img.SetRGBA(x, y, color.RGBA{mix(p.R, c.R), mix(p.G, c.G), mix(p.B, c.B), 255})
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package jpeg
+
+import (
+	"image"
+	"testing"
+)
+
+// A flat image is nothing but DCs. Mid gray's DC is 0 as well, so it has no dots
+func TestOverlayDCOnlyBlocks(t *testing.T) {
+	cases := []struct {
+		name  string
+		value uint8
+		dots  bool
+	}{
+		{"mid gray", 128, false},
+		{"light gray", 200, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			img := image.NewGray(image.Rect(0, 0, 16, 16))
+			for i := range img.Pix {
+				img.Pix[i] = c.value
+			}
+
+			j, err := NewJpegParserFromBytes(testEncode(t, img, &EncodeOptions{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, overlay, err := j.DecodeWithOverlay(&DecodeOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, p := range []image.Point{{3, 3}, {11, 4}, {12, 12}} {
+				if dot := overlay.RGBAAt(p.X, p.Y) == overlayDCColour; dot != c.dots {
+					t.Errorf("dot at %v is %v, pixel %v", p, dot, overlay.RGBAAt(p.X, p.Y))
+				}
+			}
+		})
+	}
+}
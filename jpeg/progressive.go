@@ -0,0 +1,182 @@
+package jpeg
+
+import (
+	"huffman"
+)
+
+// Progressive decoding, G.1.2. Each scan adds either a band of coefficients (spectral selection) or one more bit of
+// coefficients already sent (successive approximation) to the planes. Nothing is reconstructed until every scan
+// is in
+
+// Decoding state that carries from one block to the next within an interval
+type progressiveState struct {
+	predictions []int
+	// Blocks left in the current run of end of band blocks (G.1.2.2)
+	eobrun int
+}
+
+func (j *JpegParser) decodeProgressiveInterval(scan *Scan, interval *Interval, planes []*componentPlane) {
+
+	// Always zero at start of an interval
+	state := &progressiveState{predictions: make([]int, len(j.ScanComponents))}
+
+	for i := 0; i < interval.MCUs; i++ {
+		j.forEachMCUBlock(planes, interval.MCUOffset+i, func(ci int, p *componentPlane, index int) {
+			block := &p.coefficients[index]
+			start := interval.BitsRead()
+
+			switch {
+			case scan.IsDC() && !scan.IsRefinement():
+				j.decodeDCFirst(scan, interval, p.component, block, &state.predictions[ci])
+			case scan.IsDC():
+				decodeDCRefine(scan, interval, block)
+			case !scan.IsRefinement():
+				j.decodeACFirst(scan, interval, p.component, block, state)
+			default:
+				j.decodeACRefine(scan, interval, p.component, block, state)
+			}
+
+			if scan.IsDC() {
+				j.bitCounter.add(p.component, index, interval.BitsRead()-start, 0)
+			} else {
+				j.bitCounter.add(p.component, index, 0, interval.BitsRead()-start)
+			}
+		})
+
+		interval.decodedMCUs = i + 1
+	}
+}
+
+// G.1.2.1. The DC difference is coded as in baseline, but only the bits above Al are sent
+func (j *JpegParser) decodeDCFirst(scan *Scan, interval *Interval, c *Component, block *[64]int, prediction *int) {
+	dcReader := j.GetHuffmanReader(huffman.TARGET_DC, c.Td)
+
+	*prediction = dcReader.DecodeDC(interval, *prediction)
+
+	block[0] = *prediction << uint(scan.Al)
+}
+
+// G.1.2.1. Refinement scans send one raw bit per block
+func decodeDCRefine(scan *Scan, interval *Interval, block *[64]int) {
+	if interval.NextBits(1) == 1 {
+		block[0] |= 1 << uint(scan.Al)
+	}
+}
+
+// G.1.2.2. Like figure F.13 over Ss to Se, except that EOBn codes end this block and the n - 1 after it
+func (j *JpegParser) decodeACFirst(scan *Scan, interval *Interval, c *Component, block *[64]int, state *progressiveState) {
+	if state.eobrun > 0 {
+		state.eobrun--
+		return
+	}
+
+	acReader := j.GetHuffmanReader(huffman.TARGET_AC, c.Ta)
+
+	for k := scan.Ss; k <= scan.Se; k++ {
+		rs := acReader.Decode(interval)
+
+		r := rs >> 4
+		ssss := rs & 0x0F
+
+		if ssss == 0 {
+			if r == 15 {
+				// ZRL. The loop skips the 16th zero
+				k += 15
+				continue
+			}
+
+			state.eobrun = 1 << uint(r)
+			if r > 0 {
+				state.eobrun += interval.NextBits(r)
+			}
+
+			// This block is the first of the run
+			state.eobrun--
+			break
+		}
+
+		k += r
+
+		if k > scan.Se {
+			panic("run of zeros goes past the end of the band")
+		}
+
+		block[k] = acReader.DecodeZZ(interval, ssss) << uint(scan.Al)
+	}
+}
+
+// G.1.2.3. Newly significant coefficients are +-1 << Al. Coefficients that were already non zero get a correction
+// bit as they're passed over, whether in a zero run or an end of band run
+func (j *JpegParser) decodeACRefine(scan *Scan, interval *Interval, c *Component, block *[64]int, state *progressiveState) {
+	p1 := 1 << uint(scan.Al)
+	m1 := -1 << uint(scan.Al)
+
+	refine := func(k int) {
+		if interval.NextBits(1) == 1 && block[k]&p1 == 0 {
+			if block[k] >= 0 {
+				block[k] += p1
+			} else {
+				block[k] += m1
+			}
+		}
+	}
+
+	k := scan.Ss
+
+	if state.eobrun == 0 {
+		acReader := j.GetHuffmanReader(huffman.TARGET_AC, c.Ta)
+
+		for ; k <= scan.Se; k++ {
+			rs := acReader.Decode(interval)
+
+			r := rs >> 4
+			ssss := rs & 0x0F
+
+			value := 0
+
+			if ssss != 0 {
+				// Only ever 1. The sign follows
+				if interval.NextBits(1) == 1 {
+					value = p1
+				} else {
+					value = m1
+				}
+			} else if r != 15 {
+				state.eobrun = 1 << uint(r)
+				if r > 0 {
+					state.eobrun += interval.NextBits(r)
+				}
+				break
+			}
+
+			// Skip r coefficients that are still zero, refining the non zero ones along the way. The new value
+			// lands on the next zero after that
+			for ; k <= scan.Se; k++ {
+				if block[k] != 0 {
+					refine(k)
+					continue
+				}
+
+				if r == 0 {
+					if value != 0 {
+						block[k] = value
+					}
+					break
+				}
+
+				r--
+			}
+		}
+	}
+
+	if state.eobrun > 0 {
+		// The rest of the band is in the run. Only the corrections are left
+		for ; k <= scan.Se; k++ {
+			if block[k] != 0 {
+				refine(k)
+			}
+		}
+
+		state.eobrun--
+	}
+}
@@ -0,0 +1,410 @@
+package jpeg
+
+import (
+	"errors"
+	"io"
+
+	"huffman"
+)
+
+// Progressive encoding, the inverse of progressive.go. Follows libjpeg's jcphuff.c so the scans come out the way
+// other decoders expect them
+
+// libjpeg buffers at most this many correction bits before it forces out the end of band run that owns them
+const maxCorrectionBits = 1000
+
+// Runs of end of band blocks are sent as EOBn, which can count up to 2^15 - 1 blocks
+const maxEOBRun = 0x7FFF
+
+// One entry of a scan script: which components the scan covers, by their index in the frame, and which
+// coefficients and bits it sends (G.1.1.1)
+type ScanSpec struct {
+	Components []int
+	Ss         int
+	Se         int
+	Ah         int
+	Al         int
+}
+
+// Baseline is every coefficient in one go
+var baselineScanSpec = &ScanSpec{Ss: 0, Se: 63, Ah: 0, Al: 0}
+
+// The script libjpeg uses for progressive output (jpeg_simple_progression): a coarse DC pass, the low luma
+// frequencies, the chroma, then the remaining bits of everything
+func DefaultScanScript(numComponents int) []ScanSpec {
+	if numComponents == 3 {
+		return []ScanSpec{
+			{Components: []int{0, 1, 2}, Ss: 0, Se: 0, Ah: 0, Al: 1},
+			{Components: []int{0}, Ss: 1, Se: 5, Ah: 0, Al: 2},
+			{Components: []int{2}, Ss: 1, Se: 63, Ah: 0, Al: 1},
+			{Components: []int{1}, Ss: 1, Se: 63, Ah: 0, Al: 1},
+			{Components: []int{0}, Ss: 6, Se: 63, Ah: 0, Al: 2},
+			{Components: []int{0}, Ss: 1, Se: 63, Ah: 2, Al: 1},
+			{Components: []int{0, 1, 2}, Ss: 0, Se: 0, Ah: 1, Al: 0},
+			{Components: []int{2}, Ss: 1, Se: 63, Ah: 1, Al: 0},
+			{Components: []int{1}, Ss: 1, Se: 63, Ah: 1, Al: 0},
+			{Components: []int{0}, Ss: 1, Se: 63, Ah: 1, Al: 0},
+		}
+	}
+
+	return []ScanSpec{
+		{Components: []int{0}, Ss: 0, Se: 0, Ah: 0, Al: 1},
+		{Components: []int{0}, Ss: 1, Se: 5, Ah: 0, Al: 2},
+		{Components: []int{0}, Ss: 6, Se: 63, Ah: 0, Al: 2},
+		{Components: []int{0}, Ss: 1, Se: 63, Ah: 2, Al: 1},
+		{Components: []int{0}, Ss: 0, Se: 0, Ah: 1, Al: 0},
+		{Components: []int{0}, Ss: 1, Se: 63, Ah: 1, Al: 0},
+	}
+}
+
+// Checks the script against the rules in G.1.1.1.1: DC and AC in separate scans, AC scans with a single component,
+// DC before any AC for a component, and each refinement picking up exactly where the last scan of those
+// coefficients left off
+func (e *Encoder) validateScanScript(script []ScanSpec) error {
+	if len(script) == 0 {
+		return errors.New("scan script is empty")
+	}
+
+	// The Al each coefficient of each component was last sent with. -1 is not sent yet
+	lastBit := make([][64]int, len(e.Components))
+	for ci := range lastBit {
+		for k := range lastBit[ci] {
+			lastBit[ci][k] = -1
+		}
+	}
+
+	for _, spec := range script {
+		if len(spec.Components) < 1 || len(spec.Components) > 4 {
+			return errors.New("a scan needs between 1 and 4 components")
+		}
+
+		for i, ci := range spec.Components {
+			if ci < 0 || ci >= len(e.Components) {
+				return errors.New("scan component is not in the frame")
+			}
+
+			if i > 0 && ci <= spec.Components[i-1] {
+				return errors.New("scan components must be in frame order")
+			}
+		}
+
+		if spec.Ss < 0 || spec.Se > 63 || spec.Ss > spec.Se {
+			return errors.New("bad spectral selection")
+		}
+
+		if spec.Al < 0 || spec.Al > 13 || spec.Ah < 0 || spec.Ah > 13 {
+			return errors.New("bad successive approximation")
+		}
+
+		if spec.Ah != 0 && spec.Ah != spec.Al+1 {
+			return errors.New("refinement scans send one bit at a time")
+		}
+
+		if spec.Ss == 0 && spec.Se != 0 {
+			return errors.New("DC and AC coefficients need separate scans")
+		}
+
+		if spec.Ss > 0 && len(spec.Components) != 1 {
+			return errors.New("AC scans can only have one component")
+		}
+
+		for _, ci := range spec.Components {
+			if spec.Ss > 0 && lastBit[ci][0] < 0 {
+				return errors.New("AC scan before the DC scan of its component")
+			}
+
+			for k := spec.Ss; k <= spec.Se; k++ {
+				if spec.Ah == 0 && lastBit[ci][k] >= 0 {
+					return errors.New("coefficient sent twice without refinement")
+				}
+
+				if spec.Ah != 0 && lastBit[ci][k] != spec.Ah {
+					return errors.New("refinement doesn't follow on from the previous scan")
+				}
+
+				lastBit[ci][k] = spec.Al
+			}
+		}
+	}
+
+	for ci := range lastBit {
+		if lastBit[ci][0] < 0 {
+			return errors.New("component has no DC scan")
+		}
+	}
+
+	return nil
+}
+
+// Points ScanComponents at the components of the scan
+func (e *Encoder) activateScanSpec(spec *ScanSpec) {
+	e.ScanComponents = make([]*Component, 0, len(spec.Components))
+
+	for _, ci := range spec.Components {
+		e.ScanComponents = append(e.ScanComponents, e.Components[ci])
+	}
+}
+
+// Builds the Huffman tables for the scan from a counting pass. The Annex K tables have no EOBn codes so there's no
+// fixed alternative. DC refinement scans don't use any tables
+func (e *Encoder) buildScanTables(spec *ScanSpec) {
+	counters := make([]*huffman.HuffmanWriter, 0)
+
+	for _, c := range e.ScanComponents {
+		if spec.Ss == 0 {
+			counters = append(counters, huffman.NewFrequencyCounter(huffman.TARGET_DC, c.Td))
+		} else {
+			counters = append(counters, huffman.NewFrequencyCounter(huffman.TARGET_AC, c.Ta))
+		}
+	}
+
+	e.HuffmanWriters = counters
+
+	e.encodeProgressiveScanTo(spec, huffman.DiscardBitWriter{})
+
+	writers := make([]*huffman.HuffmanWriter, 0)
+
+	for _, c := range counters {
+		if len(c.Frequencies) == 0 {
+			continue
+		}
+
+		bits, huffVal := huffman.OptimalTable(c.Frequencies)
+		writers = append(writers, huffman.NewHuffmanWriter(c.Target, c.Identifier, bits, huffVal))
+	}
+
+	e.HuffmanWriters = writers
+}
+
+func (e *Encoder) encodeProgressiveScan(spec *ScanSpec) []byte {
+	s := NewScanWriter()
+
+	e.encodeProgressiveScanTo(spec, s)
+
+	s.Flush()
+
+	return s.Body
+}
+
+// Each scan is a DHT with its own tables, when it uses any, then the SOS and the data
+func (e *Encoder) writeProgressiveScans(w io.Writer) error {
+	for i := range e.ScanScript {
+		spec := &e.ScanScript[i]
+
+		e.activateScanSpec(spec)
+		e.buildScanTables(spec)
+
+		if len(e.HuffmanWriters) > 0 {
+			if err := writeSegment(w, MARKER_DHT, e.dhtBody()); err != nil {
+				return err
+			}
+		}
+
+		if err := writeSegment(w, MARKER_SOS, e.sosBody(spec)); err != nil {
+			return err
+		}
+
+		if _, err := w.Write(e.encodeProgressiveScan(spec)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Encoding state that carries from one block to the next within an interval
+type progressiveEncodeState struct {
+	writer      huffman.BitWriter
+	acWriter    *huffman.HuffmanWriter
+	predictions []int
+	// Blocks in the pending end of band run and the refinement correction bits that go out after it
+	eobrun         int
+	correctionBits []int
+}
+
+// Sends the pending end of band run as EOBn with the low n bits of the run length, then its correction bits
+func (st *progressiveEncodeState) emitEOBRun() {
+	if st.eobrun == 0 {
+		return
+	}
+
+	n := huffman.Category(st.eobrun) - 1
+
+	st.acWriter.Encode(st.writer, n<<4)
+	if n > 0 {
+		st.writer.WriteBits(st.eobrun&((1<<uint(n))-1), n)
+	}
+
+	st.eobrun = 0
+
+	st.emitBits(st.correctionBits)
+	st.correctionBits = st.correctionBits[:0]
+}
+
+func (st *progressiveEncodeState) emitBits(bits []int) {
+	for _, b := range bits {
+		st.writer.WriteBits(b, 1)
+	}
+}
+
+// Entropy code one scan of the script, with a restart marker every RestartInterval MCUs
+func (e *Encoder) encodeProgressiveScanTo(spec *ScanSpec, s huffman.BitWriter) {
+	st := &progressiveEncodeState{writer: s, predictions: make([]int, len(e.ScanComponents))}
+
+	if spec.Ss > 0 {
+		st.acWriter = e.GetHuffmanWriter(huffman.TARGET_AC, e.ScanComponents[0].Ta)
+	}
+
+	total := e.ScanMCUs()
+
+	for mcu := 0; mcu < total; mcu++ {
+		if e.RestartInterval > 0 && mcu > 0 && mcu%e.RestartInterval == 0 {
+			// A run can't carry over a restart
+			st.emitEOBRun()
+
+			if r, ok := s.(restartWriter); ok {
+				r.WriteRestart(mcu/e.RestartInterval - 1)
+			}
+
+			for i := range st.predictions {
+				st.predictions[i] = 0
+			}
+		}
+
+		e.forEachMCUBlock(e.planes, mcu, func(ci int, p *componentPlane, index int) {
+			block := &p.coefficients[index]
+
+			switch {
+			case spec.Ss == 0 && spec.Ah == 0:
+				dcWriter := e.GetHuffmanWriter(huffman.TARGET_DC, p.component.Td)
+				// Arithmetic shift, so negative values round down the same way the refinement bits assume
+				st.predictions[ci] = dcWriter.EncodeDC(s, block[0]>>uint(spec.Al), st.predictions[ci])
+			case spec.Ss == 0:
+				s.WriteBits((block[0]>>uint(spec.Al))&1, 1)
+			case spec.Ah == 0:
+				st.encodeACFirst(spec, block)
+			default:
+				st.encodeACRefine(spec, block)
+			}
+		})
+	}
+
+	st.emitEOBRun()
+}
+
+// The point transform for AC coefficients divides the magnitude, so it rounds towards zero
+func pointTransform(v int, al int) int {
+	if v < 0 {
+		return -((-v) >> uint(al))
+	}
+
+	return v >> uint(al)
+}
+
+// G.1.2.2. Like figure F.3 over Ss to Se, except that blocks ending in zeros join an end of band run instead of each
+// sending EOB
+func (st *progressiveEncodeState) encodeACFirst(spec *ScanSpec, block *[64]int) {
+	r := 0
+
+	for k := spec.Ss; k <= spec.Se; k++ {
+		v := pointTransform(block[k], spec.Al)
+
+		if v == 0 {
+			r++
+			continue
+		}
+
+		st.emitEOBRun()
+
+		for r > 15 {
+			st.acWriter.Encode(st.writer, 0xF0) // ZRL
+			r -= 16
+		}
+
+		ssss := huffman.Category(v)
+
+		st.acWriter.Encode(st.writer, r<<4|ssss)
+		st.acWriter.EncodeZZ(st.writer, v, ssss)
+
+		r = 0
+	}
+
+	if r > 0 {
+		st.eobrun++
+
+		if st.eobrun == maxEOBRun {
+			st.emitEOBRun()
+		}
+	}
+}
+
+// G.1.2.3. Coefficients that become non zero in this bit are sent as a run and a sign. Ones that already were
+// get their next bit as a correction, held back until the symbol that follows them is out
+func (st *progressiveEncodeState) encodeACRefine(spec *ScanSpec, block *[64]int) {
+	absolute := [64]int{}
+
+	// Position of the last coefficient that becomes non zero in this scan. Zero runs past it go into the end of band
+	eob := 0
+
+	for k := spec.Ss; k <= spec.Se; k++ {
+		v := block[k]
+		if v < 0 {
+			v = -v
+		}
+
+		absolute[k] = v >> uint(spec.Al)
+
+		if absolute[k] == 1 {
+			eob = k
+		}
+	}
+
+	r := 0
+	blockBits := make([]int, 0)
+
+	for k := spec.Ss; k <= spec.Se; k++ {
+		v := absolute[k]
+
+		if v == 0 {
+			r++
+			continue
+		}
+
+		for r > 15 && k <= eob {
+			st.emitEOBRun()
+			st.acWriter.Encode(st.writer, 0xF0) // ZRL
+			r -= 16
+			st.emitBits(blockBits)
+			blockBits = blockBits[:0]
+		}
+
+		// Already non zero from an earlier scan
+		if v > 1 {
+			blockBits = append(blockBits, v&1)
+			continue
+		}
+
+		st.emitEOBRun()
+
+		sign := 1
+		if block[k] < 0 {
+			sign = 0
+		}
+
+		st.acWriter.Encode(st.writer, r<<4|1)
+		st.writer.WriteBits(sign, 1)
+
+		st.emitBits(blockBits)
+		blockBits = blockBits[:0]
+		r = 0
+	}
+
+	if r > 0 || len(blockBits) > 0 {
+		st.eobrun++
+		st.correctionBits = append(st.correctionBits, blockBits...)
+
+		if st.eobrun == maxEOBRun || len(st.correctionBits) > maxCorrectionBits-63 {
+			st.emitEOBRun()
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package jpeg
+
+import (
+	"math"
+	"sort"
+
+	"huffman"
+)
+
+// Working out what quality a file was saved at from its quantization tables. libjpeg and everything built on it
+// scale the Annex K tables by the IJG formula, so those match one quality exactly. A lot of camera firmware keeps the
+// Annex K shape with its own scaling and rounding, while Photoshop and some others use tables of their own
+
+const (
+	// Exactly the IJG scaling of the Annex K tables
+	QUALITY_FAMILY_IJG = "IJG"
+	// The Annex K shape under some other scale or rounding, as many cameras write
+	QUALITY_FAMILY_SCALED_ANNEX_K = "scaled Annex K"
+	// Every step the same size
+	QUALITY_FAMILY_FLAT = "flat"
+	// Tables that don't follow Annex K at all. The quality is only a comparison of average step size
+	QUALITY_FAMILY_CUSTOM = "custom"
+	// Photoshop's own tables, matched exactly. Level is its quality setting. Only level 12 is in
+	// knownQuantizationTables so far, so the other levels come out as one of the families above
+	QUALITY_FAMILY_PHOTOSHOP = "Photoshop"
+)
+
+// Tables written by encoders that don't use the IJG scaling, in natural order. Each is only listed once it has
+// been checked against a file the encoder wrote
+var knownQuantizationTables = []struct {
+	family string
+	level  int
+	luma   [64]int
+	chroma [64]int
+}{
+	{QUALITY_FAMILY_PHOTOSHOP, 12, [64]int{
+		1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 2,
+		1, 1, 1, 1, 1, 1, 2, 2,
+		1, 1, 1, 1, 1, 2, 2, 3,
+		1, 1, 1, 1, 2, 2, 3, 3,
+		1, 1, 1, 2, 2, 3, 3, 3,
+		1, 1, 2, 2, 3, 3, 3, 3,
+	}, [64]int{
+		1, 1, 1, 2, 2, 3, 3, 3,
+		1, 1, 1, 2, 3, 3, 3, 3,
+		1, 1, 1, 3, 3, 3, 3, 3,
+		2, 2, 3, 3, 3, 3, 3, 3,
+		2, 3, 3, 3, 3, 3, 3, 3,
+		3, 3, 3, 3, 3, 3, 3, 3,
+		3, 3, 3, 3, 3, 3, 3, 3,
+		3, 3, 3, 3, 3, 3, 3, 3,
+	}},
+}
+
+type QualityEstimate struct {
+	// The IJG quality whose tables come closest, 1 to 100
+	Quality int
+	Family  string
+	// The encoder's own quality setting when the tables are one of the known ones, 0 otherwise
+	Level int
+	// 1 for an exact IJG or known table match, falling towards 0 as the tables get further from anything IJG would write
+	Confidence float64
+	// Sum of absolute differences from the IJG tables at Quality
+	Error int
+}
+
+func (j *JpegParser) EstimateQuality() QualityEstimate {
+	return EstimateQuality(j.QuantizationTables, j.Components)
+}
+
+// Compares the table the first component uses with the Annex K luminance table, and the table of the second
+// component, when it has one of its own, with the chrominance table
+func EstimateQuality(tables map[int][64]int, components []*Component) QualityEstimate {
+	if len(components) == 0 {
+		return QualityEstimate{Family: QUALITY_FAMILY_CUSTOM}
+	}
+
+	luma, present := tables[components[0].Tq]
+	if !present {
+		return QualityEstimate{Family: QUALITY_FAMILY_CUSTOM}
+	}
+
+	var chroma *[64]int
+	if len(components) > 1 && components[1].Tq != components[0].Tq {
+		if table, present := tables[components[1].Tq]; present {
+			chroma = &table
+		}
+	}
+
+	best := QualityEstimate{Error: math.MaxInt32}
+
+	for _, known := range knownQuantizationTables {
+		if luma == huffman.ZigZag(known.luma) && (chroma == nil || *chroma == huffman.ZigZag(known.chroma)) {
+			best.Family = known.family
+			best.Level = known.level
+			best.Confidence = 1
+			break
+		}
+	}
+
+	for q := 1; q <= 100; q++ {
+		e := absDifference(luma, ScaleQuantizationTable(AnnexKLuminanceQuantization, q))
+
+		if chroma != nil {
+			e += absDifference(*chroma, ScaleQuantizationTable(AnnexKChrominanceQuantization, q))
+		}
+
+		// Ties go to the higher quality. Near 100 the tables bottom out at all ones
+		if e <= best.Error {
+			best.Quality = q
+			best.Error = e
+		}
+	}
+
+	if best.Family != "" {
+		return best
+	}
+
+	if best.Error == 0 {
+		best.Family = QUALITY_FAMILY_IJG
+		best.Confidence = 1
+		return best
+	}
+
+	total := tableSum(luma)
+	if chroma != nil {
+		total += tableSum(*chroma)
+	}
+
+	// How much of the table the nearest IJG one accounts for
+	fit := math.Max(0, 1-float64(best.Error)/float64(total))
+
+	switch {
+	case isFlat(luma):
+		best.Family = QUALITY_FAMILY_FLAT
+		best.Confidence = fit / 2
+	case annexKShapeError(luma, AnnexKLuminanceQuantization) < 0.15:
+		best.Family = QUALITY_FAMILY_SCALED_ANNEX_K
+		// Never as sure as an exact match
+		best.Confidence = math.Min(fit, 0.99)
+	default:
+		best.Family = QUALITY_FAMILY_CUSTOM
+		best.Confidence = fit / 2
+	}
+
+	return best
+}
+
+func absDifference(a [64]int, b [64]int) int {
+	sum := 0
+
+	for i := range a {
+		d := a[i] - b[i]
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+
+	return sum
+}
+
+func tableSum(t [64]int) int {
+	sum := 0
+
+	for _, v := range t {
+		sum += v
+	}
+
+	return sum
+}
+
+func isFlat(t [64]int) bool {
+	for _, v := range t {
+		if v != t[0] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// How far the table is from the natural order Annex K table times a single factor, relative to its average step.
+// The factor is the median ratio so the steps clamped at 1 or 255 don't drag it around
+func annexKShapeError(zz [64]int, annexK [64]int) float64 {
+	natural := naturalOrder(zz)
+
+	ratios := make([]float64, 64)
+	for i := range natural {
+		ratios[i] = float64(natural[i]) / float64(annexK[i])
+	}
+
+	sort.Float64s(ratios)
+	scale := (ratios[31] + ratios[32]) / 2
+
+	sum := 0.0
+	for i := range natural {
+		expected := math.Max(1, math.Min(255, math.Floor(float64(annexK[i])*scale+0.5)))
+		sum += math.Abs(float64(natural[i]) - expected)
+	}
+
+	return sum / float64(tableSum(zz))
+}
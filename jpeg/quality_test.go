@@ -0,0 +1,111 @@
+package jpeg
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"huffman"
+)
+
+func TestEstimateQuality(t *testing.T) {
+	color := []*Component{NewComponent(1, 2, 2, 0), NewComponent(2, 1, 1, 1), NewComponent(3, 1, 1, 1)}
+	gray := []*Component{NewComponent(1, 1, 1, 0)}
+
+	photoshop := knownQuantizationTables[0]
+	flat := [64]int{}
+	for i := range flat {
+		flat[i] = 4
+	}
+
+	type table struct {
+		luma   [64]int
+		chroma [64]int
+	}
+
+	ijg := func(q int) table {
+		return table{ScaleQuantizationTable(AnnexKLuminanceQuantization, q), ScaleQuantizationTable(AnnexKChrominanceQuantization, q)}
+	}
+
+	cases := []struct {
+		name       string
+		tables     table
+		components []*Component
+		family     string
+		quality    int
+		level      int
+	}{
+		{"IJG 10", ijg(10), color, QUALITY_FAMILY_IJG, 10, 0},
+		{"IJG 50", ijg(50), color, QUALITY_FAMILY_IJG, 50, 0},
+		{"IJG 90 gray", ijg(90), gray, QUALITY_FAMILY_IJG, 90, 0},
+		{"IJG 100", ijg(100), color, QUALITY_FAMILY_IJG, 100, 0},
+		{"Photoshop 12", table{huffman.ZigZag(photoshop.luma), huffman.ZigZag(photoshop.chroma)}, color, QUALITY_FAMILY_PHOTOSHOP, 99, 12},
+		{"Photoshop 12 gray", table{huffman.ZigZag(photoshop.luma), [64]int{}}, gray, QUALITY_FAMILY_PHOTOSHOP, 99, 12},
+		{"Photoshop 12 luma, IJG chroma", table{huffman.ZigZag(photoshop.luma), ijg(90).chroma}, color, QUALITY_FAMILY_CUSTOM, -1, 0},
+		{"flat", table{flat, flat}, color, QUALITY_FAMILY_FLAT, -1, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			e := EstimateQuality(map[int][64]int{0: c.tables.luma, 1: c.tables.chroma}, c.components)
+
+			got := fmt.Sprintf("%s level %d", e.Family, e.Level)
+			if want := fmt.Sprintf("%s level %d", c.family, c.level); got != want {
+				t.Errorf("got %s, not %s", got, want)
+			}
+
+			if c.quality > 0 && e.Quality != c.quality {
+				t.Errorf("got IJG quality %d, not %d", e.Quality, c.quality)
+			}
+		})
+	}
+}
+
+// A camera that scales Annex K 5% coarser than IJG does. It lands near the IJG quality it was derived from, but is
+// never an exact match until every step bottoms out at 1
+func TestEstimateQualityScaledAnnexK(t *testing.T) {
+	color := []*Component{NewComponent(1, 2, 2, 0), NewComponent(2, 1, 1, 1), NewComponent(3, 1, 1, 1)}
+
+	coarser := func(natural [64]int, q int) [64]int {
+		scale := float64(200 - q*2)
+		if q < 50 {
+			scale = 5000 / float64(q)
+		}
+
+		scaled := [64]int{}
+		for i, e := range natural {
+			scaled[i] = int(math.Max(1, math.Min(255, math.Floor(float64(e)*scale*1.05/100+0.5))))
+		}
+
+		return huffman.ZigZag(scaled)
+	}
+
+	cases := []struct {
+		from    int
+		family  string
+		quality int
+	}{
+		{10, QUALITY_FAMILY_SCALED_ANNEX_K, 10},
+		{25, QUALITY_FAMILY_SCALED_ANNEX_K, 24},
+		{50, QUALITY_FAMILY_SCALED_ANNEX_K, 47},
+		{75, QUALITY_FAMILY_SCALED_ANNEX_K, 74},
+		{90, QUALITY_FAMILY_SCALED_ANNEX_K, 89},
+		{95, QUALITY_FAMILY_SCALED_ANNEX_K, 95},
+		{100, QUALITY_FAMILY_IJG, 100},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprint(c.from), func(t *testing.T) {
+			tables := map[int][64]int{0: coarser(AnnexKLuminanceQuantization, c.from), 1: coarser(AnnexKChrominanceQuantization, c.from)}
+			e := EstimateQuality(tables, color)
+
+			if e.Family != c.family || e.Quality != c.quality {
+				t.Fatalf("got %s quality %d, not %s quality %d", e.Family, e.Quality, c.family, c.quality)
+			}
+
+			if c.family == QUALITY_FAMILY_SCALED_ANNEX_K && (e.Confidence < 0.9 || e.Confidence >= 1 || e.Error == 0) {
+				t.Errorf("confidence %v with error %d", e.Confidence, e.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package jpeg
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Decoding past damage in the entropy coded data. Restart markers are the only place a decoder can pick the
+// bitstream back up (F.1.2.3), so a bad interval is thrown away whole and filled in, and decoding carries on at the
+// next marker
+
+const (
+	// Leave the damaged blocks as they were before the scan. For a baseline file that's flat gray
+	FILL_GRAY int = 0
+	// Repeat the blocks one MCU row up. Damage in the top row still falls back to gray
+	FILL_COPY_ABOVE int = 1
+)
+
+// MCUs of one scan that couldn't be decoded
+type MCURange struct {
+	// Index into JpegParser.Scans
+	Scan  int
+	First int
+	Count int
+}
+
+// Returned along with the image by a tolerant decode when some of it had to be filled in
+type CorruptDataError struct {
+	Damaged []MCURange
+}
+
+func (e *CorruptDataError) Error() string {
+	ranges := make([]string, len(e.Damaged))
+
+	for i, r := range e.Damaged {
+		ranges[i] = fmt.Sprintf("scan %d MCUs %d-%d", r.Scan, r.First, r.First+r.Count-1)
+	}
+
+	return "corrupt entropy coded data, filled in " + strings.Join(ranges, ", ")
+}
+
+// Decodes an interval and reports whether it had to be filled in instead. Each block the interval covers is
+// saved first so a decode that fails halfway through doesn't leave half written blocks behind
+func (j *JpegParser) decodeIntervalTolerant(scan *Scan, interval *Interval, planes []*componentPlane, fill int) (damaged bool) {
+	saved := make(map[*componentPlane]map[int][64]int)
+
+	for i := 0; i < interval.MCUs; i++ {
+		j.forEachMCUBlock(planes, interval.MCUOffset+i, func(ci int, p *componentPlane, index int) {
+			if saved[p] == nil {
+				saved[p] = make(map[int][64]int)
+			}
+			saved[p][index] = p.coefficients[index]
+		})
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			panicOnBug(r)
+			damaged = true
+		}
+
+		if damaged {
+			j.fillInterval(interval, planes, saved, fill)
+		}
+	}()
+
+	if interval.Damaged {
+		return true
+	}
+
+	if j.Progressive {
+		j.decodeProgressiveInterval(scan, interval, planes)
+	} else {
+		j.decodeInterval(interval, planes)
+	}
+
+	return false
+}
+
+// Only the parser's own panics about the data are damage. Anything the runtime raises is a bug, and turning it
+// into a filled in interval would hide it
+func panicOnBug(r interface{}) {
+	if err, isRuntime := r.(runtime.Error); isRuntime {
+		panic(err)
+	}
+}
+
+func (j *JpegParser) fillInterval(interval *Interval, planes []*componentPlane, saved map[*componentPlane]map[int][64]int, fill int) {
+	for i := 0; i < interval.MCUs; i++ {
+		j.forEachMCUBlock(planes, interval.MCUOffset+i, func(ci int, p *componentPlane, index int) {
+			// One MCU row up is V block rows in an interleaved scan and a single row otherwise
+			rowsPerMCU := 1
+			if len(j.ScanComponents) > 1 {
+				rowsPerMCU = p.component.V
+			}
+
+			above := index - p.blockCols*rowsPerMCU
+
+			if fill == FILL_COPY_ABOVE && above >= 0 {
+				p.coefficients[index] = p.coefficients[above]
+			} else {
+				p.coefficients[index] = saved[p][index]
+			}
+		})
+	}
+}
+
+// Returned along with the image when the file ends before EOI. Everything past ValidMCURows is flat gray, or for
+// a progressive file whatever the earlier scans had got to
+type TruncatedError struct {
+	ValidMCURows int
+	MCURows      int
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("file is truncated, %d of %d MCU rows are valid", e.ValidMCURows, e.MCURows)
+}
+
+// Decodes the scan the file ends in for as long as there's data
+func (j *JpegParser) decodeTruncatedScan(scan *Scan, planes []*componentPlane) *TruncatedError {
+	stoppedAt := j.ScanMCUs()
+
+	for _, interval := range j.Intervals {
+		interval.Reset()
+
+		if !j.decodeUntilEnd(scan, interval, planes) {
+			stoppedAt = interval.MCUOffset + interval.decodedMCUs
+			break
+		}
+	}
+
+	return &TruncatedError{ValidMCURows: j.validMCURows(stoppedAt), MCURows: j.frameMCURows()}
+}
+
+// Returns false if the data ran out partway through the interval
+func (j *JpegParser) decodeUntilEnd(scan *Scan, interval *Interval, planes []*componentPlane) (complete bool) {
+	if interval.Damaged {
+		return false
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			panicOnBug(r)
+			complete = false
+		}
+	}()
+
+	if j.Progressive {
+		j.decodeProgressiveInterval(scan, interval, planes)
+	} else {
+		j.decodeInterval(interval, planes)
+	}
+
+	return true
+}
+
+// Converts how far the current scan got into whole MCU rows of the frame. Components no scan got to at all
+// leave nothing valid
+func (j *JpegParser) validMCURows(stoppedAt int) int {
+	for _, c := range j.Components {
+		if !j.componentHasScan(c) {
+			return 0
+		}
+	}
+
+	// A partial last row counts once the scan is done with it
+	if stoppedAt >= j.ScanMCUs() {
+		return j.frameMCURows()
+	}
+
+	if len(j.ScanComponents) > 1 {
+		return stoppedAt / j.MCUCols()
+	}
+
+	c := j.ScanComponents[0]
+	cols, _ := j.ComponentBlocks(c)
+	blockRows := stoppedAt / cols
+
+	if len(j.Components) == 1 {
+		return blockRows
+	}
+
+	return blockRows / c.V
+}
+
+func (j *JpegParser) componentHasScan(c *Component) bool {
+	for _, scan := range j.Scans {
+		for _, sc := range scan.Components {
+			if sc == c {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,227 @@
+package jpeg
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"runtime"
+	"testing"
+)
+
+// A 64x64 grayscale file with one MCU row per restart interval, so interval n is pixel rows 8n to 8n+7. Returns
+// the file and where each RSTn marker is in it
+func testRestartFile(t *testing.T) ([]byte, []int) {
+	b := testEncode(t, testImage(64, 64, true, 8), &EncodeOptions{RestartInterval: 8})
+
+	l := testSegmentList(t, b)
+
+	var markers []int
+	for _, s := range l.Segments {
+		if s.Type != MARKER_FRAME {
+			continue
+		}
+
+		for i := 0; i+1 < len(s.Body); i++ {
+			if s.Body[i] == 0xFF && s.Body[i+1] >= MARKER_RST0 && s.Body[i+1] <= MARKER_RST7 {
+				markers = append(markers, s.Offset+i)
+			}
+		}
+	}
+
+	if len(markers) != 7 {
+		t.Fatalf("got %d restart markers", len(markers))
+	}
+
+	return b, markers
+}
+
+func testDecodeTolerant(t *testing.T, b []byte, fill int) (*image.RGBA, []MCURange) {
+	j, err := NewJpegParserFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := j.DecodeWithOptions(&DecodeOptions{Tolerant: true, Fill: fill})
+	if img == nil {
+		t.Fatal(err)
+	}
+	if err == nil {
+		return img, nil
+	}
+
+	corrupt, isCorrupt := err.(*CorruptDataError)
+	if !isCorrupt {
+		t.Fatal(err)
+	}
+
+	return img, corrupt.Damaged
+}
+
+// Whether pixel rows from and from+8 on are the same as rows to and to+8 on
+func testRowsEqual(a *image.RGBA, from int, b *image.RGBA, to int) bool {
+	return bytes.Equal(a.Pix[from*a.Stride:(from+8)*a.Stride], b.Pix[to*b.Stride:(to+8)*b.Stride])
+}
+
+func TestTolerantDecode(t *testing.T) {
+	b, markers := testRestartFile(t)
+	clean := testDecode(t, b)
+
+	edit := func(f func(c []byte) []byte) []byte {
+		return f(append([]byte{}, b...))
+	}
+
+	cases := []struct {
+		name    string
+		file    []byte
+		damaged []MCURange
+	}{
+		{"clean", b, nil},
+		// The data from after RST2 runs on into interval 2. Interval 3 is the one that went missing
+		{"deleted RST2", edit(func(c []byte) []byte {
+			return append(c[:markers[2]], c[markers[2]+2:]...)
+		}), []MCURange{{0, 24, 8}}},
+		// With no marker after it, the last interval holds both and the second can't be placed
+		{"deleted RST6", edit(func(c []byte) []byte {
+			return append(c[:markers[6]], c[markers[6]+2:]...)
+		}), []MCURange{{0, 56, 8}}},
+		// The markers either side carry on the count, so each interval is still in the right place
+		{"swapped RST2 and RST3", edit(func(c []byte) []byte {
+			c[markers[2]+1], c[markers[3]+1] = c[markers[3]+1], c[markers[2]+1]
+			return c
+		}), nil},
+		// A marker that means nothing inside interval 4. The decode picks up again at the RST4 after it
+		{"bad marker in interval 4", edit(func(c []byte) []byte {
+			at := (markers[3] + markers[4]) / 2
+			return append(c[:at], append([]byte{0xFF, 0x13}, c[at:]...)...)
+		}), []MCURange{{0, 32, 8}}},
+		{"bad marker in the last interval", edit(func(c []byte) []byte {
+			at := markers[6] + 4
+			return append(c[:at], append([]byte{0xFF, 0x13}, c[at:]...)...)
+		}), []MCURange{{0, 56, 8}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, fill := range []int{FILL_GRAY, FILL_COPY_ABOVE} {
+				img, damaged := testDecodeTolerant(t, c.file, fill)
+
+				if fmt.Sprint(damaged) != fmt.Sprint(c.damaged) {
+					t.Fatalf("fill %d damaged %v, want %v", fill, damaged, c.damaged)
+				}
+
+				isDamaged := make(map[int]bool)
+				for _, r := range damaged {
+					for mcu := r.First; mcu < r.First+r.Count; mcu += 8 {
+						isDamaged[mcu/8] = true
+					}
+				}
+
+				for row := 0; row < 8; row++ {
+					switch {
+					case !isDamaged[row]:
+						if !testRowsEqual(img, row*8, clean, row*8) {
+							t.Errorf("fill %d changed undamaged row %d", fill, row)
+						}
+					case fill == FILL_COPY_ABOVE:
+						if !testRowsEqual(img, row*8, img, row*8-8) {
+							t.Errorf("row %d isn't a copy of the one above", row)
+						}
+					default:
+						for x := 0; x < 64; x++ {
+							if p := img.RGBAAt(x, row*8+3); p.R != 128 || p.G != 128 || p.B != 128 {
+								t.Fatalf("row %d is %v, not gray", row, p)
+							}
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// A bug in the decoder isn't damage to the data, and mustn't come back as a filled in interval
+func TestTolerantDecodeRepanicsRuntimeErrors(t *testing.T) {
+	b, _ := testRestartFile(t)
+
+	j, err := NewJpegParserFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j.ActivateScan(j.Scans[0])
+
+	defer func() {
+		if _, isRuntime := recover().(runtime.Error); !isRuntime {
+			t.Error("the runtime error was swallowed")
+		}
+	}()
+
+	// No planes to write the blocks into
+	j.decodeUntilEnd(j.Scans[0], j.Intervals[0], nil)
+}
+
+// Cutting a file short anywhere in its scan keeps every row before the cut exactly as a full decode has it
+func TestTruncatedDecode(t *testing.T) {
+	cases := []struct {
+		name      string
+		file      []byte
+		mcuHeight int
+	}{
+		{"gray", testEncode(t, testImage(64, 64, true, 9), &EncodeOptions{}), 8},
+		{"4:2:0", testEncode(t, testImage(64, 64, false, 9), &EncodeOptions{Subsampling: SUBSAMPLING_420}), 16},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			full := testDecode(t, c.file)
+			mcuRows := 64 / c.mcuHeight
+
+			var data *Section
+			for _, s := range testSegmentList(t, c.file).Segments {
+				if s.Type == MARKER_FRAME {
+					data = s
+				}
+			}
+
+			previous := 0
+
+			for eighths := 0; eighths <= 8; eighths++ {
+				cut := len(data.Body) * eighths / 8
+
+				j, err := NewJpegParserFromBytes(c.file[:data.Offset+cut])
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				img, err := j.Decode()
+				truncated, isTruncated := err.(*TruncatedError)
+				if img == nil || !isTruncated {
+					t.Fatalf("cut at %d gave %v", cut, err)
+				}
+
+				if truncated.MCURows != mcuRows {
+					t.Errorf("cut at %d has %d MCU rows", cut, truncated.MCURows)
+				}
+
+				valid := truncated.ValidMCURows
+
+				switch {
+				case eighths == 0 && valid != 0:
+					t.Errorf("no data but %d valid rows", valid)
+				case eighths == 8 && valid != mcuRows:
+					t.Errorf("only EOI missing but %d valid rows", valid)
+				case eighths == 4 && (valid == 0 || valid == mcuRows):
+					t.Errorf("half the data gives %d valid rows", valid)
+				case valid < previous:
+					t.Errorf("cut at %d gives %d valid rows, fewer than the %d before", cut, valid, previous)
+				}
+				previous = valid
+
+				rows := valid * c.mcuHeight
+				if !bytes.Equal(img.Pix[:rows*img.Stride], full.Pix[:rows*full.Stride]) {
+					t.Errorf("cut at %d changed the %d valid pixel rows", cut, rows)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package jpeg
+
+import (
+	"errors"
+	"image"
+	"math"
+)
+
+// Lowering the quality of a file in the DCT domain. Each coefficient is scaled from its old quantization step to
+// the new one, so there's no IDCT and FDCT round trip adding its own error on top
+
+// A copy of the coefficients quantized with the Annex K tables at quality. Tables used by the first component get
+// the luminance table and the rest get the chrominance one. No step gets finer than it already was, since
+// that would only spend bits on precision that's gone
+func (c *Coefficients) Requantize(quality int) (*Coefficients, error) {
+	if quality < 1 || quality > 100 {
+		return nil, errors.New("quality must be between 1 and 100")
+	}
+
+	luma := ScaleQuantizationTable(AnnexKLuminanceQuantization, quality)
+	chroma := ScaleQuantizationTable(AnnexKChrominanceQuantization, quality)
+
+	tables := make(map[int][64]int)
+
+	for i, component := range c.Components {
+		if _, done := tables[component.Tq]; done {
+			continue
+		}
+
+		old, present := c.QuantizationTables[component.Tq]
+		if !present {
+			return nil, errors.New("component uses a quantization table that isn't there")
+		}
+
+		target := chroma
+		if i == 0 {
+			target = luma
+		}
+
+		for k := range target {
+			if target[k] < old[k] {
+				target[k] = old[k]
+			}
+		}
+
+		tables[component.Tq] = target
+	}
+
+	return c.remap(c.copyFrame(), tables, func(ci int, row int, col int) [64]int {
+		block := c.blockOrZero(ci, row, col)
+
+		tq := c.Components[ci].Tq
+		old := c.QuantizationTables[tq]
+		target := tables[tq]
+
+		for k := range block {
+			block[k] = quantize(block[k]*old[k], target[k])
+		}
+
+		return block
+	}), nil
+}
+
+// Peak signal to noise ratio over the R, G and B channels, in dB. Identical images give +Inf
+func PSNR(a *image.RGBA, b *image.RGBA) (float64, error) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return 0, errors.New("images are different sizes")
+	}
+
+	sum := 0.0
+	count := 0
+
+	for y := 0; y < a.Bounds().Dy(); y++ {
+		rowA := a.Pix[y*a.Stride:]
+		rowB := b.Pix[y*b.Stride:]
+
+		for x := 0; x < a.Bounds().Dx(); x++ {
+			for ch := 0; ch < 3; ch++ {
+				d := float64(rowA[x*4+ch]) - float64(rowB[x*4+ch])
+				sum += d * d
+				count++
+			}
+		}
+	}
+
+	mse := sum / float64(count)
+
+	if mse == 0 {
+		return math.Inf(1), nil
+	}
+
+	return 10 * math.Log10(255*255/mse), nil
+}
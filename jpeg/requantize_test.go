@@ -0,0 +1,108 @@
+package jpeg
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+// A finer quality than the file already has can't bring back what was lost, so nothing changes
+func TestRequantizeFiner(t *testing.T) {
+	c := testCoefficients(t, testEncode(t, testImage(45, 29, false, 5), &EncodeOptions{Quality: 50, Subsampling: SUBSAMPLING_420}))
+
+	requantized, err := c.Requantize(90)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, table := range c.QuantizationTables {
+		if requantized.QuantizationTables[id] != table {
+			t.Errorf("table %d went from %v to %v", id, table, requantized.QuantizationTables[id])
+		}
+	}
+
+	for ci, plane := range c.Planes {
+		for i, block := range plane.Blocks {
+			if requantized.Planes[ci].Blocks[i] != block {
+				t.Fatalf("component %d block %d changed", ci, i)
+			}
+		}
+	}
+}
+
+// A coarser quality takes the Annex K tables at that quality and rounds each dequantized coefficient to them
+func TestRequantizeCoarser(t *testing.T) {
+	c := testCoefficients(t, testEncode(t, testImage(45, 29, false, 5), &EncodeOptions{Quality: 90, Subsampling: SUBSAMPLING_420}))
+
+	requantized, err := c.Requantize(30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int][64]int{
+		c.Components[0].Tq: ScaleQuantizationTable(AnnexKLuminanceQuantization, 30),
+		c.Components[1].Tq: ScaleQuantizationTable(AnnexKChrominanceQuantization, 30),
+	}
+
+	for id, table := range want {
+		if requantized.QuantizationTables[id] != table {
+			t.Errorf("table %d is %v, want %v", id, requantized.QuantizationTables[id], table)
+		}
+	}
+
+	changed := 0
+
+	for ci, plane := range c.Planes {
+		old := c.QuantizationTables[plane.Component.Tq]
+		target := want[plane.Component.Tq]
+
+		for i, block := range plane.Blocks {
+			got := requantized.Planes[ci].Blocks[i]
+
+			for k := range block {
+				if w := quantize(block[k]*old[k], target[k]); got[k] != w {
+					t.Fatalf("component %d block %d coefficient %d is %d, want %d", ci, i, k, got[k], w)
+				}
+				if got[k] != block[k] {
+					changed++
+				}
+			}
+		}
+	}
+
+	if changed == 0 {
+		t.Error("no coefficient changed")
+	}
+}
+
+func TestRequantizeQualityRange(t *testing.T) {
+	c := testCoefficients(t, testEncode(t, testImage(16, 16, true, 5), &EncodeOptions{}))
+
+	for _, quality := range []int{0, 101} {
+		if _, err := c.Requantize(quality); err == nil {
+			t.Errorf("quality %d requantized", quality)
+		}
+	}
+}
+
+func TestPSNR(t *testing.T) {
+	img := testDecode(t, testEncode(t, testImage(20, 12, false, 6), &EncodeOptions{}))
+
+	same := image.NewRGBA(img.Bounds())
+	copy(same.Pix, img.Pix)
+
+	if psnr, err := PSNR(img, same); err != nil || !math.IsInf(psnr, 1) {
+		t.Errorf("identical images give %v, %v", psnr, err)
+	}
+
+	// One channel of one pixel off by 1 is an MSE of 1 over every R, G and B sample
+	same.Pix[0] ^= 1
+	want := 10 * math.Log10(255*255*20*12*3)
+	if psnr, err := PSNR(img, same); err != nil || math.Abs(psnr-want) > 1e-9 {
+		t.Errorf("got %v, %v, want %v", psnr, err, want)
+	}
+
+	if _, err := PSNR(img, image.NewRGBA(image.Rect(0, 0, 20, 13))); err == nil {
+		t.Error("compared images of different sizes")
+	}
+}
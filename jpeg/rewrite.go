@@ -0,0 +1,350 @@
+package jpeg
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+)
+
+// Rewriting the metadata of a file without decoding it. The file is split into its marker segments and the entropy
+// coded data between them, the segments are edited, and everything is written back with the data copied byte for
+// byte
+
+var (
+	iccIdentifier   = []byte("ICC_PROFILE\x00")
+	adobeIdentifier = []byte("Adobe")
+
+	// The XMP copies of what ZeroGPS and ZeroSerialNumbers blank in EXIF, for BlankXmp
+	XmpGPSProperties    = []string{`exif:GPS\w+`}
+	XmpSerialProperties = []string{`aux:SerialNumber`, `aux:LensSerialNumber`, `aux:OwnerName`, `exifEX:BodySerialNumber`,
+		`exifEX:LensSerialNumber`, `exifEX:CameraOwnerName`, `exif:ImageUniqueID`, `exifEX:ImageUniqueID`}
+)
+
+// Every segment of a file in order. The entropy coded data after each SOS, restart markers and all, is kept in a
+// MARKER_FRAME section right after it
+type SegmentList struct {
+	Segments []*Section
+	// Whatever follows EOI. Multi-Picture files keep their other images here, at offsets counted from the MPF
+	// segment, so resizing anything between that segment and EOI leaves them pointing at the wrong place
+	Trailer []byte
+	// The primary image's size and the bytes from the MPF segment's TIFF header to the end of EOI as read. -1
+	// without an MPF segment
+	mpfPrimarySize int
+	mpfSpan        int
+}
+
+// Splits b at its markers. Unlike the parser this never panics, since it's meant for files that are only
+// passing through
+func ReadSegmentList(b []byte) (*SegmentList, error) {
+	if len(b) < 2 || b[0] != 0xFF || b[1] != MARKER_SOI {
+		return nil, errors.New("missing SOI")
+	}
+
+	l := &SegmentList{Segments: make([]*Section, 0)}
+
+	offset := 2
+
+	for {
+		if offset+2 > len(b) {
+			return nil, errors.New("got to the end of the file without an EOI")
+		}
+
+		if b[offset] != 0xFF {
+			return nil, errors.New("expected a marker")
+		}
+
+		marker := b[offset+1]
+
+		// Any number of 0xFF fill bytes may come before a marker (B.1.1.2)
+		if marker == 0xFF {
+			offset++
+			continue
+		}
+
+		if marker == MARKER_EOI {
+			l.Trailer = b[offset+2:]
+			l.mpfPrimarySize, l.mpfSpan = l.mpfSizes()
+			return l, nil
+		}
+
+		if offset+4 > len(b) {
+			return nil, errors.New("marker segment runs past end of file")
+		}
+
+		length := int(b[offset+2])<<8 | int(b[offset+3])
+
+		if length < 2 || offset+2+length > len(b) {
+			return nil, errors.New("marker segment runs past end of file")
+		}
+
+		section := NewSection(marker, b[offset+4:offset+2+length])
+		section.Offset = offset
+		l.Segments = append(l.Segments, section)
+
+		offset += 2 + length
+
+		if marker == MARKER_SOS {
+			end := entropyCodedDataEnd(b, offset)
+
+			data := NewSection(MARKER_FRAME, b[offset:end])
+			data.Offset = offset
+			l.Segments = append(l.Segments, data)
+
+			offset = end
+		}
+	}
+}
+
+// Where the entropy coded data starting at offset ends: the first marker the parser would read a segment for, or
+// the end of the file
+func entropyCodedDataEnd(b []byte, offset int) int {
+	for i := offset; i+1 < len(b); i++ {
+		if b[i] != 0xFF {
+			continue
+		}
+
+		next := b[i+1]
+
+		if next == 0x00 || (next >= MARKER_RST0 && next <= MARKER_RST7) {
+			i++
+			continue
+		}
+
+		// Fill bytes belong to the marker that follows
+		if next == 0xFF {
+			continue
+		}
+
+		// Damage to the data, which is copied through as it is
+		if !isSegmentMarker(next) {
+			i++
+			continue
+		}
+
+		return i
+	}
+
+	return len(b)
+}
+
+// Where the MPF segment's TIFF header would be written, and the size of the primary image from SOI to the end of
+// EOI. -1 for the header without an MPF segment
+func (l *SegmentList) mpfSizes() (int, int) {
+	size := 2
+	header := -1
+
+	for _, s := range l.Segments {
+		if s.Type == MARKER_APP2 && IsMpf(s.Body) && header < 0 {
+			header = size + 4 + len(mpfIdentifier)
+		}
+
+		size += len(s.Body)
+		if s.Type != MARKER_FRAME {
+			size += 4
+		}
+	}
+
+	size += 2
+
+	if header < 0 {
+		return size, -1
+	}
+
+	return size, size - header
+}
+
+// Refuses to write a Multi-Picture file whose primary image changed size, since the MP Index would then have the
+// wrong size for it and the wrong offsets for the images in the trailer
+func (l *SegmentList) Write(w io.Writer) error {
+	if l.mpfSpan >= 0 && len(l.Trailer) > 0 {
+		primarySize, span := l.mpfSizes()
+
+		if span >= 0 && (primarySize != l.mpfPrimarySize || span != l.mpfSpan) {
+			return errors.New("the primary image changed size, which would break the MPF offsets")
+		}
+	}
+
+	buffered := bufio.NewWriter(w)
+
+	if _, err := buffered.Write([]byte{0xFF, MARKER_SOI}); err != nil {
+		return err
+	}
+
+	for _, s := range l.Segments {
+		if s.Type == MARKER_FRAME {
+			if _, err := buffered.Write(s.Body); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := writeSegment(buffered, s.Type, s.Body); err != nil {
+			return err
+		}
+	}
+
+	if _, err := buffered.Write([]byte{0xFF, MARKER_EOI}); err != nil {
+		return err
+	}
+
+	if _, err := buffered.Write(l.Trailer); err != nil {
+		return err
+	}
+
+	return buffered.Flush()
+}
+
+func (l *SegmentList) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := l.Write(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Drops every segment keep returns false for. Returns how many went
+func (l *SegmentList) Filter(keep func(s *Section) bool) int {
+	kept := make([]*Section, 0, len(l.Segments))
+
+	for _, s := range l.Segments {
+		if keep(s) {
+			kept = append(kept, s)
+		}
+	}
+
+	removed := len(l.Segments) - len(kept)
+	l.Segments = kept
+
+	return removed
+}
+
+// Gives every segment match returns true for a new body. Returns how many were replaced
+func (l *SegmentList) Replace(match func(s *Section) bool, body []byte) int {
+	replaced := 0
+
+	for _, s := range l.Segments {
+		if match(s) {
+			s.Body = body
+			replaced++
+		}
+	}
+
+	return replaced
+}
+
+// Adds a segment ahead of the first one with a marker that isn't APPn, so it ends up with the rest of the
+// application data
+func (l *SegmentList) InsertAppSegment(s *Section) {
+	i := 0
+	for i < len(l.Segments) && l.Segments[i].IsApp() {
+		i++
+	}
+
+	l.Segments = append(l.Segments[:i], append([]*Section{s}, l.Segments[i:]...)...)
+}
+
+// Drops everything that describes the picture rather than being needed to show it. JFIF and Adobe segments stay
+// since they say how to interpret the color, and ICC profiles stay when keepICC is set. The trailer goes too, since
+// without the MPF segment nothing points into it
+func (l *SegmentList) StripMetadata(keepICC bool) int {
+	removed := l.Filter(func(s *Section) bool {
+		switch {
+		case s.Type == MARKER_COM:
+			return false
+		case !s.IsApp():
+			return true
+		case s.Type == MARKER_JFIF && bytes.HasPrefix(s.Body, jfifIdentifier):
+			return true
+		case s.Type == MARKER_APP14 && bytes.HasPrefix(s.Body, adobeIdentifier):
+			return true
+		case keepICC && s.IsICC():
+			return true
+		}
+
+		return false
+	})
+
+	l.Trailer = nil
+
+	return removed
+}
+
+// Runs edit over a copy of each EXIF segment and swaps the result in. Returns an error if there's no EXIF
+func (l *SegmentList) EditExif(edit func(e *ExifEditor) error) error {
+	found := false
+
+	for _, s := range l.Segments {
+		if s.Type != MARKER_EXIF || !IsExif(s.Body) {
+			continue
+		}
+
+		body := append([]byte{}, s.Body...)
+
+		e, err := NewExifEditor(body)
+		if err != nil {
+			return err
+		}
+
+		if err := edit(e); err != nil {
+			return err
+		}
+
+		s.Body = body
+		found = true
+	}
+
+	if !found {
+		return errors.New("no EXIF segment")
+	}
+
+	return nil
+}
+
+// Overwrites the given XMP properties with spaces in each standard XMP packet, both as attributes and as
+// elements. properties are regular expressions for the qualified names. Nothing changes size, so the packet's
+// padding and any MPF offsets stay right. Extended XMP isn't touched, since editing it would change the MD5 the
+// standard packet points at it by. Returns how many were blanked
+func (l *SegmentList) BlankXmp(properties []string) int {
+	blanked := 0
+
+	for _, s := range l.Segments {
+		if s.Type != MARKER_EXIF || !IsXmp(s.Body) {
+			continue
+		}
+
+		body := append([]byte{}, s.Body...)
+		packet := body[len(xmpIdentifier):]
+
+		for _, property := range properties {
+			attribute := regexp.MustCompile(`\s` + property + `=(?:"[^"]*"|'[^']*')`)
+			element := regexp.MustCompile(`(?s)<` + property + `(?:\s[^>]*)?(?:/>|>.*?</` + property + `>)`)
+
+			for _, re := range []*regexp.Regexp{attribute, element} {
+				for _, match := range re.FindAllIndex(packet, -1) {
+					for i := match[0]; i < match[1]; i++ {
+						packet[i] = ' '
+					}
+					blanked++
+				}
+			}
+		}
+
+		s.Body = body
+	}
+
+	return blanked
+}
+
+// APP0 to APP15
+func (s *Section) IsApp() bool {
+	return s.Type >= MARKER_JFIF && s.Type <= MARKER_APP15
+}
+
+// One chunk of an ICC profile. Profiles too big for a segment are split over several (ICC.1 annex B.4)
+func (s *Section) IsICC() bool {
+	return s.Type == MARKER_APP2 && bytes.HasPrefix(s.Body, iccIdentifier)
+}
@@ -0,0 +1,206 @@
+package jpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func testSegmentList(t *testing.T, b []byte) *SegmentList {
+	l, err := ReadSegmentList(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+func TestSegmentListRoundTrip(t *testing.T) {
+	spec, err := ioutil.ReadFile("../spec.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withMetadata, _ := testFileWithMetadata(t)
+	restarts := testEncode(t, testImage(40, 24, false, 2), &EncodeOptions{RestartInterval: 1})
+
+	cases := map[string][]byte{
+		"spec.jpg":              spec,
+		"metadata":              withMetadata,
+		"restarts with trailer": append(append([]byte{}, restarts...), "trailing data"...),
+		"fill bytes":            append([]byte{0xFF, MARKER_SOI, 0xFF, 0xFF}, withMetadata[2:]...),
+		// A marker the parser has no use for can only be damage, and stays in the data
+		"damaged marker": append(append(append([]byte{}, restarts[:len(restarts)-10]...), 0xFF, 0x13), restarts[len(restarts)-10:]...),
+	}
+
+	for name, b := range cases {
+		t.Run(name, func(t *testing.T) {
+			out, err := testSegmentList(t, b).Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Fill bytes before a marker aren't kept
+			want := b
+			if name == "fill bytes" {
+				want = withMetadata
+			}
+
+			if !bytes.Equal(out, want) {
+				t.Errorf("got %d bytes back from %d", len(out), len(want))
+			}
+		})
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	withMetadata, _ := testFileWithMetadata(t)
+
+	for _, keepICC := range []bool{true, false} {
+		l := testSegmentList(t, append(append([]byte{}, withMetadata...), "trailing data"...))
+
+		// EXIF, COM and MPF always go, and the ICC profile unless it's kept
+		want := 4
+		if keepICC {
+			want = 3
+		}
+		if removed := l.StripMetadata(keepICC); removed != want {
+			t.Errorf("keepICC %v removed %d segments", keepICC, removed)
+		}
+
+		out, err := l.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		segments, err := ReadHeaderSegments(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var kept []string
+		for _, s := range segments {
+			switch {
+			case s.Type == MARKER_JFIF:
+				kept = append(kept, "JFIF")
+			case s.IsICC():
+				kept = append(kept, "ICC")
+			case s.Type == MARKER_APP14 && bytes.HasPrefix(s.Body, adobeIdentifier):
+				kept = append(kept, "Adobe")
+			case s.IsApp() || s.Type == MARKER_COM:
+				t.Errorf("kept a %#x segment", s.Type)
+			}
+		}
+
+		wantKept := "JFIF Adobe"
+		if keepICC {
+			wantKept = "JFIF ICC Adobe"
+		}
+		if strings.Join(kept, " ") != wantKept {
+			t.Errorf("keepICC %v kept %v", keepICC, kept)
+		}
+
+		if !bytes.HasSuffix(out, []byte{0xFF, MARKER_EOI}) {
+			t.Error("the trailer was kept")
+		}
+
+		testDecode(t, out)
+	}
+}
+
+func TestBlankXmp(t *testing.T) {
+	packet := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description exif:GPSLatitude="51,30.0N" exif:GPSLongitude='0,7.5W' exif:DateTimeOriginal="2020-01-01">
+<aux:SerialNumber>1234567</aux:SerialNumber>
+<exif:GPSVersionID><rdf:Seq><rdf:li>2</rdf:li></rdf:Seq></exif:GPSVersionID>
+<exifEX:BodySerialNumber/>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+	b := testEncode(t, testImage(16, 16, false, 3), &EncodeOptions{})
+	xmp := append(append([]byte{}, xmpIdentifier...), packet...)
+	b = append(append(append([]byte{}, b[:2]...), testSegment(MARKER_EXIF, xmp)...), b[2:]...)
+
+	l := testSegmentList(t, b)
+
+	if blanked := l.BlankXmp(XmpGPSProperties); blanked != 3 {
+		t.Errorf("blanked %d GPS properties", blanked)
+	}
+	if blanked := l.BlankXmp(XmpSerialProperties); blanked != 2 {
+		t.Errorf("blanked %d serial number properties", blanked)
+	}
+
+	out, err := l.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blanking keeps every size, so the file only differs inside the packet
+	if len(out) != len(b) {
+		t.Fatalf("went from %d to %d bytes", len(b), len(out))
+	}
+
+	x, err := ReadXMP(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(x.Packet)
+	for _, gone := range []string{"GPS", "51,30.0N", "Serial", "1234567"} {
+		if strings.Contains(got, gone) {
+			t.Errorf("%q is still in %s", gone, got)
+		}
+	}
+	if !strings.Contains(got, `exif:DateTimeOriginal="2020-01-01">`) {
+		t.Errorf("blanked too much: %s", got)
+	}
+
+	// The input isn't edited in place
+	if !bytes.Contains(b, []byte("1234567")) {
+		t.Error("the input was changed")
+	}
+}
+
+// Resizing anything in a Multi-Picture primary would leave the MP Index pointing at the wrong bytes
+func TestSegmentListMpf(t *testing.T) {
+	primary, second, start := testMpfImages(t)
+
+	b := buildMpf(binary.BigEndian, primary, []testMpEntry{
+		{MP_TYPE_BASELINE_PRIMARY, start, 0},
+		{MP_TYPE_LARGE_THUMBNAIL_1, len(second), start - testMpfBase},
+	}, second)
+
+	isCom := func(s *Section) bool { return s.Type == MARKER_COM }
+
+	l := testSegmentList(t, b)
+	l.InsertAppSegment(NewSection(MARKER_COM, []byte("x")))
+	if _, err := l.Bytes(); err == nil {
+		t.Error("wrote an MPF file with a bigger primary")
+	}
+
+	// Putting it back to the size it was is fine
+	l.Filter(func(s *Section) bool { return !isCom(s) })
+	if out, err := l.Bytes(); err != nil || !bytes.Equal(out, b) {
+		t.Errorf("got %v", err)
+	}
+
+	l.InsertAppSegment(NewSection(MARKER_COM, []byte("abc")))
+	l.Replace(isCom, []byte("abcdef"))
+	if _, err := l.Bytes(); err == nil {
+		t.Error("wrote an MPF file after a bigger Replace")
+	}
+
+	// Without the MPF segment or the trailer nothing points past the primary
+	l.StripMetadata(false)
+	if _, err := l.Bytes(); err != nil {
+		t.Error(err)
+	}
+
+	l = testSegmentList(t, b)
+	l.Trailer = nil
+	l.InsertAppSegment(NewSection(MARKER_COM, []byte("x")))
+	if _, err := l.Bytes(); err != nil {
+		t.Error(err)
+	}
+}
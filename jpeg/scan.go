@@ -0,0 +1,62 @@
+package jpeg
+
+import (
+	"huffman"
+)
+
+// One scan (B.2.3): its header, its entropy coded data and the tables that were in effect when it started. Baseline
+// files usually have a single scan. Progressive files have many, and can redefine Huffman tables and the restart
+// interval between them
+type Scan struct {
+	// The SOS segment body
+	Header []byte
+	// Entropy coded data up to the next marker that isn't RSTn, restart markers included
+	Body []byte
+	// Offset of the SOS marker in the file
+	Offset int
+	// Every table defined before this scan. Later definitions of the same table win
+	HuffmanReaders  []*huffman.HuffmanReader
+	RestartInterval int
+	Components      []*Component
+	// Table selectors, one per scan component
+	Td []int
+	Ta []int
+	// Spectral selection and successive approximation (G.1.1.1). Baseline is always 0, 63, 0, 0
+	Ss        int
+	Se        int
+	Ah        int
+	Al        int
+	Intervals []*Interval
+}
+
+func NewScan(header []byte, body []byte, offset int, huffmanReaders []*huffman.HuffmanReader, restartInterval int) *Scan {
+	return &Scan{
+		Header:          header,
+		Body:            body,
+		Offset:          offset,
+		HuffmanReaders:  huffmanReaders,
+		RestartInterval: restartInterval,
+	}
+}
+
+func (s *Scan) IsDC() bool {
+	return s.Ss == 0
+}
+
+func (s *Scan) IsRefinement() bool {
+	return s.Ah != 0
+}
+
+// Makes this the scan that decoding works on: the component table selectors, ScanComponents, the Huffman tables
+// GetHuffmanReader hands out and the intervals
+func (j *JpegParser) ActivateScan(s *Scan) {
+	for i, c := range s.Components {
+		c.Td = s.Td[i]
+		c.Ta = s.Ta[i]
+	}
+
+	j.CurrentScan = s
+	j.ScanComponents = s.Components
+	j.RestartInterval = s.RestartInterval
+	j.Intervals = s.Intervals
+}
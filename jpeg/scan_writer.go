@@ -0,0 +1,49 @@
+package jpeg
+
+// The writing side of Interval. Collects entropy coded bits into bytes, stuffing a 0x00 after every 0xFF so the
+// data can't be mistaken for a marker (F.1.2.3)
+type ScanWriter struct {
+	Body        []byte
+	bitCount    int
+	workingByte byte
+}
+
+func NewScanWriter() *ScanWriter {
+	return &ScanWriter{Body: make([]byte, 0)}
+}
+
+func (s *ScanWriter) WriteBits(bits int, numBits int) {
+	for i := numBits - 1; i >= 0; i-- {
+		s.workingByte <<= 1
+		s.workingByte |= byte((bits >> uint(i)) & 1)
+		s.bitCount++
+
+		if s.bitCount == 8 {
+			s.emitByte()
+		}
+	}
+}
+
+func (s *ScanWriter) emitByte() {
+	s.Body = append(s.Body, s.workingByte)
+
+	if s.workingByte == 0xFF {
+		s.Body = append(s.Body, 0x00)
+	}
+
+	s.workingByte = 0
+	s.bitCount = 0
+}
+
+// Pads the last byte out with 1 bits (F.1.2.3). Needed before a restart marker and at the end of the scan
+func (s *ScanWriter) Flush() {
+	for s.bitCount != 0 {
+		s.WriteBits(1, 1)
+	}
+}
+
+// RSTm markers count 0 to 7 and wrap
+func (s *ScanWriter) WriteRestart(m int) {
+	s.Flush()
+	s.Body = append(s.Body, 0xFF, MARKER_RST0+byte(m%8))
+}
@@ -1,8 +1,15 @@
 package jpeg
 
+import (
+	"errors"
+	"fmt"
+)
+
 type Section struct {
 	Type byte
 	Body []byte
+	// Offset of the marker (the 0xFF byte) from the start of the file
+	Offset int
 }
 
 func NewSection(inboundType byte, body []byte) *Section {
@@ -17,15 +24,106 @@ const (
 	MARKER_DRI  byte = 0xdd
 	MARKER_EOI  byte = 0xd9
 	MARKER_SOF0 byte = 0xc0
+	MARKER_SOF1 byte = 0xc1 // extended sequential, Huffman
+	MARKER_SOF2 byte = 0xc2 // progressive, Huffman
 	MARKER_SOI  byte = 0xd8
 	MARKER_SOS  byte = 0xda
+	MARKER_RST0 byte = 0xd0
+	MARKER_RST7 byte = 0xd7
 
 	// Non image
 	MARKER_EXIF                   byte = 0xe1
 	MARKER_JFIF                   byte = 0xe0
 	MARKER_UNKNOWN_EXTENSION_MASK byte = 0xe0 // if it's not one of the named ones, i.e. JFIF or EXIF, we just mask it out
 	MARKER_UNKNOWN_EXTENSION      byte = 0xe2 // We need to store it under something so I used 0xe2 to represent the rest of them
+	MARKER_APP2                   byte = 0xe2 // ICC profiles and MPF. Only meaningful in Segments, which keeps the real marker
+	MARKER_APP14                  byte = 0xee // Adobe
+	MARKER_APP15                  byte = 0xef
+	MARKER_COM                    byte = 0xfe // comment. The parser lumps it in with the extensions
 
 	// phony since no marker to start this. Just at the end of the scan
 	MARKER_FRAME byte = 0x00
 )
+
+// Walks the marker segments from SOI up to and including the first SOS without touching the entropy coded data
+// or building any tables. Useful when only the metadata is wanted
+func ReadHeaderSegments(b []byte) ([]*Section, error) {
+	if len(b) < 2 || b[0] != 0xFF || b[1] != MARKER_SOI {
+		return nil, errors.New("missing SOI")
+	}
+
+	segments := make([]*Section, 0)
+
+	offset := 2
+
+	for offset+4 <= len(b) {
+		if b[offset] != 0xFF {
+			return nil, errors.New("expected a marker")
+		}
+
+		marker := b[offset+1]
+
+		// Any number of 0xFF fill bytes may come before a marker (B.1.1.2)
+		if marker == 0xFF {
+			offset++
+			continue
+		}
+
+		length := int(b[offset+2])<<8 | int(b[offset+3])
+
+		if length < 2 || offset+2+length > len(b) {
+			return nil, errors.New("marker segment runs past end of file")
+		}
+
+		section := NewSection(marker, b[offset+4:offset+2+length])
+		section.Offset = offset
+		segments = append(segments, section)
+
+		if marker == MARKER_SOS {
+			return segments, nil
+		}
+
+		offset += 2 + length
+	}
+
+	return nil, errors.New("got to the end of the file without a scan start")
+}
+
+// The name Table B.1 gives a marker code, such as SOF2, RST5 or APP1. Reserved codes come back as RES
+func MarkerName(m byte) string {
+	switch {
+	case m == 0xc4:
+		return "DHT"
+	case m == 0xc8:
+		return "JPG"
+	case m == 0xcc:
+		return "DAC"
+	case m >= 0xc0 && m <= 0xcf:
+		return fmt.Sprintf("SOF%d", m-0xc0)
+	case m >= MARKER_RST0 && m <= MARKER_RST7:
+		return fmt.Sprintf("RST%d", m-MARKER_RST0)
+	case m >= 0xe0 && m <= 0xef:
+		return fmt.Sprintf("APP%d", m-0xe0)
+	case m >= 0xf0 && m <= 0xfd:
+		return fmt.Sprintf("JPG%d", m-0xf0)
+	}
+
+	names := map[byte]string{
+		MARKER_SOI: "SOI",
+		MARKER_EOI: "EOI",
+		MARKER_SOS: "SOS",
+		MARKER_DQT: "DQT",
+		0xdc:       "DNL",
+		MARKER_DRI: "DRI",
+		0xde:       "DHP",
+		0xdf:       "EXP",
+		MARKER_COM: "COM",
+		0x01:       "TEM",
+	}
+
+	if name, present := names[m]; present {
+		return name
+	}
+
+	return "RES"
+}
@@ -0,0 +1,252 @@
+package jpeg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+
+	"huffman"
+)
+
+// Where the bits of the entropy coded data go. The decoder counts the bits each block takes from its interval,
+// DC and AC apart, and the rest comes from the coefficients once every scan is in
+
+type BitStats struct {
+	Width   int `json:"width"`
+	Height  int `json:"height"`
+	MCUCols int `json:"mcu_cols"`
+	MCURows int `json:"mcu_rows"`
+	// Pixels per MCU
+	MCUWidth  int `json:"mcu_width"`
+	MCUHeight int `json:"mcu_height"`
+	// Bits decoded for each frame MCU, every component and scan together. Padding bits and restart markers
+	// aren't part of any MCU
+	MCUBits    []int               `json:"mcu_bits"`
+	Components []ComponentBitStats `json:"components"`
+	TotalBits  int                 `json:"total_bits"`
+	FileSize   int                 `json:"file_size"`
+}
+
+type ComponentBitStats struct {
+	Id int `json:"id"`
+	// Blocks that hold image data. The padding blocks of an interleaved scan count towards the bits but not
+	// towards the distributions below
+	Blocks int `json:"blocks"`
+	DCBits int `json:"dc_bits"`
+	ACBits int `json:"ac_bits"`
+	// ZeroRuns[r] counts the non zero AC coefficients with r zeros before them in zig-zag order, runs longer than
+	// 15 included, which are coded with ZRLs
+	ZeroRuns [63]int `json:"zero_runs"`
+	// EOBPositions[k] counts the blocks whose last non zero AC coefficient is at zig-zag position k, so the EOB
+	// comes right after it. 0 is a block with only a DC and 63 one that needs no EOB
+	EOBPositions [64]int `json:"eob_positions"`
+	// Quantized coefficients by magnitude category, the SSSS of F.1.2.1. Category n holds magnitudes from
+	// 2^(n-1) to 2^n - 1, and category 0 the zeros
+	DCMagnitudes [16]int `json:"dc_magnitudes"`
+	ACMagnitudes [16]int `json:"ac_magnitudes"`
+}
+
+// Bits used by each block, per component and by position in the component's plane
+type bitCounter struct {
+	dc map[*Component][]int
+	ac map[*Component][]int
+}
+
+func (b *bitCounter) add(c *Component, index int, dcBits int, acBits int) {
+	if b == nil {
+		return
+	}
+
+	b.dc[c][index] += dcBits
+	b.ac[c][index] += acBits
+}
+
+// Decodes the whole image, counting bits as it goes. The error is the same as Decode's, and the counts are still
+// there when it's a damaged file
+func (j *JpegParser) Stats() (*BitStats, error) {
+	if len(j.Components) != 1 && len(j.Components) != 3 {
+		return nil, errors.New("only grayscale and YCbCr images are supported")
+	}
+
+	sizes := j.planeSizes()
+	counter := &bitCounter{dc: make(map[*Component][]int), ac: make(map[*Component][]int)}
+
+	for i, c := range j.Components {
+		counter.dc[c] = make([]int, sizes[i][0]*sizes[i][1])
+		counter.ac[c] = make([]int, sizes[i][0]*sizes[i][1])
+	}
+
+	j.bitCounter = counter
+	defer func() { j.bitCounter = nil }()
+
+	planes, decodeErr := j.decodeCoefficientPlanes(&DecodeOptions{})
+
+	s := &BitStats{
+		Width:      j.XLines,
+		Height:     j.YLines,
+		MCUCols:    j.frameMCUCols(),
+		MCURows:    j.frameMCURows(),
+		Components: make([]ComponentBitStats, 0),
+		FileSize:   int(j.ByteReader.Size()),
+	}
+	s.MCUWidth, s.MCUHeight = j.frameMCUSize()
+
+	s.MCUBits = make([]int, s.MCUCols*s.MCURows)
+
+	for _, p := range planes {
+		c := p.component
+		cs := ComponentBitStats{Id: c.Id}
+		cols, rows := j.ComponentBlocks(c)
+
+		for index, coefficients := range p.coefficients {
+			row := index / p.blockCols
+			col := index % p.blockCols
+
+			bits := counter.dc[c][index] + counter.ac[c][index]
+			cs.DCBits += counter.dc[c][index]
+			cs.ACBits += counter.ac[c][index]
+			s.MCUBits[j.blockMCU(c, row, col)] += bits
+			s.TotalBits += bits
+
+			if row >= rows || col >= cols {
+				continue
+			}
+
+			cs.Blocks++
+			cs.addBlock(coefficients)
+		}
+
+		s.Components = append(s.Components, cs)
+	}
+
+	return s, decodeErr
+}
+
+func (cs *ComponentBitStats) addBlock(coefficients [64]int) {
+	cs.DCMagnitudes[minInt(huffman.Category(coefficients[0]), 15)]++
+
+	run := 0
+	last := 0
+
+	for k := 1; k < 64; k++ {
+		cs.ACMagnitudes[minInt(huffman.Category(coefficients[k]), 15)]++
+
+		if coefficients[k] == 0 {
+			run++
+			continue
+		}
+
+		cs.ZeroRuns[run]++
+		run = 0
+		last = k
+	}
+
+	cs.EOBPositions[last]++
+}
+
+// The frame MCU a block of a component's plane belongs to. A single component frame has one block per MCU,
+// otherwise each MCU holds H x V blocks of the component
+func (f *Frame) blockMCU(c *Component, row int, col int) int {
+	if len(f.Components) == 1 {
+		return row*f.frameMCUCols() + col
+	}
+
+	return (row/c.V)*f.frameMCUCols() + col/c.H
+}
+
+// The image with each MCU coloured by the bits it took, from black for none through red and yellow to white for
+// the most any MCU took
+func (s *BitStats) Heatmap() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, s.Width, s.Height))
+
+	most := 1
+	for _, bits := range s.MCUBits {
+		most = maxInt(most, bits)
+	}
+
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; x++ {
+			bits := s.MCUBits[(y/s.MCUHeight)*s.MCUCols+x/s.MCUWidth]
+			img.SetRGBA(x, y, heatColour(float64(bits)/float64(most)))
+		}
+	}
+
+	return img
+}
+
+// 0 to 1 as black, red, yellow, white, a third of the way each
+func heatColour(v float64) color.RGBA {
+	channel := func(start float64) uint8 {
+		return uint8(255 * clampFloat((v-start)*3, 0, 1))
+	}
+
+	return color.RGBA{channel(0), channel(1.0 / 3), channel(2.0 / 3), 255}
+}
+
+func clampFloat(v float64, low float64, high float64) float64 {
+	if v < low {
+		return low
+	}
+	if v > high {
+		return high
+	}
+	return v
+}
+
+func (s *BitStats) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(s)
+}
+
+func (s *BitStats) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%d bits of entropy coded data, %d bytes, %.1f%% of the %d byte file\n", s.TotalBits, s.TotalBits/8, 100*float64(s.TotalBits)/8/float64(s.FileSize), s.FileSize)
+
+	least, most := s.TotalBits, 0
+	for _, bits := range s.MCUBits {
+		least = minInt(least, bits)
+		most = maxInt(most, bits)
+	}
+	fmt.Fprintf(&b, "%d MCUs of %dx%d pixels: %d to %d bits, %.1f on average\n", len(s.MCUBits), s.MCUWidth, s.MCUHeight, least, most, float64(s.TotalBits)/float64(len(s.MCUBits)))
+
+	for _, cs := range s.Components {
+		bits := cs.DCBits + cs.ACBits
+		fmt.Fprintf(&b, "\nComponent %d: %d bits, %.1f%% of the total, %d DC and %d AC. %.1f bits per block over %d blocks\n", cs.Id, bits, 100*float64(bits)/float64(maxInt(s.TotalBits, 1)), cs.DCBits, cs.ACBits, float64(bits)/float64(maxInt(cs.Blocks, 1)), cs.Blocks)
+
+		histogram(&b, "Zero runs before a non zero AC coefficient", "run", cs.ZeroRuns[:])
+		histogram(&b, "Last non zero AC coefficient, where the EOB goes", "k", cs.EOBPositions[:])
+		histogram(&b, "DC coefficient magnitude categories", "SSSS", cs.DCMagnitudes[:])
+		histogram(&b, "AC coefficient magnitude categories", "SSSS", cs.ACMagnitudes[:])
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// The non zero counts, with a bar scaled to the largest
+func histogram(b *strings.Builder, title string, label string, counts []int) {
+	fmt.Fprintf(b, "  %s\n", title)
+
+	most := 1
+	total := 0
+	for _, n := range counts {
+		most = maxInt(most, n)
+		total += n
+	}
+
+	for i, n := range counts {
+		if n == 0 {
+			continue
+		}
+
+		fmt.Fprintf(b, "    %s %2d %9d %5.1f%% %s\n", label, i, n, 100*float64(n)/float64(total), strings.Repeat("#", (n*40+most-1)/most))
+	}
+}
@@ -0,0 +1,64 @@
+package jpeg
+
+import (
+	"testing"
+)
+
+// Without restarts every bit of the scan belongs to some MCU, apart from the 1s padding out the last byte
+func TestStatsBitsAddUp(t *testing.T) {
+	cases := []struct {
+		name string
+		file []byte
+	}{
+		{"gray", testEncode(t, testImage(40, 32, true, 12), &EncodeOptions{})},
+		// 45x29 leaves padding blocks at the right and bottom edges
+		{"4:2:0", testEncode(t, testImage(45, 29, false, 12), &EncodeOptions{Subsampling: SUBSAMPLING_420})},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			j, err := NewJpegParserFromBytes(c.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := j.Stats()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mcuBits := 0
+			for _, bits := range s.MCUBits {
+				mcuBits += bits
+			}
+
+			componentBits := 0
+			for _, cs := range s.Components {
+				componentBits += cs.DCBits + cs.ACBits
+			}
+
+			if mcuBits != s.TotalBits || componentBits != s.TotalBits {
+				t.Errorf("MCUs add up to %d bits and components to %d, the total is %d", mcuBits, componentBits, s.TotalBits)
+			}
+
+			// The data with its stuffed 0x00 bytes taken out
+			data := make([]byte, 0)
+			body := j.Scans[0].Body
+			for i := 0; i < len(body); i++ {
+				data = append(data, body[i])
+				if body[i] == 0xFF {
+					i++
+				}
+			}
+
+			padding := len(data)*8 - s.TotalBits
+			if padding < 0 || padding > 7 {
+				t.Fatalf("%d bits counted in %d bytes of data", s.TotalBits, len(data))
+			}
+
+			if last := data[len(data)-1]; int(last)&(1<<uint(padding)-1) != 1<<uint(padding)-1 {
+				t.Errorf("the %d bits after the last MCU are %08b, not all 1s", padding, last)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package jpeg
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	stdjpeg "image/jpeg"
+	"math"
+	"testing"
+)
+
+// Differential tests against image/jpeg. Both decoders get the same files and have to agree on every pixel to
+// within stdlibTolerance.
+//
+// They aren't expected to agree exactly. image/jpeg uses an integer IDCT where this decoder uses the float one
+// from A.3.3, and its colour conversion rounds where this one truncates. Chroma upsampling is the same in both,
+// each pixel taking the sample it falls on. Together these come to a few levels at most
+const stdlibTolerance = 4
+
+// Sizes that don't fill whole MCUs, so the edges of the frame get tested along with the middle
+var stdlibSizes = [][2]int{{1, 1}, {7, 9}, {17, 15}, {33, 47}, {100, 75}, {129, 66}}
+
+var stdlibQualities = []int{1, 10, 50, 75, 90, 100}
+
+// What one comparison found. MCUs lists the MCUs with any difference at all
+type stdlibDiff struct {
+	MaxError int
+	PSNR     float64
+	MCUs     []int
+	// The MCUs with a difference over stdlibTolerance
+	Failed []int
+	// MCUs in the frame
+	Total int
+}
+
+func stdlibEncode(t *testing.T, img image.Image, quality int) []byte {
+	var buf bytes.Buffer
+
+	if err := stdjpeg.Encode(&buf, img, &stdjpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// Rewrites the coefficients of b with restart markers. image/jpeg doesn't write them itself
+func withRestarts(t *testing.T, b []byte, restartInterval int) []byte {
+	j, err := NewJpegParserFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := j.DecodeCoefficients()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewEncoderFromCoefficients(c, &EncodeOptions{RestartInterval: restartInterval})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := e.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// Decodes b with both decoders and compares them pixel by pixel
+func compareWithStdlib(t *testing.T, b []byte) *stdlibDiff {
+	j, err := NewJpegParserFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ours, err := j.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	theirs, err := stdjpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ours.Bounds() != theirs.Bounds() {
+		t.Fatalf("decoded to %v, image/jpeg decoded to %v", ours.Bounds(), theirs.Bounds())
+	}
+
+	mcuWidth, mcuHeight := j.frameMCUSize()
+	mcuCols := j.frameMCUCols()
+
+	d := &stdlibDiff{Total: mcuCols * j.frameMCURows()}
+	worst := make(map[int]int)
+	squares := 0.0
+	samples := 0
+
+	for y := 0; y < j.YLines; y++ {
+		for x := 0; x < j.XLines; x++ {
+			p := ours.RGBAAt(x, y)
+			r, g, b, _ := theirs.At(x, y).RGBA()
+
+			m := (y/mcuHeight)*mcuCols + x/mcuWidth
+
+			for _, e := range []int{int(p.R) - int(r>>8), int(p.G) - int(g>>8), int(p.B) - int(b>>8)} {
+				if e < 0 {
+					e = -e
+				}
+
+				d.MaxError = maxInt(d.MaxError, e)
+				squares += float64(e * e)
+				samples++
+
+				if e > 0 {
+					if _, seen := worst[m]; !seen {
+						d.MCUs = append(d.MCUs, m)
+					}
+					worst[m] = maxInt(worst[m], e)
+				}
+			}
+		}
+	}
+
+	for _, m := range d.MCUs {
+		if worst[m] > stdlibTolerance {
+			d.Failed = append(d.Failed, m)
+		}
+	}
+
+	d.PSNR = math.Inf(1)
+	if squares > 0 {
+		d.PSNR = 10 * math.Log10(255*255/(squares/float64(samples)))
+	}
+
+	return d
+}
+
+func checkAgainstStdlib(t *testing.T, b []byte) {
+	d := compareWithStdlib(t, b)
+
+	t.Logf("max error %d, PSNR %.1f dB, %d of %d MCUs differ", d.MaxError, d.PSNR, len(d.MCUs), d.Total)
+
+	if len(d.Failed) > 0 {
+		shown := d.Failed
+		if len(shown) > 10 {
+			shown = shown[:10]
+		}
+		t.Errorf("%d MCUs are off by more than %d, the most by %d: %v", len(d.Failed), stdlibTolerance, d.MaxError, shown)
+	}
+}
+
+// Files written by image/jpeg: 4:2:0 colour and grayscale, every quality and size
+func TestStdlibQualities(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		for _, size := range stdlibSizes {
+			for _, quality := range stdlibQualities {
+				name := fmt.Sprintf("gray=%v/%dx%d/q%d", gray, size[0], size[1], quality)
+
+				t.Run(name, func(t *testing.T) {
+					img := testImage(size[0], size[1], gray, int64(size[0]*size[1]+quality))
+					checkAgainstStdlib(t, stdlibEncode(t, img, quality))
+				})
+			}
+		}
+	}
+}
+
+// The same files with restart markers added, including an interval of one MCU and ones that don't divide the
+// MCU count
+func TestStdlibRestartIntervals(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		for _, size := range stdlibSizes[2:] {
+			for _, restart := range []int{1, 3, 7} {
+				name := fmt.Sprintf("gray=%v/%dx%d/restart%d", gray, size[0], size[1], restart)
+
+				t.Run(name, func(t *testing.T) {
+					img := testImage(size[0], size[1], gray, int64(size[0]+size[1]))
+					checkAgainstStdlib(t, withRestarts(t, stdlibEncode(t, img, 75), restart))
+				})
+			}
+		}
+	}
+}
+
+// image/jpeg only writes 4:2:0, so the other subsampling ratios come from this package's encoder. image/jpeg
+// decodes them all
+func TestStdlibSubsampling(t *testing.T) {
+	ratios := map[string]int{"444": SUBSAMPLING_444, "422": SUBSAMPLING_422, "420": SUBSAMPLING_420}
+
+	for ratioName, subsampling := range ratios {
+		for _, size := range stdlibSizes {
+			for _, quality := range []int{25, 75, 95} {
+				for _, restart := range []int{0, 5} {
+					name := fmt.Sprintf("%s/%dx%d/q%d/restart%d", ratioName, size[0], size[1], quality, restart)
+
+					t.Run(name, func(t *testing.T) {
+						img := testImage(size[0], size[1], false, int64(size[0]*size[1]+quality))
+
+						var buf bytes.Buffer
+						if err := Encode(&buf, img, &EncodeOptions{Quality: quality, Subsampling: subsampling, RestartInterval: restart}); err != nil {
+							t.Fatal(err)
+						}
+
+						checkAgainstStdlib(t, buf.Bytes())
+					})
+				}
+			}
+		}
+	}
+}
+
+// Progressive files from this package's encoder, with the default script, which has successive approximation, and
+// with spectral selection alone. image/jpeg has its own progressive decoder, so this checks both the encoder and
+// the decoder's refinement scans against it.
+//
+// image/jpeg counts restart intervals in a non-interleaved scan in frame MCUs of H x V blocks rather than in
+// single blocks (A.2.2), so it only finds the markers where they go when every component is 1x1. Restart markers
+// are left out of the 4:2:0 files
+func TestStdlibProgressive(t *testing.T) {
+	spectralOnly := func(components int) []ScanSpec {
+		script := []ScanSpec{{Components: []int{0}, Ss: 0, Se: 0}}
+		if components == 3 {
+			script[0].Components = []int{0, 1, 2}
+		}
+
+		for c := 0; c < components; c++ {
+			script = append(script, ScanSpec{Components: []int{c}, Ss: 1, Se: 9}, ScanSpec{Components: []int{c}, Ss: 10, Se: 63})
+		}
+
+		return script
+	}
+
+	layouts := []struct {
+		name        string
+		gray        bool
+		subsampling int
+		restarts    []int
+	}{
+		{"gray", true, SUBSAMPLING_444, []int{0, 5}},
+		{"444", false, SUBSAMPLING_444, []int{0, 5}},
+		{"420", false, SUBSAMPLING_420, []int{0}},
+	}
+
+	for _, layout := range layouts {
+		components := 3
+		if layout.gray {
+			components = 1
+		}
+
+		scripts := map[string][]ScanSpec{"default": nil, "spectral": spectralOnly(components)}
+
+		for scriptName, script := range scripts {
+			for _, size := range stdlibSizes {
+				for _, quality := range []int{25, 75, 95} {
+					for _, restart := range layout.restarts {
+						name := fmt.Sprintf("%s/%s/%dx%d/q%d/restart%d", layout.name, scriptName, size[0], size[1], quality, restart)
+
+						t.Run(name, func(t *testing.T) {
+							img := testImage(size[0], size[1], layout.gray, int64(size[0]*size[1]+quality))
+
+							var buf bytes.Buffer
+							opts := &EncodeOptions{Quality: quality, Subsampling: layout.subsampling, RestartInterval: restart, Progressive: true, ScanScript: script}
+							if err := Encode(&buf, img, opts); err != nil {
+								t.Fatal(err)
+							}
+
+							checkAgainstStdlib(t, buf.Bytes())
+						})
+					}
+				}
+			}
+		}
+	}
+}
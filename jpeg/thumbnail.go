@@ -0,0 +1,253 @@
+package jpeg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+const (
+	THUMBNAIL_JPEG    int = 0 // a complete JPEG stream from SOI to EOI
+	THUMBNAIL_PALETTE int = 1 // one byte per pixel indexing a 256 entry RGB palette
+	THUMBNAIL_RGB     int = 2 // three bytes per pixel
+
+	// JFXX extension codes from the JFIF 1.02 spec
+	JFXX_JPEG    byte = 0x10
+	JFXX_PALETTE byte = 0x11
+	JFXX_RGB     byte = 0x13
+)
+
+var (
+	jfifIdentifier = []byte("JFIF\x00")
+	jfxxIdentifier = []byte("JFXX\x00")
+)
+
+type Thumbnail struct {
+	// Where it came from: JFIF, JFXX or EXIF
+	Source string
+	Format int
+	// Zero for JPEG thumbnails until they are decoded since only the embedded frame header knows
+	Width  int
+	Height int
+	// Pixels for RGB and palette thumbnails, the whole JPEG stream otherwise
+	Data    []byte
+	Palette []byte
+}
+
+// Finds the thumbnails in the JFIF, JFXX and EXIF segments of the file without parsing the frame. Nothing is decoded
+// until Image is called on one of them. A broken segment is skipped, so the error can come back along with the
+// thumbnails that were fine
+func ReadThumbnails(b []byte) ([]*Thumbnail, error) {
+	segments, err := ReadHeaderSegments(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return thumbnailsFromSegments(segments)
+}
+
+func (j *JpegParser) Thumbnails() ([]*Thumbnail, error) {
+	return thumbnailsFromSegments(j.Segments)
+}
+
+func thumbnailsFromSegments(segments []*Section) ([]*Thumbnail, error) {
+	thumbnails := make([]*Thumbnail, 0)
+	var firstErr error
+
+	for _, s := range segments {
+		var t *Thumbnail
+		var err error
+
+		switch {
+		case s.Type == MARKER_JFIF && bytes.HasPrefix(s.Body, jfifIdentifier):
+			t, err = readJfifThumbnail(s.Body)
+		case s.Type == MARKER_JFIF && bytes.HasPrefix(s.Body, jfxxIdentifier):
+			t, err = readJfxxThumbnail(s.Body)
+		case s.Type == MARKER_EXIF && IsExif(s.Body):
+			t, err = readExifThumbnail(s.Body)
+		}
+
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if t != nil {
+			thumbnails = append(thumbnails, t)
+		}
+	}
+
+	return thumbnails, firstErr
+}
+
+// JFIF APP0: identifier, version (2), units (1), X and Y density (2 each), then Xthumbnail, Ythumbnail and 3n bytes
+// of RGB
+func readJfifThumbnail(body []byte) (*Thumbnail, error) {
+	offset := len(jfifIdentifier) + 7
+
+	if len(body) < offset+2 {
+		return nil, errors.New("JFIF segment too short")
+	}
+
+	width := int(body[offset])
+	height := int(body[offset+1])
+
+	if width == 0 || height == 0 {
+		return nil, nil
+	}
+
+	return newPixelThumbnail("JFIF", THUMBNAIL_RGB, width, height, body[offset+2:], nil)
+}
+
+func readJfxxThumbnail(body []byte) (*Thumbnail, error) {
+	offset := len(jfxxIdentifier)
+
+	if len(body) < offset+1 {
+		return nil, errors.New("JFXX segment too short")
+	}
+
+	code := body[offset]
+	offset += 1
+
+	if code == JFXX_JPEG {
+		return &Thumbnail{Source: "JFXX", Format: THUMBNAIL_JPEG, Data: body[offset:]}, nil
+	}
+
+	if len(body) < offset+2 {
+		return nil, errors.New("JFXX segment too short")
+	}
+
+	width := int(body[offset])
+	height := int(body[offset+1])
+	offset += 2
+
+	switch code {
+	case JFXX_PALETTE:
+		if len(body) < offset+768 {
+			return nil, errors.New("JFXX palette too short")
+		}
+		return newPixelThumbnail("JFXX", THUMBNAIL_PALETTE, width, height, body[offset+768:], body[offset:offset+768])
+	case JFXX_RGB:
+		return newPixelThumbnail("JFXX", THUMBNAIL_RGB, width, height, body[offset:], nil)
+	}
+
+	return nil, fmt.Errorf("unknown JFXX extension code 0x%02x", code)
+}
+
+func newPixelThumbnail(source string, format int, width int, height int, data []byte, palette []byte) (*Thumbnail, error) {
+	bytesPerPixel := 3
+	if format == THUMBNAIL_PALETTE {
+		bytesPerPixel = 1
+	}
+
+	if len(data) < width*height*bytesPerPixel {
+		return nil, fmt.Errorf("%s thumbnail is truncated", source)
+	}
+
+	return &Thumbnail{
+		Source:  source,
+		Format:  format,
+		Width:   width,
+		Height:  height,
+		Data:    data[:width*height*bytesPerPixel],
+		Palette: palette,
+	}, nil
+}
+
+// The thumbnail lives in IFD1, which is the IFD after IFD0 (EXIF 2.3 section 4.5.4)
+func readExifThumbnail(body []byte) (*Thumbnail, error) {
+	t, err := NewExifReader(body)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ifd1, err := t.ReadIFD(t.FirstIFDOffset())
+	if err != nil {
+		return nil, err
+	}
+
+	if ifd1 == 0 {
+		return nil, nil
+	}
+
+	entries, _, err := t.ReadIFD(ifd1)
+	if err != nil {
+		return nil, err
+	}
+
+	offsetEntry := FindEntry(entries, TAG_JPEG_IF_OFFSET)
+	lengthEntry := FindEntry(entries, TAG_JPEG_IF_LENGTH)
+
+	// Uncompressed TIFF thumbnails use strips instead. They're rare enough to leave out
+	if offsetEntry == nil || lengthEntry == nil {
+		return nil, nil
+	}
+
+	start, err := t.Uint(offsetEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := t.Uint(lengthEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	if start+length > len(t.Body) {
+		return nil, errors.New("EXIF thumbnail runs past end of segment")
+	}
+
+	return &Thumbnail{Source: "EXIF", Format: THUMBNAIL_JPEG, Data: t.Body[start : start+length]}, nil
+}
+
+// Builds the image, running JPEG thumbnails through this decoder
+func (t *Thumbnail) Image() (img image.Image, err error) {
+	switch t.Format {
+	case THUMBNAIL_JPEG:
+		// The parser panics on malformed data. A broken thumbnail shouldn't take the caller down with it
+		defer func() {
+			if r := recover(); r != nil {
+				img = nil
+				err = fmt.Errorf("decoding %s thumbnail: %v", t.Source, r)
+			}
+		}()
+
+		j, err := NewJpegParserFromBytes(t.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		rgba, err := j.Decode()
+		if err != nil {
+			return nil, err
+		}
+
+		t.Width = rgba.Bounds().Dx()
+		t.Height = rgba.Bounds().Dy()
+
+		return rgba, nil
+
+	case THUMBNAIL_PALETTE, THUMBNAIL_RGB:
+		rgba := image.NewRGBA(image.Rect(0, 0, t.Width, t.Height))
+
+		for i := 0; i < t.Width*t.Height; i++ {
+			var rgb []byte
+			if t.Format == THUMBNAIL_PALETTE {
+				index := int(t.Data[i])
+				rgb = t.Palette[index*3 : index*3+3]
+			} else {
+				rgb = t.Data[i*3 : i*3+3]
+			}
+
+			rgba.Set(i%t.Width, i/t.Width, color.RGBA{rgb[0], rgb[1], rgb[2], 255})
+		}
+
+		return rgba, nil
+	}
+
+	return nil, errors.New("unknown thumbnail format")
+}
@@ -0,0 +1,39 @@
+package jpeg
+
+import (
+	"strings"
+	"testing"
+)
+
+// A broken segment costs only its own thumbnail
+func TestThumbnailsSkipBrokenSegments(t *testing.T) {
+	jfif := append(append([]byte{}, jfifIdentifier...), 1, 2, 0, 0, 1, 0, 1, 2, 1)
+	jfif = append(jfif, 255, 0, 0, 0, 255, 0)
+
+	jfxx := append(append([]byte{}, jfxxIdentifier...), JFXX_JPEG, 0xFF, MARKER_SOI, 0xFF, MARKER_EOI)
+
+	segments := []*Section{
+		NewSection(MARKER_JFIF, jfif),
+		NewSection(MARKER_EXIF, append(append([]byte{}, exifIdentifier...), 'M', 'M')),
+		NewSection(MARKER_JFIF, append(append([]byte{}, jfxxIdentifier...), 0x42)),
+		NewSection(MARKER_JFIF, jfxx),
+	}
+
+	thumbnails, err := thumbnailsFromSegments(segments)
+
+	if err == nil || !strings.Contains(err.Error(), "TIFF") {
+		t.Errorf("got %v, want the EXIF segment's error", err)
+	}
+
+	if len(thumbnails) != 2 {
+		t.Fatalf("got %d thumbnails", len(thumbnails))
+	}
+
+	if thumbnails[0].Source != "JFIF" || thumbnails[0].Width != 2 || thumbnails[0].Height != 1 {
+		t.Errorf("first is %+v", thumbnails[0])
+	}
+
+	if thumbnails[1].Source != "JFXX" || thumbnails[1].Format != THUMBNAIL_JPEG || len(thumbnails[1].Data) != 4 {
+		t.Errorf("second is %+v", thumbnails[1])
+	}
+}
@@ -0,0 +1,420 @@
+package jpeg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"dct"
+	"huffman"
+)
+
+// A record of every stage one block goes through, from the bits in the file to the pixels on screen. This is what
+// the commented out Printfs used to show, just for the blocks asked for. Sequential files trace the entropy
+// decoding too. Progressive files build each block over several scans so their trace starts at the coefficients
+
+type TraceOptions struct {
+	// Frame MCUs, numbered left to right then top to bottom
+	MCUs []int
+	// Single blocks picked by their position in a component's plane
+	Blocks []BlockPosition
+	// Component ids to keep. Empty keeps them all
+	Components []int
+}
+
+type BlockPosition struct {
+	Component int
+	Row       int
+	Col       int
+}
+
+type BlockTrace struct {
+	// The frame MCU the block belongs to
+	MCU       int `json:"mcu"`
+	Component int `json:"component"`
+	// Block position in the component's plane
+	Row               int `json:"row"`
+	Col               int `json:"col"`
+	QuantizationTable int `json:"quantization_table"`
+	// In decoding order, DC first. Empty for progressive files
+	Symbols []TraceSymbol `json:"symbols,omitempty"`
+	// The DC of the previous block of this component in the interval (F.2.1.3.1)
+	Prediction int `json:"prediction"`
+	// Quantized coefficients in zig-zag order
+	ZigZag [64]int `json:"zigzag"`
+	// Multiplied by the quantization table (F.2.1.4), still in zig-zag order
+	Dequantized [64]int `json:"dequantized"`
+	// De-zigzagged, row by row
+	Matrix [8][8]int `json:"matrix"`
+	// The IDCT output before the level shift (A.3.1)
+	IDCT [8][8]int `json:"idct"`
+	// Level shifted and clamped to 0-255
+	Samples [8][8]int `json:"samples"`
+	// The pixels the block covers in the output image, after upsampling and colour conversion. A subsampled
+	// component covers more than 8x8 pixels, and blocks past the edge of the image cover none
+	X   int          `json:"x"`
+	Y   int          `json:"y"`
+	RGB [][][3]uint8 `json:"rgb"`
+}
+
+type TraceSymbol struct {
+	// "DC" or "AC"
+	Table string `json:"table"`
+	// The decoded value: SSSS for a DC, RRRRSSSS for an AC
+	Symbol int `json:"symbol"`
+	// The Huffman code that was read, most significant bit first
+	Code string `json:"code"`
+	// The extra bits after the code, if any
+	Extra string `json:"extra"`
+	// The DC difference, or the AC coefficient. 0 for EOB and ZRL
+	Value int `json:"value"`
+	// Zig-zag position of the coefficient. For EOB and ZRL, where the run starts
+	Index int `json:"index"`
+}
+
+// Blocks to record, by component and by position in the component's plane
+type tracer struct {
+	blocks map[*Component]map[int]*BlockTrace
+}
+
+func (t *tracer) block(c *Component, index int) *BlockTrace {
+	if t == nil {
+		return nil
+	}
+
+	return t.blocks[c][index]
+}
+
+// Decodes the whole image and returns the trace of the blocks asked for, in the order the options list them. The
+// error is the same as Decode's, and like Decode the traces are still there when it's a damaged file
+func (j *JpegParser) Trace(opts *TraceOptions) ([]*BlockTrace, error) {
+	traces, _, err := j.trace(opts)
+
+	return traces, err
+}
+
+// Trace, also returning the decoded image
+func (j *JpegParser) trace(opts *TraceOptions) ([]*BlockTrace, *image.RGBA, error) {
+	if len(j.Components) != 1 && len(j.Components) != 3 {
+		return nil, nil, errors.New("only grayscale and YCbCr images are supported")
+	}
+
+	traces, err := j.newTracer(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	planes, decodeErr := j.decodeCoefficientPlanes(&DecodeOptions{})
+	j.tracer = nil
+
+	for _, p := range planes {
+		j.reconstructPlane(p)
+	}
+
+	img := j.planesToImage(planes)
+
+	reader := &huffman.HuffmanReader{}
+	dctTransformer := dct.NewTransformer()
+	hMax, vMax := j.MaxSampling()
+
+	for _, t := range traces {
+		p := planes[j.componentIndex(t.Component)]
+		index := t.Row*p.blockCols + t.Col
+
+		t.ZigZag = p.coefficients[index]
+
+		dequantized, natural, spatial := reconstructBlock(t.ZigZag, j.QuantizationTables[p.component.Tq], reader, dctTransformer)
+		t.Dequantized = dequantized
+
+		for i := 0; i < 64; i++ {
+			t.Matrix[i/8][i%8] = natural[i]
+			t.IDCT[i/8][i%8] = spatial[i]
+			t.Samples[i/8][i%8] = p.samples[(t.Row*8+i/8)*p.stride()+t.Col*8+i%8]
+		}
+
+		// planesToImage reads sample x * H / hMax, so these are the pixels that land on this block
+		t.X = ceilDiv(t.Col*8*hMax, p.component.H)
+		t.Y = ceilDiv(t.Row*8*vMax, p.component.V)
+		x1 := minInt(ceilDiv((t.Col+1)*8*hMax, p.component.H), j.XLines)
+		y1 := minInt(ceilDiv((t.Row+1)*8*vMax, p.component.V), j.YLines)
+
+		t.RGB = make([][][3]uint8, 0)
+		for y := t.Y; y < y1; y++ {
+			row := make([][3]uint8, 0)
+			for x := t.X; x < x1; x++ {
+				c := img.RGBAAt(x, y)
+				row = append(row, [3]uint8{c.R, c.G, c.B})
+			}
+			t.RGB = append(t.RGB, row)
+		}
+	}
+
+	return traces, img, decodeErr
+}
+
+// Works out which blocks the options pick and sets up j.tracer to catch them
+func (j *JpegParser) newTracer(opts *TraceOptions) ([]*BlockTrace, error) {
+	keep := func(id int) bool {
+		if len(opts.Components) == 0 {
+			return true
+		}
+		for _, c := range opts.Components {
+			if c == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, id := range opts.Components {
+		if j.componentIndex(id) < 0 {
+			return nil, fmt.Errorf("there is no component %d", id)
+		}
+	}
+
+	mcuCols := j.frameMCUCols()
+	mcus := mcuCols * j.frameMCURows()
+	sizes := j.planeSizes()
+
+	t := &tracer{blocks: make(map[*Component]map[int]*BlockTrace)}
+	traces := make([]*BlockTrace, 0)
+
+	add := func(ci int, row int, col int) {
+		c := j.Components[ci]
+		index := row*sizes[ci][0] + col
+
+		if t.blocks[c] == nil {
+			t.blocks[c] = make(map[int]*BlockTrace)
+		}
+
+		// Picked twice, once by MCU and once by position
+		if t.blocks[c][index] != nil {
+			return
+		}
+
+		bt := &BlockTrace{MCU: j.blockMCU(c, row, col), Component: c.Id, Row: row, Col: col, QuantizationTable: c.Tq}
+		t.blocks[c][index] = bt
+		traces = append(traces, bt)
+	}
+
+	for _, m := range opts.MCUs {
+		if m < 0 || m >= mcus {
+			return nil, fmt.Errorf("MCU %d is outside the %d MCUs of the frame", m, mcus)
+		}
+
+		mcuRow := m / mcuCols
+		mcuCol := m % mcuCols
+
+		for ci, c := range j.Components {
+			if !keep(c.Id) {
+				continue
+			}
+
+			if len(j.Components) == 1 {
+				add(ci, mcuRow, mcuCol)
+				continue
+			}
+
+			for v := 0; v < c.V; v++ {
+				for h := 0; h < c.H; h++ {
+					add(ci, mcuRow*c.V+v, mcuCol*c.H+h)
+				}
+			}
+		}
+	}
+
+	for _, b := range opts.Blocks {
+		ci := j.componentIndex(b.Component)
+		if ci < 0 {
+			return nil, fmt.Errorf("there is no component %d", b.Component)
+		}
+
+		if b.Row < 0 || b.Col < 0 || b.Col >= sizes[ci][0] || b.Row >= sizes[ci][1] {
+			return nil, fmt.Errorf("block %d,%d is outside the %dx%d blocks of component %d", b.Row, b.Col, sizes[ci][1], sizes[ci][0], b.Component)
+		}
+
+		add(ci, b.Row, b.Col)
+	}
+
+	j.tracer = t
+
+	return traces, nil
+}
+
+func (f *Frame) componentIndex(id int) int {
+	for i, c := range f.Components {
+		if c.Id == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// decodeBlock, taken apart to keep each symbol and the bits it came from
+func (j *JpegParser) traceBlock(interval *Interval, c *Component, previousDC int, t *BlockTrace) ([64]int, int) {
+	array := [64]int{}
+
+	dcReader := j.GetHuffmanReader(huffman.TARGET_DC, c.Td)
+	acReader := j.GetHuffmanReader(huffman.TARGET_AC, c.Ta)
+
+	r := &bitRecorder{provider: interval}
+
+	t.Prediction = previousDC
+	t.Symbols = make([]TraceSymbol, 0)
+
+	// Figure F.12
+	ssss := dcReader.Decode(r)
+	code := r.take()
+	diff := dcReader.ExtendVal(r.NextBits(ssss), ssss)
+
+	t.Symbols = append(t.Symbols, TraceSymbol{Table: "DC", Symbol: ssss, Code: code, Extra: r.take(), Value: diff, Index: 0})
+
+	array[0] = previousDC + diff
+
+	// Figure F.13, following DecodeACCoefficients step for step
+	k := 1
+	for {
+		rs := acReader.Decode(r)
+		code := r.take()
+
+		ssss := rs % 16
+		run := rs >> 4
+
+		if ssss == 0 {
+			t.Symbols = append(t.Symbols, TraceSymbol{Table: "AC", Symbol: rs, Code: code, Index: k})
+
+			if run == 15 {
+				k += 16
+				continue
+			}
+			break
+		}
+
+		k += run
+
+		if k > 63 {
+			panic("run of zeros goes past the end of the block")
+		}
+
+		array[k] = acReader.DecodeZZ(r, ssss)
+		t.Symbols = append(t.Symbols, TraceSymbol{Table: "AC", Symbol: rs, Code: code, Extra: r.take(), Value: array[k], Index: k})
+
+		if k == 63 {
+			break
+		}
+		k++
+	}
+
+	return array, array[0]
+}
+
+// Passes bits through from the interval, keeping a copy of them until they're taken
+type bitRecorder struct {
+	provider huffman.NextBitProvider
+	bits     strings.Builder
+}
+
+func (r *bitRecorder) NextBit() (byte, error) {
+	bit, err := r.provider.NextBit()
+	if err == nil {
+		r.bits.WriteByte('0' + bit)
+	}
+
+	return bit, err
+}
+
+func (r *bitRecorder) NextBits(numBits int) int {
+	ret := 0
+	for i := 0; i < numBits; i++ {
+		bit, _ := r.NextBit()
+		ret = ret<<1 | int(bit)
+	}
+
+	return ret
+}
+
+func (r *bitRecorder) PrintDebug() {
+	r.provider.PrintDebug()
+}
+
+// The bits read since the last take
+func (r *bitRecorder) take() string {
+	s := r.bits.String()
+	r.bits.Reset()
+
+	return s
+}
+
+func WriteTraceJSON(w io.Writer, traces []*BlockTrace) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(traces)
+}
+
+// One section per block, the stages one after the other
+func WriteTraceText(w io.Writer, traces []*BlockTrace) error {
+	var b strings.Builder
+
+	grid := func(title string, values [8][8]int) {
+		fmt.Fprintf(&b, "  %s\n", title)
+		for _, row := range values {
+			b.WriteString("   ")
+			for _, v := range row {
+				fmt.Fprintf(&b, " %5d", v)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	// Zig-zag order is a list, so it goes eight to a line
+	list := func(title string, values [64]int) {
+		var rows [8][8]int
+		for i, v := range values {
+			rows[i/8][i%8] = v
+		}
+		grid(title, rows)
+	}
+
+	for _, t := range traces {
+		fmt.Fprintf(&b, "MCU %d, component %d, block row %d col %d, quantization table %d\n", t.MCU, t.Component, t.Row, t.Col, t.QuantizationTable)
+
+		if len(t.Symbols) > 0 {
+			fmt.Fprintf(&b, "  Huffman symbols\n")
+			fmt.Fprintf(&b, "    %-5s %-4s %-18s %-12s %5s %6s\n", "table", "k", "code", "extra", "symbol", "value")
+			for _, s := range t.Symbols {
+				fmt.Fprintf(&b, "    %-5s %-4d %-18s %-12s  0x%02x %6d\n", s.Table, s.Index, s.Code, s.Extra, s.Symbol, s.Value)
+			}
+			fmt.Fprintf(&b, "  DC prediction %d, DC %d\n", t.Prediction, t.ZigZag[0])
+		}
+
+		list("Zig-zag coefficients", t.ZigZag)
+		list("Dequantized", t.Dequantized)
+		grid("De-zigzagged", t.Matrix)
+		grid("IDCT", t.IDCT)
+		grid("Samples", t.Samples)
+
+		if len(t.RGB) == 0 {
+			fmt.Fprintf(&b, "  RGB: outside the image\n")
+		} else {
+			fmt.Fprintf(&b, "  RGB at %d,%d\n", t.X, t.Y)
+			for _, row := range t.RGB {
+				b.WriteString("   ")
+				for _, p := range row {
+					fmt.Fprintf(&b, " %02x%02x%02x", p[0], p[1], p[2])
+				}
+				b.WriteString("\n")
+			}
+		}
+
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
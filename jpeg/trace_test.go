@@ -0,0 +1,88 @@
+package jpeg
+
+import (
+	"math"
+	"testing"
+)
+
+// One block's trace, checked stage by stage against the coefficients the decoder reads, the IDCT of A.3.3 worked
+// out directly and the pixels Decode gives
+func TestTraceBlock(t *testing.T) {
+	b := testEncode(t, testImage(40, 32, true, 11), &EncodeOptions{Quality: 80})
+	row, col := 2, 3
+
+	j, err := NewJpegParserFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	traces, err := j.Trace(&TraceOptions{Blocks: []BlockPosition{{Component: 1, Row: row, Col: col}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 1 {
+		t.Fatalf("got %d traces", len(traces))
+	}
+	tr := traces[0]
+
+	c := testCoefficients(t, b)
+	if tr.ZigZag != c.Planes[0].Block(row, col) {
+		t.Errorf("traced coefficients %v, the decoder has %v", tr.ZigZag, c.Planes[0].Block(row, col))
+	}
+
+	// The DC is the prediction plus the difference read first
+	if len(tr.Symbols) == 0 || tr.Symbols[0].Table != "DC" || tr.Prediction+tr.Symbols[0].Value != tr.ZigZag[0] {
+		t.Errorf("DC of %d from a prediction of %d and %+v", tr.ZigZag[0], tr.Prediction, tr.Symbols)
+	}
+
+	q := c.QuantizationTables[tr.QuantizationTable]
+	for k := range tr.ZigZag {
+		if tr.Dequantized[k] != tr.ZigZag[k]*q[k] {
+			t.Fatalf("dequantized %v from %v and %v", tr.Dequantized, tr.ZigZag, q)
+		}
+	}
+
+	natural := naturalOrder(tr.Dequantized)
+	for i, v := range natural {
+		if tr.Matrix[i/8][i%8] != v {
+			t.Fatalf("matrix %v from %v", tr.Matrix, tr.Dequantized)
+		}
+	}
+
+	cu := func(u int) float64 {
+		if u == 0 {
+			return 1 / math.Sqrt2
+		}
+		return 1
+	}
+
+	decoded := testDecode(t, b)
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			sum := 0.0
+			for v := 0; v < 8; v++ {
+				for u := 0; u < 8; u++ {
+					sum += cu(u) * cu(v) * float64(tr.Matrix[v][u]) * math.Cos(float64((2*x+1)*u)*math.Pi/16) * math.Cos(float64((2*y+1)*v)*math.Pi/16)
+				}
+			}
+
+			if math.Abs(float64(tr.IDCT[y][x])-sum/4) > 1 {
+				t.Errorf("IDCT at %d,%d is %d, A.3.3 gives %.2f", x, y, tr.IDCT[y][x], sum/4)
+			}
+
+			if want := maxInt(0, minInt(255, tr.IDCT[y][x]+128)); tr.Samples[y][x] != want {
+				t.Errorf("sample at %d,%d is %d, not %d", x, y, tr.Samples[y][x], want)
+			}
+
+			p := decoded.RGBAAt(col*8+x, row*8+y)
+			if int(p.R) != tr.Samples[y][x] || tr.RGB[y][x] != [3]uint8{p.R, p.G, p.B} {
+				t.Errorf("pixel at %d,%d is %v, the trace has sample %d and %v", x, y, p, tr.Samples[y][x], tr.RGB[y][x])
+			}
+		}
+	}
+
+	if tr.X != col*8 || tr.Y != row*8 || len(tr.RGB) != 8 || len(tr.RGB[0]) != 8 {
+		t.Errorf("block covers %dx%d pixels at %d,%d", len(tr.RGB[0]), len(tr.RGB), tr.X, tr.Y)
+	}
+}
@@ -0,0 +1,86 @@
+package jpeg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Rewrites a file with a different scan layout, baseline to progressive or back, and optionally optimized Huffman
+// tables. The quantized coefficients are carried over untouched so the pixels don't change, and so are the
+// metadata segments unless opts.DropMetadata is set
+func Transcode(w io.Writer, b []byte, opts *EncodeOptions) error {
+	j, err := NewJpegParserFromBytes(b)
+	if err != nil {
+		return err
+	}
+
+	c, err := j.DecodeCoefficients()
+	if err != nil {
+		return err
+	}
+
+	e, err := NewEncoderFromCoefficients(c, opts)
+	if err != nil {
+		return err
+	}
+
+	return e.Write(w)
+}
+
+// Decodes both files with this decoder and checks that the coefficients inside the image and the resulting
+// pixels are identical. Padding blocks past the edge aren't compared since progressive scans don't code them
+func VerifyLossless(original []byte, converted []byte) error {
+	ja, err := NewJpegParserFromBytes(original)
+	if err != nil {
+		return err
+	}
+
+	jb, err := NewJpegParserFromBytes(converted)
+	if err != nil {
+		return err
+	}
+
+	a, err := ja.DecodeCoefficients()
+	if err != nil {
+		return err
+	}
+
+	b, err := jb.DecodeCoefficients()
+	if err != nil {
+		return err
+	}
+
+	if a.XLines != b.XLines || a.YLines != b.YLines || len(a.Planes) != len(b.Planes) {
+		return errors.New("frames differ")
+	}
+
+	for ci, p := range a.Planes {
+		cols, rows := a.ComponentBlocks(a.Components[ci])
+
+		for row := 0; row < rows; row++ {
+			for col := 0; col < cols; col++ {
+				if p.Block(row, col) != b.Planes[ci].Block(row, col) {
+					return fmt.Errorf("component %d differs at block %d, %d", ci, row, col)
+				}
+			}
+		}
+	}
+
+	imgA, err := ja.Decode()
+	if err != nil {
+		return err
+	}
+
+	imgB, err := jb.Decode()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(imgA.Pix, imgB.Pix) {
+		return errors.New("pixels differ")
+	}
+
+	return nil
+}
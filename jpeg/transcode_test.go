@@ -0,0 +1,99 @@
+package jpeg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTranscodeIsLossless(t *testing.T) {
+	img := testImage(45, 29, false, 9)
+	baseline := testEncode(t, img, &EncodeOptions{Subsampling: SUBSAMPLING_420})
+	progressive := testEncode(t, img, &EncodeOptions{Subsampling: SUBSAMPLING_420, Progressive: true})
+
+	cases := []struct {
+		name string
+		from []byte
+		opts *EncodeOptions
+	}{
+		{"baseline to progressive", baseline, &EncodeOptions{Progressive: true}},
+		{"progressive to baseline", progressive, &EncodeOptions{}},
+		{"optimized tables", baseline, &EncodeOptions{OptimizeHuffman: true}},
+		{"restart markers", progressive, &EncodeOptions{RestartInterval: 3}},
+		{"progressive with restart markers", baseline, &EncodeOptions{Progressive: true, RestartInterval: 2}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Transcode(&buf, c.from, c.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := VerifyLossless(c.from, buf.Bytes()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestVerifyLosslessFindsDifferences(t *testing.T) {
+	img := testImage(45, 29, false, 9)
+	original := testEncode(t, img, &EncodeOptions{Quality: 80})
+
+	requantized, err := testCoefficients(t, original).Requantize(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		converted []byte
+	}{
+		{"another quality", testEncode(t, img, &EncodeOptions{Quality: 81})},
+		{"requantized", testWriteCoefficients(t, requantized)},
+		{"another size", testEncode(t, testImage(44, 29, false, 9), &EncodeOptions{Quality: 80})},
+		{"grayscale", testEncode(t, testImage(45, 29, true, 9), &EncodeOptions{Quality: 80})},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := VerifyLossless(original, c.converted); err == nil {
+				t.Error("no difference found")
+			}
+		})
+	}
+}
+
+func TestTranscodeKeepsMetadata(t *testing.T) {
+	b, segments := testFileWithMetadata(t)
+
+	cases := []struct {
+		name string
+		opts *EncodeOptions
+		want []*Section
+	}{
+		// The MPF index would point at images that aren't written, so it's the only one left behind
+		{"copied", &EncodeOptions{Progressive: true}, segments},
+		{"dropped", &EncodeOptions{DropMetadata: true}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Transcode(&buf, b, c.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			carried := testCarried(t, buf.Bytes())
+			if len(carried) != len(c.want) {
+				t.Fatalf("got %d segments", len(carried))
+			}
+
+			for i, s := range carried {
+				if s.Type != c.want[i].Type || !bytes.Equal(s.Body, c.want[i].Body) {
+					t.Errorf("segment %d is 0x%02x %q", i, s.Type, s.Body)
+				}
+			}
+		})
+	}
+}
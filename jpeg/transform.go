@@ -0,0 +1,255 @@
+package jpeg
+
+import (
+	"errors"
+	"image"
+
+	"huffman"
+)
+
+// Lossless transforms on quantized coefficients, the way jpegtran does them. Mirroring a block only changes the
+// sign of its odd frequencies and transposing it swaps u and v, so nothing is requantized. Mirroring an axis
+// drops a partial MCU at the far edge of it, since that MCU would have to move to the near edge where it
+// no longer lines up (jpegtran's -trim)
+
+const (
+	TRANSFORM_NONE       int = 0
+	TRANSFORM_FLIP_H     int = 1
+	TRANSFORM_FLIP_V     int = 2
+	TRANSFORM_TRANSPOSE  int = 3 // across the top left to bottom right diagonal
+	TRANSFORM_TRANSVERSE int = 4 // across the top right to bottom left diagonal
+	TRANSFORM_ROT_90     int = 5 // clockwise
+	TRANSFORM_ROT_180    int = 6
+	TRANSFORM_ROT_270    int = 7
+)
+
+// The EXIF Orientation of the result goes back to 1, since it described the pixels as they were stored before
+func (c *Coefficients) Transform(t int) (*Coefficients, error) {
+	out, err := c.transform(t)
+	if err != nil || out == c {
+		return out, err
+	}
+
+	out.Segments = resetOrientation(out.Segments)
+
+	return out, nil
+}
+
+func (c *Coefficients) transform(t int) (*Coefficients, error) {
+	switch t {
+	case TRANSFORM_NONE:
+		return c, nil
+	case TRANSFORM_FLIP_H:
+		return c.flipH()
+	case TRANSFORM_FLIP_V:
+		return c.flipV()
+	case TRANSFORM_TRANSPOSE:
+		return c.transpose(), nil
+	case TRANSFORM_TRANSVERSE:
+		return c.transpose().transform(TRANSFORM_ROT_180)
+	case TRANSFORM_ROT_90:
+		return c.transpose().flipH()
+	case TRANSFORM_ROT_180:
+		flipped, err := c.flipH()
+		if err != nil {
+			return nil, err
+		}
+		return flipped.flipV()
+	case TRANSFORM_ROT_270:
+		return c.transpose().flipV()
+	}
+
+	return nil, errors.New("unknown transform")
+}
+
+// Copies of the EXIF segments with Orientation set to 1. The source's segments are shared with its parser so
+// they're left alone
+func resetOrientation(segments []*Section) []*Section {
+	out := make([]*Section, len(segments))
+
+	for i, s := range segments {
+		out[i] = s
+
+		if s.Type != MARKER_EXIF || !IsExif(s.Body) {
+			continue
+		}
+
+		body := append([]byte{}, s.Body...)
+
+		// A segment that can't be read goes through as it is
+		e, err := NewExifEditor(body)
+		if err != nil {
+			continue
+		}
+
+		_, entry, err := e.FindTag(TAG_ORIENTATION)
+		if err != nil || entry == nil || entry.Type != TIFF_TYPE_SHORT {
+			continue
+		}
+
+		normal := make([]byte, 2)
+		e.Order.PutUint16(normal, 1)
+
+		if _, err := e.SetValue(TAG_ORIENTATION, normal); err != nil {
+			continue
+		}
+
+		out[i] = NewSection(s.Type, body)
+	}
+
+	return out
+}
+
+// Width and height of an MCU in pixels. A single component is coded one block at a time whatever its sampling
+// factors say
+func (c *Coefficients) mcuSize() (int, int) {
+	if len(c.Components) == 1 {
+		return 8, 8
+	}
+
+	hMax, vMax := c.MaxSampling()
+
+	return 8 * hMax, 8 * vMax
+}
+
+// Blocks of the component that hold image data across and down, scaled from the frame size
+func (f *Frame) dataBlocks(ci int) (int, int) {
+	if len(f.Components) == 1 {
+		return ceilDiv(f.XLines, 8), ceilDiv(f.YLines, 8)
+	}
+
+	return f.ComponentBlocks(f.Components[ci])
+}
+
+func (c *Coefficients) flipH() (*Coefficients, error) {
+	mcuWidth, _ := c.mcuSize()
+
+	frame := c.copyFrame()
+	frame.XLines = c.XLines / mcuWidth * mcuWidth
+
+	if frame.XLines == 0 {
+		return nil, errors.New("image is narrower than an MCU")
+	}
+
+	return c.remap(frame, copyTables(c.QuantizationTables), func(ci int, row int, col int) [64]int {
+		cols, _ := frame.dataBlocks(ci)
+
+		return mirrorBlock(c.blockOrZero(ci, row, cols-1-col), true, false)
+	}), nil
+}
+
+func (c *Coefficients) flipV() (*Coefficients, error) {
+	_, mcuHeight := c.mcuSize()
+
+	frame := c.copyFrame()
+	frame.YLines = c.YLines / mcuHeight * mcuHeight
+
+	if frame.YLines == 0 {
+		return nil, errors.New("image is shorter than an MCU")
+	}
+
+	return c.remap(frame, copyTables(c.QuantizationTables), func(ci int, row int, col int) [64]int {
+		_, rows := frame.dataBlocks(ci)
+
+		return mirrorBlock(c.blockOrZero(ci, rows-1-row, col), false, true)
+	}), nil
+}
+
+// Nothing moves relative to the top left corner so there's nothing to trim. The sampling factors and the
+// quantization tables are transposed along with the blocks
+func (c *Coefficients) transpose() *Coefficients {
+	frame := c.copyFrame()
+	frame.XLines, frame.YLines = c.YLines, c.XLines
+
+	for _, component := range frame.Components {
+		component.H, component.V = component.V, component.H
+	}
+
+	tables := make(map[int][64]int)
+	for id, table := range c.QuantizationTables {
+		tables[id] = transposeBlock(table)
+	}
+
+	return c.remap(frame, tables, func(ci int, row int, col int) [64]int {
+		return transposeBlock(c.blockOrZero(ci, col, row))
+	})
+}
+
+// Cuts out r. The top left corner moves up and left to the nearest MCU boundary so the blocks keep their
+// positions within the MCU grid, the same as jpegtran
+func (c *Coefficients) Crop(r image.Rectangle) (*Coefficients, error) {
+	r = r.Intersect(image.Rect(0, 0, c.XLines, c.YLines))
+
+	if r.Empty() {
+		return nil, errors.New("crop is outside the image")
+	}
+
+	mcuWidth, mcuHeight := c.mcuSize()
+
+	x0 := r.Min.X / mcuWidth * mcuWidth
+	y0 := r.Min.Y / mcuHeight * mcuHeight
+
+	frame := c.copyFrame()
+	frame.XLines = r.Max.X - x0
+	frame.YLines = r.Max.Y - y0
+
+	// Offsets in blocks for each component
+	hMax, vMax := c.MaxSampling()
+
+	return c.remap(frame, copyTables(c.QuantizationTables), func(ci int, row int, col int) [64]int {
+		component := c.Components[ci]
+
+		offsetCols := x0 / 8 * component.H / hMax
+		offsetRows := y0 / 8 * component.V / vMax
+
+		if len(c.Components) == 1 {
+			offsetCols, offsetRows = x0/8, y0/8
+		}
+
+		return c.blockOrZero(ci, row+offsetRows, col+offsetCols)
+	}), nil
+}
+
+// Keeps only the luma. The sampling factors no longer mean anything with a single component so they become 1x1
+func (c *Coefficients) Grayscale() *Coefficients {
+	if len(c.Components) == 1 {
+		return c
+	}
+
+	frame := c.copyFrame()
+	frame.Components = frame.Components[:1]
+	frame.Components[0].H, frame.Components[0].V = 1, 1
+
+	tq := c.Components[0].Tq
+	tables := map[int][64]int{tq: c.QuantizationTables[tq]}
+
+	return c.remap(frame, tables, func(ci int, row int, col int) [64]int {
+		return c.blockOrZero(0, row, col)
+	})
+}
+
+// Mirroring a block negates the coefficients with an odd horizontal (u) or vertical (v) frequency
+func mirrorBlock(zz [64]int, horizontal bool, vertical bool) [64]int {
+	for k, i := range huffman.ZigZagOrder {
+		u := i % 8
+		v := i / 8
+
+		if (horizontal && u%2 == 1) != (vertical && v%2 == 1) {
+			zz[k] = -zz[k]
+		}
+	}
+
+	return zz
+}
+
+// Swaps u and v. Works on quantization tables as well since they're kept in the same zig-zag order
+func transposeBlock(zz [64]int) [64]int {
+	natural := naturalOrder(zz)
+	transposed := [64]int{}
+
+	for i, value := range natural {
+		transposed[(i%8)*8+i/8] = value
+	}
+
+	return huffman.ZigZag(transposed)
+}
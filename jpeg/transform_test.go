@@ -0,0 +1,317 @@
+package jpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Smooth gradients, a diagonal ripple, a hard edge and some noise, so that both low and high frequencies end
+// up in the coefficients. The same seed gives the same image
+func testImage(width int, height int, gray bool, seed int64) image.Image {
+	r := rand.New(rand.NewSource(seed))
+
+	value := func(x int, y int, phase float64) uint8 {
+		v := 255 * float64(x+y) / float64(width+height)
+		v += 40 * math.Sin(float64(x)*0.7+float64(y)*0.3+phase)
+		if x > width/2 {
+			v = 255 - v
+		}
+		v += float64(r.Intn(25)) - 12
+
+		return uint8(math.Max(0, math.Min(255, v)))
+	}
+
+	if gray {
+		img := image.NewGray(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.SetGray(x, y, color.Gray{value(x, y, 0)})
+			}
+		}
+		return img
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{value(x, y, 0), value(y, x, 1), value(x/2, y, 2), 255})
+		}
+	}
+	return img
+}
+
+func testEncode(t *testing.T, img image.Image, opts *EncodeOptions) []byte {
+	var buf bytes.Buffer
+
+	if err := Encode(&buf, img, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func testDecode(t *testing.T, b []byte) *image.RGBA {
+	j, err := NewJpegParserFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := j.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return img
+}
+
+func testCoefficients(t *testing.T, b []byte) *Coefficients {
+	j, err := NewJpegParserFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := j.DecodeCoefficients()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func testWriteCoefficients(t *testing.T, c *Coefficients) []byte {
+	e, err := NewEncoderFromCoefficients(c, &EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := e.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// Where pixel x, y of a width x height image ends up
+var transformPixel = map[int]func(x, y, width, height int) (int, int){
+	TRANSFORM_FLIP_H:     func(x, y, w, h int) (int, int) { return w - 1 - x, y },
+	TRANSFORM_FLIP_V:     func(x, y, w, h int) (int, int) { return x, h - 1 - y },
+	TRANSFORM_TRANSPOSE:  func(x, y, w, h int) (int, int) { return y, x },
+	TRANSFORM_TRANSVERSE: func(x, y, w, h int) (int, int) { return h - 1 - y, w - 1 - x },
+	TRANSFORM_ROT_90:     func(x, y, w, h int) (int, int) { return h - 1 - y, x },
+	TRANSFORM_ROT_180:    func(x, y, w, h int) (int, int) { return w - 1 - x, h - 1 - y },
+	TRANSFORM_ROT_270:    func(x, y, w, h int) (int, int) { return y, w - 1 - x },
+}
+
+var transformInverse = map[int]int{
+	TRANSFORM_FLIP_H:     TRANSFORM_FLIP_H,
+	TRANSFORM_FLIP_V:     TRANSFORM_FLIP_V,
+	TRANSFORM_TRANSPOSE:  TRANSFORM_TRANSPOSE,
+	TRANSFORM_TRANSVERSE: TRANSFORM_TRANSVERSE,
+	TRANSFORM_ROT_90:     TRANSFORM_ROT_270,
+	TRANSFORM_ROT_180:    TRANSFORM_ROT_180,
+	TRANSFORM_ROT_270:    TRANSFORM_ROT_90,
+}
+
+// Whole MCUs, so nothing gets trimmed. Every transform has to move each decoded pixel to where it belongs, and
+// undoing it has to give back the very same coefficients
+func TestTransforms(t *testing.T) {
+	layouts := []struct {
+		name        string
+		gray        bool
+		subsampling int
+	}{
+		{"gray", true, SUBSAMPLING_444},
+		{"444", false, SUBSAMPLING_444},
+		{"422", false, SUBSAMPLING_422},
+		{"420", false, SUBSAMPLING_420},
+	}
+
+	for _, layout := range layouts {
+		original := testEncode(t, testImage(48, 32, layout.gray, 5), &EncodeOptions{Subsampling: layout.subsampling})
+		c := testCoefficients(t, original)
+		before := testDecode(t, original)
+
+		for transform := TRANSFORM_FLIP_H; transform <= TRANSFORM_ROT_270; transform++ {
+			t.Run(fmt.Sprintf("%s/transform%d", layout.name, transform), func(t *testing.T) {
+				transformed, err := c.Transform(transform)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				after := testDecode(t, testWriteCoefficients(t, transformed))
+
+				width, height := before.Bounds().Dx(), before.Bounds().Dy()
+				if transform >= TRANSFORM_TRANSPOSE && transform != TRANSFORM_ROT_180 {
+					width, height = height, width
+				}
+				if after.Bounds() != image.Rect(0, 0, width, height) {
+					t.Fatalf("got %v", after.Bounds())
+				}
+
+				worst := 0
+				for y := 0; y < before.Bounds().Dy(); y++ {
+					for x := 0; x < before.Bounds().Dx(); x++ {
+						tx, ty := transformPixel[transform](x, y, before.Bounds().Dx(), before.Bounds().Dy())
+						p, q := before.RGBAAt(x, y), after.RGBAAt(tx, ty)
+
+						for _, d := range []int{int(p.R) - int(q.R), int(p.G) - int(q.G), int(p.B) - int(q.B)} {
+							if d < 0 {
+								d = -d
+							}
+							if d > worst {
+								worst = d
+							}
+						}
+					}
+				}
+
+				if worst > 0 {
+					t.Errorf("pixels off by up to %d", worst)
+				}
+
+				undone, err := transformed.Transform(transformInverse[transform])
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if undone.XLines != c.XLines || undone.YLines != c.YLines {
+					t.Fatalf("undone to %dx%d", undone.XLines, undone.YLines)
+				}
+
+				for ci, p := range c.Planes {
+					if undone.Components[ci].H != p.Component.H || undone.Components[ci].V != p.Component.V {
+						t.Errorf("component %d sampling %dx%d", ci, undone.Components[ci].H, undone.Components[ci].V)
+					}
+
+					for i, block := range p.Blocks {
+						if undone.Planes[ci].Blocks[i] != block {
+							t.Fatalf("component %d block %d differs once undone", ci, i)
+						}
+					}
+				}
+
+				for id, table := range c.QuantizationTables {
+					if undone.QuantizationTables[id] != table {
+						t.Errorf("quantization table %d differs once undone", id)
+					}
+				}
+			})
+		}
+	}
+}
+
+// An encoder built file with EXIF holding Orientation 6, an ICC profile, an Adobe segment, a comment and an MPF
+// index after its JFIF segment
+func testFileWithMetadata(t *testing.T) ([]byte, []*Section) {
+	orientation := make([]byte, 2)
+	binary.BigEndian.PutUint16(orientation, 6)
+
+	segments := []*Section{
+		NewSection(MARKER_EXIF, buildExif(binary.BigEndian, [][]testIfdEntry{{
+			{tag: TAG_ORIENTATION, typ: TIFF_TYPE_SHORT, count: 1, value: orientation},
+		}})),
+		NewSection(MARKER_APP2, append(append([]byte{}, iccIdentifier...), 1, 1, 'p', 'r', 'o', 'f')),
+		NewSection(MARKER_APP14, append(append([]byte{}, adobeIdentifier...), 0, 100, 0, 0, 0, 0, 1)),
+		NewSection(MARKER_COM, []byte("a comment")),
+	}
+
+	b := testEncode(t, testImage(48, 32, false, 4), &EncodeOptions{Subsampling: SUBSAMPLING_420})
+	jfifEnd := 4 + (int(b[4])<<8 | int(b[5]))
+
+	var withMetadata []byte
+	withMetadata = append(withMetadata, b[:jfifEnd]...)
+	for _, s := range segments {
+		withMetadata = append(withMetadata, testSegment(s.Type, s.Body)...)
+	}
+	withMetadata = append(withMetadata, testSegment(MARKER_APP2, append(append([]byte{}, mpfIdentifier...), buildTiff(binary.BigEndian, [][]testIfdEntry{{}})...))...)
+	withMetadata = append(withMetadata, b[jfifEnd:]...)
+
+	return withMetadata, segments
+}
+
+// The APPn and COM segments after the encoder's JFIF one
+func testCarried(t *testing.T, b []byte) []*Section {
+	segments, err := ReadHeaderSegments(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if segments[0].Type != MARKER_JFIF || !bytes.HasPrefix(segments[0].Body, jfifIdentifier) {
+		t.Fatalf("starts with 0x%02x", segments[0].Type)
+	}
+
+	carried := make([]*Section, 0)
+	for _, s := range segments[1:] {
+		if s.IsApp() || s.Type == MARKER_COM {
+			carried = append(carried, s)
+		}
+	}
+
+	return carried
+}
+
+// A transform changes the stored pixels, so the Orientation that described them goes back to 1. The source file's
+// own segment is left as it was
+func TestTransformResetsOrientation(t *testing.T) {
+	b, segments := testFileWithMetadata(t)
+	c := testCoefficients(t, b)
+
+	orientation := func(body []byte) int {
+		e, err := NewExifEditor(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, entry, err := e.FindTag(TAG_ORIENTATION)
+		if err != nil || entry == nil {
+			t.Fatalf("no orientation, %v", err)
+		}
+
+		v, err := e.Uint(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return v
+	}
+
+	cases := []struct {
+		transform int
+		want      int
+	}{
+		{TRANSFORM_NONE, 6},
+		{TRANSFORM_ROT_90, 1},
+		{TRANSFORM_TRANSVERSE, 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("transform%d", tc.transform), func(t *testing.T) {
+			transformed, err := c.Transform(tc.transform)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			carried := testCarried(t, testWriteCoefficients(t, transformed))
+			if got := orientation(carried[0].Body); got != tc.want {
+				t.Errorf("orientation %d", got)
+			}
+
+			for i := 1; i < len(segments); i++ {
+				if !bytes.Equal(carried[i].Body, segments[i].Body) {
+					t.Errorf("segment %d changed", i)
+				}
+			}
+
+			if got := orientation(c.Segments[0].Body); got != 6 {
+				t.Errorf("the source's orientation became %d", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package jpeg
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// XMP in JPEG is described in the XMP spec part 3, section 1.1.3. The standard packet sits in one APP1 segment.
+// Anything that doesn't fit in 64k goes in Extended XMP segments, each holding a chunk of a second packet along with
+// the MD5 of the whole second packet (the GUID), its total length and the chunk's offset
+
+var (
+	xmpIdentifier         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedIdentifier = []byte("http://ns.adobe.com/xmp/extension/\x00")
+
+	// The standard packet names the extended packet that goes with it. It can be an attribute, in either kind of
+	// quotes, or an element
+	hasExtendedXMP = regexp.MustCompile(`HasExtendedXMP(?:=["']|>)([0-9A-Fa-f]{32})`)
+)
+
+const xmpGUIDLength = 32
+
+type Xmp struct {
+	// The standard packet
+	Packet []byte
+	// The reassembled extended packet. nil if the file doesn't have one
+	Extended     []byte
+	ExtendedGUID string
+}
+
+func IsXmp(body []byte) bool {
+	return bytes.HasPrefix(body, xmpIdentifier)
+}
+
+func IsExtendedXmp(body []byte) bool {
+	return bytes.HasPrefix(body, xmpExtendedIdentifier)
+}
+
+// One chunk of the extended packet
+type xmpChunk struct {
+	guid   string
+	length int
+	offset int
+	data   []byte
+}
+
+func parseXmpChunk(body []byte) (*xmpChunk, error) {
+	offset := len(xmpExtendedIdentifier)
+
+	if len(body) < offset+xmpGUIDLength+8 {
+		return nil, errors.New("extended XMP segment too short")
+	}
+
+	c := &xmpChunk{guid: strings.ToUpper(string(body[offset : offset+xmpGUIDLength]))}
+	offset += xmpGUIDLength
+
+	c.length = int(binary.BigEndian.Uint32(body[offset:]))
+	c.offset = int(binary.BigEndian.Uint32(body[offset+4:]))
+	c.data = body[offset+8:]
+
+	return c, nil
+}
+
+// Finds the XMP packet before the scan without parsing the frame. Returns nil if there isn't one
+func ReadXMP(b []byte) (*Xmp, error) {
+	segments, err := ReadHeaderSegments(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return xmpFromSegments(segments)
+}
+
+func (j *JpegParser) XMP() (*Xmp, error) {
+	return xmpFromSegments(j.Segments)
+}
+
+func xmpFromSegments(segments []*Section) (*Xmp, error) {
+	var x *Xmp
+	chunks := make([]*xmpChunk, 0)
+
+	for _, s := range segments {
+		if s.Type != MARKER_EXIF {
+			continue
+		}
+
+		if IsXmp(s.Body) {
+			if x != nil {
+				return nil, errors.New("more than one standard XMP packet")
+			}
+			x = &Xmp{Packet: s.Body[len(xmpIdentifier):]}
+		} else if IsExtendedXmp(s.Body) {
+			c, err := parseXmpChunk(s.Body)
+			if err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, c)
+		}
+	}
+
+	if x == nil {
+		return nil, nil
+	}
+
+	// Extended chunks only count if the standard packet points at them. Anything else is left over from an edit
+	match := hasExtendedXMP.FindSubmatch(x.Packet)
+	if match == nil {
+		return x, nil
+	}
+
+	guid := strings.ToUpper(string(match[1]))
+
+	extended, err := reassembleExtendedXmp(guid, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	x.Extended = extended
+	x.ExtendedGUID = guid
+
+	return x, nil
+}
+
+// Chunks can arrive in any order, so place each by its offset and then check every byte was covered and the MD5
+// matches
+func reassembleExtendedXmp(guid string, chunks []*xmpChunk) ([]byte, error) {
+	var out []byte
+	var covered []bool
+
+	// The length comes straight from the file so make sure the chunks could fill it before allocating
+	available := 0
+	for _, c := range chunks {
+		if c.guid == guid {
+			available += len(c.data)
+		}
+	}
+
+	for _, c := range chunks {
+		if c.guid != guid {
+			continue
+		}
+
+		if out == nil {
+			if c.length > available {
+				return nil, errors.New("extended XMP has missing chunks")
+			}
+			out = make([]byte, c.length)
+			covered = make([]bool, c.length)
+		}
+
+		if c.length != len(out) {
+			return nil, errors.New("extended XMP chunks disagree on the full length")
+		}
+
+		if c.offset+len(c.data) > len(out) {
+			return nil, errors.New("extended XMP chunk runs past the full length")
+		}
+
+		copy(out[c.offset:], c.data)
+
+		for i := c.offset; i < c.offset+len(c.data); i++ {
+			covered[i] = true
+		}
+	}
+
+	if out == nil {
+		return nil, fmt.Errorf("extended XMP %s is referenced but missing", guid)
+	}
+
+	for _, c := range covered {
+		if !c {
+			return nil, errors.New("extended XMP has missing chunks")
+		}
+	}
+
+	sum := md5.Sum(out)
+
+	if strings.ToUpper(hex.EncodeToString(sum[:])) != guid {
+		return nil, errors.New("extended XMP MD5 doesn't match its GUID")
+	}
+
+	return out, nil
+}
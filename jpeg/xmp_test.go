@@ -0,0 +1,89 @@
+package jpeg
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func xmpSegment(packet string) *Section {
+	return NewSection(MARKER_EXIF, append(append([]byte{}, xmpIdentifier...), packet...))
+}
+
+func xmpChunkSegment(guid string, length int, offset int, data []byte) *Section {
+	body := append(append([]byte{}, xmpExtendedIdentifier...), guid...)
+	body = binary.BigEndian.AppendUint32(body, uint32(length))
+	body = binary.BigEndian.AppendUint32(body, uint32(offset))
+
+	return NewSection(MARKER_EXIF, append(body, data...))
+}
+
+func TestExtendedXmp(t *testing.T) {
+	extended := []byte(strings.Repeat("<rdf:Description>extended</rdf:Description>", 40))
+	sum := md5.Sum(extended)
+	guid := strings.ToUpper(hex.EncodeToString(sum[:]))
+	wrong := strings.Repeat("0", xmpGUIDLength)
+
+	// Three chunks, the last one short
+	chunk := func(guid string, i int) *Section {
+		end := (i + 1) * 700
+		if end > len(extended) {
+			end = len(extended)
+		}
+		return xmpChunkSegment(guid, len(extended), i*700, extended[i*700:end])
+	}
+
+	cases := []struct {
+		name     string
+		segments []*Section
+		// "" when the packet should come back whole
+		err string
+	}{
+		{"attribute", []*Section{xmpSegment(`xmpNote:HasExtendedXMP="` + guid + `"`), chunk(guid, 0), chunk(guid, 1), chunk(guid, 2)}, ""},
+		{"single quoted attribute", []*Section{xmpSegment(`xmpNote:HasExtendedXMP='` + guid + `'`), chunk(guid, 0), chunk(guid, 1), chunk(guid, 2)}, ""},
+		{"element", []*Section{xmpSegment(`<xmpNote:HasExtendedXMP>` + guid + `</xmpNote:HasExtendedXMP>`), chunk(guid, 0), chunk(guid, 1), chunk(guid, 2)}, ""},
+		{"lower case GUID", []*Section{xmpSegment(`xmpNote:HasExtendedXMP="` + strings.ToLower(guid) + `"`), chunk(guid, 0), chunk(guid, 1), chunk(guid, 2)}, ""},
+		{"out of order", []*Section{chunk(guid, 2), xmpSegment(`xmpNote:HasExtendedXMP="` + guid + `"`), chunk(guid, 0), chunk(guid, 1)}, ""},
+		{"leftover chunks from an edit", []*Section{xmpSegment(`xmpNote:HasExtendedXMP="` + guid + `"`), chunk(wrong, 0), chunk(guid, 0), chunk(guid, 1), chunk(guid, 2)}, ""},
+		{"missing chunk", []*Section{xmpSegment(`xmpNote:HasExtendedXMP="` + guid + `"`), chunk(guid, 0), chunk(guid, 2)}, "missing chunks"},
+		{"wrong MD5", []*Section{xmpSegment(`xmpNote:HasExtendedXMP="` + wrong + `"`), chunk(wrong, 0), chunk(wrong, 1), chunk(wrong, 2)}, "MD5"},
+		{"no chunks", []*Section{xmpSegment(`xmpNote:HasExtendedXMP="` + guid + `"`)}, "missing"},
+		{"chunk past the end", []*Section{xmpSegment(`xmpNote:HasExtendedXMP="` + guid + `"`), chunk(guid, 0), chunk(guid, 1), chunk(guid, 2), xmpChunkSegment(guid, len(extended), len(extended)-1, []byte("xx"))}, "runs past"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			x, err := xmpFromSegments(c.segments)
+
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("got %v, want an error with %q", err, c.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(x.Extended, extended) || x.ExtendedGUID != guid {
+				t.Errorf("got %d bytes for %s", len(x.Extended), x.ExtendedGUID)
+			}
+		})
+	}
+}
+
+// Extended chunks nobody points at are left alone
+func TestXmpWithoutExtended(t *testing.T) {
+	x, err := xmpFromSegments([]*Section{xmpSegment("<x:xmpmeta/>"), xmpChunkSegment(fmt.Sprintf("%032d", 1), 4, 0, []byte("abcd"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(x.Packet) != "<x:xmpmeta/>" || x.Extended != nil {
+		t.Errorf("got %q, %q", x.Packet, x.Extended)
+	}
+}
@@ -1,179 +1,572 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	// below for writing outputs
 	"image"
-	"image/color"
 	golangPng "image/png"
+	"io/ioutil"
 	"os"
+	"strings"
 
 	// Mine - extracted from their own projects
-	"dct"
-	"huffman"
 	"jpeg"
 )
 
-func fileDecodeRead(jpegReader *jpeg.JpegParser, interval *jpeg.Interval, stringIdentifier string, previousDC int) ([64]int, int) {
+// Parses a file, panicking on anything that stops the parse like the parser itself does on malformed data
+func parseFile(name string, limits *jpeg.DecodeLimits) *jpeg.JpegParser {
+	rawBytes, err := ioutil.ReadFile(name)
+	if err != nil {
+		panic(err)
+	}
 
-	identifier := 0 // luma
-	if stringIdentifier == "chroma" {
-		identifier = 1
+	j, err := jpeg.NewJpegParserWithLimits(rawBytes, limits)
+	if err != nil {
+		panic(err)
 	}
 
-	array := [64]int{}
+	return j
+}
 
-	dcReader := jpegReader.GetHuffmanReader(huffman.TARGET_DC, identifier)
-	acReader := jpegReader.GetHuffmanReader(huffman.TARGET_AC, identifier)
+func doFileDecode(desiredFile *string, opts *jpeg.DecodeOptions) image.Image {
+	j := parseFile(*desiredFile, &opts.Limits)
 
-	dcToReturn := dcReader.DecodeDC(interval, previousDC)
+	fmt.Printf("Input XLines: %d, YLines: %d\n", j.XLines, j.YLines)
 
-	array[0] = dcToReturn
+	if j.JFIF != nil {
+		fmt.Printf("JFIF %d.%02d, units: %d, density: %dx%d\n", j.JFIF.MajorVersion, j.JFIF.MinorVersion, j.JFIF.Units, j.JFIF.XDensity, j.JFIF.YDensity)
+	}
+	if j.JFIFError != nil {
+		fmt.Printf("Warning: %v\n", j.JFIFError)
+	}
 
-	zigZag := acReader.DecodeACCoefficients(interval)
+	fmt.Printf("Restart length is %d\n", j.RestartInterval)
 
-	for i := 1; i < 64; i++ {
-		array[i] = zigZag[i]
+	img, err := j.DecodeWithOptions(opts)
+	// Damaged and truncated files still come back with an image
+	if img != nil && err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	} else if err != nil {
+		panic(err)
 	}
 
-	// Now Dequantize and recenter
-
-	table := jpegReader.QuantizationTables[identifier]
+	return img
+}
 
-	for i := 0; i < 64; i++ {
-		array[i] *= table[i]
+// doFileDecode, with the diagnostic overlay as well
+func doOverlayDecode(desiredFile *string, opts *jpeg.DecodeOptions) (image.Image, image.Image) {
+	img, overlay, err := parseFile(*desiredFile, &opts.Limits).DecodeWithOverlay(opts)
+	if img != nil && err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	} else if err != nil {
+		panic(err)
 	}
 
-	// Now de-zig-zag
+	return img, overlay
+}
+
+// Pulls out the embedded previews without decoding the main image
+func doThumbnailExtract(desiredFile *string) {
+	rawBytes, err := ioutil.ReadFile(*desiredFile)
+	if err != nil {
+		panic(err)
+	}
 
-	straightened := acReader.DeZigZag(array)
-	for i := 1; i < 64; i++ {
-		array[i] = straightened[i]
+	thumbnails, err := jpeg.ReadThumbnails(rawBytes)
+	if thumbnails != nil && err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	} else if err != nil {
+		panic(err)
 	}
 
-	dctTransformer := dct.NewTransformer()
+	fmt.Printf("Found %d thumbnails\n", len(thumbnails))
 
-	array = dctTransformer.ArrayToArrayIDCT(array)
+	for i, t := range thumbnails {
+		img, err := t.Image()
+		if err != nil {
+			fmt.Printf("thumbnail %d from %s: %v\n", i, t.Source, err)
+			continue
+		}
 
-	// Recenter and clamp
+		name := fmt.Sprintf("/tmp/thumb_%d.png", i)
+		fmt.Printf("thumbnail %d from %s is %dx%d, writing %s\n", i, t.Source, t.Width, t.Height, name)
+		writeAsPngUsingGolangEncoder(img, name)
+	}
+}
 
-	for i, _ := range array {
-		array[i] += 128
+func doXmpExtract(desiredFile *string) {
+	rawBytes, err := ioutil.ReadFile(*desiredFile)
+	if err != nil {
+		panic(err)
+	}
+
+	x, err := jpeg.ReadXMP(rawBytes)
+	if err != nil {
+		panic(err)
 	}
 
-	for i, _ := range array {
-		array[i] = intClamp(array[i])
+	if x == nil {
+		fmt.Println("No XMP found")
+		return
 	}
 
-	return array, dcToReturn
+	fmt.Println(string(x.Packet))
 
+	if x.Extended != nil {
+		fmt.Printf("Extended XMP %s, %d bytes\n", x.ExtendedGUID, len(x.Extended))
+		fmt.Println(string(x.Extended))
+	}
 }
 
-func intClamp(val int) int {
-	if val > 255 {
-		val = 255
-	} else if val < 0 {
-		val = 0
+// Every image in a Multi-Picture file decoded on its own
+func doMpfDecode(desiredFile *string) {
+	rawBytes, err := ioutil.ReadFile(*desiredFile)
+	if err != nil {
+		panic(err)
+	}
+
+	images, err := jpeg.ReadMPF(rawBytes)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Found %d MP images\n", len(images))
+
+	for _, m := range images {
+		fmt.Printf("image %d: %s, offset: %d, size: %d\n", m.Index, m.TypeName(), m.Offset, m.Size)
+
+		j, err := m.Parser()
+		if err != nil {
+			fmt.Printf("image %d: %v\n", m.Index, err)
+			continue
+		}
+
+		img, err := j.Decode()
+		if err != nil {
+			fmt.Printf("image %d: %v\n", m.Index, err)
+			continue
+		}
+
+		writeAsPngUsingGolangEncoder(img, fmt.Sprintf("/tmp/mpf_%d.png", m.Index))
 	}
-	return val
 }
 
-func decodeInterval(j *jpeg.JpegParser, interval *jpeg.Interval, clrImg *image.RGBA) {
-	lumas := [4][64]int{}
+// Applies the gain map of an Ultra HDR file and writes the linear result as a float PFM
+func doHdrDecode(desiredFile *string, displayBoost float64) {
+	rawBytes, err := ioutil.ReadFile(*desiredFile)
+	if err != nil {
+		panic(err)
+	}
 
-	cbArray := [64]int{}
-	crArray := [64]int{}
+	u, err := jpeg.ReadUltraHdr(rawBytes)
+	if err != nil {
+		panic(err)
+	}
 
-	// Always zero at start of an interval
-	lumaDC := 0
-	cbDC := 0
-	crDC := 0
+	if u == nil {
+		fmt.Println("No gain map found")
+		return
+	}
 
-	for i := 0; i < interval.MCUs; i++ {
-		// Used below but also for debugging
-		thisMCU := interval.MCUOffset + i
+	fmt.Printf("Gain map %s, max: %v, capacity: %v to %v\n", u.Metadata.Version, u.Metadata.GainMapMax, u.Metadata.HDRCapacityMin, u.Metadata.HDRCapacityMax)
 
-		lumas[0], lumaDC = fileDecodeRead(j, interval, "luma", lumaDC)
-		lumas[1], lumaDC = fileDecodeRead(j, interval, "luma", lumaDC)
-		lumas[2], lumaDC = fileDecodeRead(j, interval, "luma", lumaDC)
-		lumas[3], lumaDC = fileDecodeRead(j, interval, "luma", lumaDC)
+	hdr, err := u.DecodeHDR(displayBoost)
+	if err != nil {
+		panic(err)
+	}
 
-		cbArray, cbDC = fileDecodeRead(j, interval, "chroma", cbDC)
-		crArray, crDC = fileDecodeRead(j, interval, "chroma", crDC)
+	f, err := os.Create("/tmp/out.pfm")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
 
-		// Need to rebuild col and row here
+	if err := hdr.WritePFM(f); err != nil {
+		panic(err)
+	}
+}
 
-		c := thisMCU % j.MCUCols()
-		r := thisMCU / j.MCUCols()
-		//fmt.Printf("sending in c: %d, r: %d for thisMCU: %d, intervalOffset: %d\n", c, r, thisMCU, interval.MCUOffset)
+// Decodes with this decoder and then encodes again with this encoder
+func doFileEncode(desiredFile *string, outFile string, opts *jpeg.EncodeOptions) {
+	img := doFileDecode(desiredFile, &jpeg.DecodeOptions{})
 
-		yCbCrArraysToImage(lumas, cbArray, crArray, clrImg, c*16, r*16)
+	f, err := os.Create(outFile)
+	if err != nil {
+		panic(err)
 	}
+	defer f.Close()
 
+	if err := jpeg.Encode(f, img, opts); err != nil {
+		panic(err)
+	}
 }
-func doFileDecode(desiredFile *string) image.Image {
-	j := jpeg.NewJpegParser(*desiredFile)
 
-	outImgX := j.XLines + (j.XLines % 16) // No op or plus 8
-	outImgY := j.YLines + (j.YLines % 16) // No op or plus 8
+// Rewrites the quantized coefficients without going through pixels, after an optional transform, crop, grayscale
+// conversion and requantization. Only opts' restart, Huffman and progressive settings apply
+func doTranscode(desiredFile *string, outFile string, transform int, crop image.Rectangle, gray bool, requantize int, opts *jpeg.EncodeOptions) {
+	j := parseFile(*desiredFile, &jpeg.DefaultDecodeLimits)
 
-	fmt.Printf("Input XLines: %d, YLines: %d\n", j.XLines, j.YLines)
+	c, err := j.DecodeCoefficients()
+	if err != nil {
+		panic(err)
+	}
 
-	var colorImg *image.RGBA
-	colorImg = image.NewRGBA(image.Rect(0, 0, outImgX, outImgY))
-	colorImg.Stride = outImgX * 4 // 4 bytes per pixels (rgba8)
+	c, err = c.Transform(transform)
+	if err != nil {
+		panic(err)
+	}
 
-	fmt.Printf("Restart length is %d\n", j.RestartInterval)
+	if !crop.Empty() {
+		c, err = c.Crop(crop)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if gray {
+		c = c.Grayscale()
+	}
+
+	if requantize > 0 {
+		c, err = c.Requantize(requantize)
+		if err != nil {
+			panic(err)
+		}
+	}
 
-	for _, interval := range j.Intervals {
-		fmt.Println("---------------Starting new interval---------------")
-		decodeInterval(j, interval, colorImg)
+	e, err := jpeg.NewEncoderFromCoefficients(c, opts)
+	if err != nil {
+		panic(err)
+	}
+
+	f, err := os.Create(outFile)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	if err := e.Write(f); err != nil {
+		panic(err)
 	}
 
-	return colorImg
+	fmt.Printf("Wrote %dx%d to %s\n", c.XLines, c.YLines, outFile)
+
+	// Only comparable when the geometry didn't change
+	if requantize > 0 && transform == jpeg.TRANSFORM_NONE && crop.Empty() && !gray {
+		original, err := j.Decode()
+		if err != nil {
+			panic(err)
+		}
+
+		requantized, err := parseFile(outFile, &jpeg.DefaultDecodeLimits).Decode()
+		if err != nil {
+			panic(err)
+		}
+
+		psnr, err := jpeg.PSNR(original, requantized)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("PSNR against the original decode: %.2f dB\n", psnr)
+	}
 }
 
-func yCbCrArraysToImage(lumas [4][64]int, cbArray [64]int, crArray [64]int, clrImg *image.RGBA, xOffset int, yOffset int) {
+// Checks a plain transcode decodes to exactly what the original does
+func doVerify(desiredFile *string, outFile string) {
+	original, err := ioutil.ReadFile(*desiredFile)
+	if err != nil {
+		panic(err)
+	}
 
-	for lumaI, lumaE := range lumas {
-		for row := 0; row < 8; row++ {
-			for col := 0; col < 8; col++ {
+	converted, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		panic(err)
+	}
 
-				lumaIndex := row*8 + col
-				// This is important. It operates in a 1:2 in each dimension basis to take into chroma sub-sampling account
-				chromaIndex := (row/2)*8 + col/2
-				//fmt.Printf("chromaIndex: %d\n", chromaIndex)
+	if err := jpeg.VerifyLossless(original, converted); err != nil {
+		panic(err)
+	}
 
-				intraBlockXOffset := 0
-				if lumaI == 1 || lumaI == 3 {
-					intraBlockXOffset = 8
-					chromaIndex += 4 // right side
-				}
+	fmt.Printf("%s decodes identically to %s, %d bytes before and %d after\n", outFile, *desiredFile, len(original), len(converted))
+}
+
+// Edits the metadata and copies the entropy coded data through untouched
+func doRewrite(desiredFile *string, outFile string, strip bool, noGps bool, noSerial bool) {
+	rawBytes, err := ioutil.ReadFile(*desiredFile)
+	if err != nil {
+		panic(err)
+	}
 
-				intraBlockYOffset := 0
-				if lumaI == 2 || lumaI == 3 {
-					intraBlockYOffset = 8
-					chromaIndex += 4 * 8 // bottom half
+	l, err := jpeg.ReadSegmentList(rawBytes)
+	if err != nil {
+		panic(err)
+	}
+
+	if noGps || noSerial {
+		err := l.EditExif(func(e *jpeg.ExifEditor) error {
+			if noGps {
+				removed, err := e.ZeroGPS()
+				if err != nil {
+					return err
 				}
+				fmt.Printf("GPS removed: %v\n", removed)
+			}
+
+			if noSerial {
+				return e.ZeroSerialNumbers()
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			fmt.Printf("EXIF not edited: %v\n", err)
+		}
+	}
+
+	if noGps {
+		fmt.Printf("XMP GPS properties blanked: %d\n", l.BlankXmp(jpeg.XmpGPSProperties))
+	}
+
+	if noSerial {
+		fmt.Printf("XMP serial number properties blanked: %d\n", l.BlankXmp(jpeg.XmpSerialProperties))
+	}
+
+	if strip {
+		fmt.Printf("Stripped %d segments\n", l.StripMetadata(true))
+	}
+
+	out, err := l.Bytes()
+	if err != nil {
+		panic(err)
+	}
+
+	if err := ioutil.WriteFile(outFile, out, 0644); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Wrote %s, %d bytes before and %d after\n", outFile, len(rawBytes), len(out))
+}
+
+// What quality the file was most likely saved at, from its quantization tables alone
+func doQualityEstimate(desiredFile *string) {
+	j := parseFile(*desiredFile, &jpeg.DefaultDecodeLimits)
+
+	e := j.EstimateQuality()
+
+	if e.Family == jpeg.QUALITY_FAMILY_PHOTOSHOP {
+		fmt.Printf("%s quality %d, nearest IJG quality %d\n", e.Family, e.Level, e.Quality)
+		return
+	}
+
+	fmt.Printf("Quality %d (%s), confidence %.2f, difference from IJG tables %d\n", e.Quality, e.Family, e.Confidence, e.Error)
+}
+
+// jpeg_decode lint [-json] file.jpg... Exits with 1 if any file has errors
+func doLint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	jsonPtr := fs.Bool("json", false, "print the reports as JSON")
+	fs.Parse(args)
+
+	status := 0
+	reports := make([]*jpeg.LintReport, 0)
+
+	for _, name := range fs.Args() {
+		rawBytes, err := ioutil.ReadFile(name)
+		if err != nil {
+			panic(err)
+		}
+
+		report := jpeg.Lint(rawBytes)
+		report.File = name
+		reports = append(reports, report)
+
+		if report.Count(jpeg.LINT_ERROR) > 0 {
+			status = 1
+		}
+	}
+
+	if *jsonPtr {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			panic(err)
+		}
+		return status
+	}
 
-				luma := float64(lumaE[lumaIndex])
-				cb := float64(cbArray[chromaIndex])
-				cr := float64(crArray[chromaIndex])
+	for _, report := range reports {
+		if err := report.WriteText(os.Stdout); err != nil {
+			panic(err)
+		}
+	}
 
-				// This was a problem since it's not in the itu 81 spec. link to JFIF spec
-				// https://www.w3.org/Graphics/JPEG/jfif3.pdf
-				r := luma + 1.402*(cr-128)
-				g := luma - 0.34414*(cb-128) - 0.71414*(cr-128)
-				b := luma + 1.772*(cb-128)
+	return status
+}
+
+// jpeg_decode info [-json] file.jpg
+func doInfo(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	jsonPtr := fs.Bool("json", false, "print the tree as JSON")
+	fs.Parse(args)
 
-				clr := color.RGBA{uint8(intClamp(int(r))), uint8(intClamp(int(g))), uint8(intClamp(int(b))), 255}
+	for _, name := range fs.Args() {
+		info := parseFile(name, &jpeg.DefaultDecodeLimits).Info()
 
-				clrImg.Set(col+intraBlockXOffset+xOffset, row+intraBlockYOffset+yOffset, clr)
+		if *jsonPtr {
+			if err := info.WriteJSON(os.Stdout); err != nil {
+				panic(err)
 			}
+			continue
+		}
+
+		fmt.Printf("%s: ", name)
+		if err := info.WriteText(os.Stdout); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// jpeg_decode trace [-json] [-mcu 0,57] [-block id:row:col,...] [-component id,...] file.jpg
+func doTrace(args []string) {
+	fs := flag.NewFlagSet("trace", flag.ExitOnError)
+	jsonPtr := fs.Bool("json", false, "print the trace as JSON")
+	mcuPtr := fs.String("mcu", "", "comma separated MCUs to trace")
+	blockPtr := fs.String("block", "", "comma separated blocks to trace, each as component id:row:col")
+	componentPtr := fs.String("component", "", "comma separated component ids to keep")
+	fs.Parse(args)
+
+	opts := &jpeg.TraceOptions{}
+
+	for _, field := range splitList(*mcuPtr) {
+		var m int
+		if _, err := fmt.Sscanf(field, "%d", &m); err != nil {
+			panic("-mcu is a list of MCU numbers")
+		}
+		opts.MCUs = append(opts.MCUs, m)
+	}
+
+	for _, field := range splitList(*blockPtr) {
+		var b jpeg.BlockPosition
+		if _, err := fmt.Sscanf(field, "%d:%d:%d", &b.Component, &b.Row, &b.Col); err != nil {
+			panic("-block is a list of component id:row:col")
+		}
+		opts.Blocks = append(opts.Blocks, b)
+	}
+
+	for _, field := range splitList(*componentPtr) {
+		var id int
+		if _, err := fmt.Sscanf(field, "%d", &id); err != nil {
+			panic("-component is a list of component ids")
+		}
+		opts.Components = append(opts.Components, id)
+	}
+
+	// Nothing picked, so start at the top left
+	if len(opts.MCUs) == 0 && len(opts.Blocks) == 0 {
+		opts.MCUs = []int{0}
+	}
+
+	for _, name := range fs.Args() {
+		traces, err := parseFile(name, &jpeg.DefaultDecodeLimits).Trace(opts)
+		if traces == nil {
+			panic(err)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		}
+
+		if *jsonPtr {
+			err = jpeg.WriteTraceJSON(os.Stdout, traces)
+		} else {
+			fmt.Printf("%s\n", name)
+			err = jpeg.WriteTraceText(os.Stdout, traces)
 		}
+
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// jpeg_decode explain [-mcu 0,57] [-o report.html] file.jpg
+func doExplain(args []string) {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	mcuPtr := fs.String("mcu", "", "comma separated MCUs that can be clicked on, all of them if empty")
+	outPtr := fs.String("o", "/tmp/explain.html", "where to write the report")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		panic("explain takes one file")
+	}
+
+	opts := &jpeg.ExplainOptions{}
+
+	for _, field := range splitList(*mcuPtr) {
+		var m int
+		if _, err := fmt.Sscanf(field, "%d", &m); err != nil {
+			panic("-mcu is a list of MCU numbers")
+		}
+		opts.MCUs = append(opts.MCUs, m)
+	}
+
+	f, err := os.Create(*outPtr)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	if err := parseFile(fs.Arg(0), &jpeg.DefaultDecodeLimits).WriteExplainHTML(f, fs.Arg(0), opts); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Wrote %s\n", *outPtr)
+}
+
+// jpeg_decode stats [-json] [-heatmap out.png] file.jpg
+func doStats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	jsonPtr := fs.Bool("json", false, "print the statistics as JSON")
+	heatmapPtr := fs.String("heatmap", "/tmp/heatmap.png", "where to write the bits per MCU heatmap, nowhere if empty")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		panic("stats takes one file")
+	}
+
+	stats, err := parseFile(fs.Arg(0), &jpeg.DefaultDecodeLimits).Stats()
+	if stats == nil {
+		panic(err)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+	}
+
+	if *jsonPtr {
+		err = stats.WriteJSON(os.Stdout)
+	} else {
+		fmt.Printf("%s\n", fs.Arg(0))
+		err = stats.WriteText(os.Stdout)
+	}
+
+	if err != nil {
+		panic(err)
 	}
 
+	if *heatmapPtr != "" {
+		writeAsPngUsingGolangEncoder(stats.Heatmap(), *heatmapPtr)
+	}
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, ",")
 }
 
 func writeAsPngUsingGolangEncoder(inImg image.Image, name string) {
@@ -185,12 +578,122 @@ func writeAsPngUsingGolangEncoder(inImg image.Image, name string) {
 }
 
 func main() {
+	// Subcommands take their own flags. Everything else goes through the flags below
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lint":
+			os.Exit(doLint(os.Args[2:]))
+		case "info":
+			doInfo(os.Args[2:])
+			return
+		case "trace":
+			doTrace(os.Args[2:])
+			return
+		case "explain":
+			doExplain(os.Args[2:])
+			return
+		case "stats":
+			doStats(os.Args[2:])
+			return
+		}
+	}
+
 	inImgPtr := flag.String("image", "spec.jpg", "desired input file")
+	thumbnailsPtr := flag.Bool("thumbnails", false, "only extract the embedded thumbnails")
+	aspectPtr := flag.Bool("aspect", false, "stretch non-square pixels using the JFIF density")
+	xmpPtr := flag.Bool("xmp", false, "only print the XMP metadata")
+	mpfPtr := flag.Bool("mpf", false, "decode every image in a Multi-Picture (MPO) file")
+	hdrPtr := flag.Float64("hdr", 0, "decode an Ultra HDR file for a display with this boost over SDR white")
+	encodePtr := flag.String("encode", "", "re-encode the decoded image to this file")
+	qualityPtr := flag.Int("quality", 75, "encoder quality, 1 to 100")
+	subsamplingPtr := flag.String("subsampling", "420", "encoder chroma subsampling: 444, 422 or 420")
+	restartPtr := flag.Int("restart", 0, "encoder restart interval in MCUs")
+	optimizePtr := flag.Bool("optimize", false, "encoder builds Huffman tables for the image instead of using Annex K")
+	progressivePtr := flag.Bool("progressive", false, "encoder writes a progressive file with the default scan script")
+	transcodePtr := flag.String("transcode", "", "losslessly rewrite the coefficients to this file")
+	transformPtr := flag.String("transform", "", "transcode transform: flip-h, flip-v, transpose, transverse, rot90, rot180 or rot270")
+	cropPtr := flag.String("crop", "", "transcode crop as x,y,width,height. The corner snaps to the MCU grid")
+	grayPtr := flag.Bool("gray", false, "transcode keeps only the luma")
+	requantizePtr := flag.Int("requantize", 0, "transcode requantizes to this quality in the DCT domain")
+	dropMetaPtr := flag.Bool("dropmeta", false, "transcode leaves out the source's APPn and COM segments instead of copying them")
+	verifyPtr := flag.Bool("verify", false, "check the transcode decodes to identical coefficients and pixels")
+	estimatePtr := flag.Bool("estimate", false, "only estimate the quality the file was saved at")
+	rewritePtr := flag.String("rewrite", "", "write a copy with edited metadata to this file, leaving the image data alone")
+	stripPtr := flag.Bool("strip", false, "rewrite drops all metadata except JFIF, Adobe and the ICC profile")
+	noGpsPtr := flag.Bool("nogps", false, "rewrite empties the EXIF GPS IFD and blanks the GPS properties in XMP")
+	noSerialPtr := flag.Bool("noserial", false, "rewrite blanks the EXIF owner, serial numbers and maker note, and the same properties in XMP")
+	tolerantPtr := flag.Bool("tolerant", false, "decode past damaged restart intervals instead of stopping")
+	fillPtr := flag.String("fill", "gray", "how tolerant decoding fills damaged intervals: gray or above")
+	noLimitsPtr := flag.Bool("nolimits", false, "decode however big the header says the image is")
+	overlayPtr := flag.Bool("overlay", false, "also write /tmp/out_overlay.png with the MCU grid, restart intervals, DC only blocks and damaged MCUs drawn on")
 
 	flag.Parse()
 	flag.Usage()
-	if *inImgPtr != "" {
-		img := doFileDecode(inImgPtr)
+	if *inImgPtr != "" && *thumbnailsPtr {
+		doThumbnailExtract(inImgPtr)
+	} else if *inImgPtr != "" && *xmpPtr {
+		doXmpExtract(inImgPtr)
+	} else if *inImgPtr != "" && *mpfPtr {
+		doMpfDecode(inImgPtr)
+	} else if *inImgPtr != "" && *hdrPtr > 0 {
+		doHdrDecode(inImgPtr, *hdrPtr)
+	} else if *inImgPtr != "" && *estimatePtr {
+		doQualityEstimate(inImgPtr)
+	} else if *inImgPtr != "" && *rewritePtr != "" {
+		doRewrite(inImgPtr, *rewritePtr, *stripPtr, *noGpsPtr, *noSerialPtr)
+	} else if *inImgPtr != "" && *transcodePtr != "" {
+		transforms := map[string]int{"": jpeg.TRANSFORM_NONE, "flip-h": jpeg.TRANSFORM_FLIP_H, "flip-v": jpeg.TRANSFORM_FLIP_V,
+			"transpose": jpeg.TRANSFORM_TRANSPOSE, "transverse": jpeg.TRANSFORM_TRANSVERSE, "rot90": jpeg.TRANSFORM_ROT_90,
+			"rot180": jpeg.TRANSFORM_ROT_180, "rot270": jpeg.TRANSFORM_ROT_270}
+		t, present := transforms[*transformPtr]
+		if !present {
+			panic("unknown transform")
+		}
+
+		crop := image.Rectangle{}
+		if *cropPtr != "" {
+			var x, y, w, h int
+			if _, err := fmt.Sscanf(*cropPtr, "%d,%d,%d,%d", &x, &y, &w, &h); err != nil {
+				panic(err)
+			}
+			crop = image.Rect(x, y, x+w, y+h)
+		}
+
+		doTranscode(inImgPtr, *transcodePtr, t, crop, *grayPtr, *requantizePtr, &jpeg.EncodeOptions{RestartInterval: *restartPtr, OptimizeHuffman: *optimizePtr, Progressive: *progressivePtr, DropMetadata: *dropMetaPtr})
+
+		if *verifyPtr {
+			if t != jpeg.TRANSFORM_NONE || !crop.Empty() || *grayPtr || *requantizePtr > 0 {
+				panic("verify only applies to a transcode without transforms")
+			}
+			doVerify(inImgPtr, *transcodePtr)
+		}
+	} else if *inImgPtr != "" && *encodePtr != "" {
+		subsampling := map[string]int{"444": jpeg.SUBSAMPLING_444, "422": jpeg.SUBSAMPLING_422, "420": jpeg.SUBSAMPLING_420}
+		s, present := subsampling[*subsamplingPtr]
+		if !present {
+			panic("unknown subsampling")
+		}
+		doFileEncode(inImgPtr, *encodePtr, &jpeg.EncodeOptions{Quality: *qualityPtr, Subsampling: s, RestartInterval: *restartPtr, OptimizeHuffman: *optimizePtr, Progressive: *progressivePtr})
+	} else if *inImgPtr != "" {
+		fills := map[string]int{"gray": jpeg.FILL_GRAY, "above": jpeg.FILL_COPY_ABOVE}
+		fill, present := fills[*fillPtr]
+		if !present {
+			panic("unknown fill")
+		}
+		limits := jpeg.DefaultDecodeLimits
+		if *noLimitsPtr {
+			limits = jpeg.DecodeLimits{}
+		}
+		opts := &jpeg.DecodeOptions{ApplyAspectRatio: *aspectPtr, Tolerant: *tolerantPtr, Fill: fill, Limits: limits}
+
+		if *overlayPtr {
+			img, overlay := doOverlayDecode(inImgPtr, opts)
+			writeAsPngUsingGolangEncoder(img, "/tmp/out.png")
+			writeAsPngUsingGolangEncoder(overlay, "/tmp/out_overlay.png")
+			return
+		}
+
+		img := doFileDecode(inImgPtr, opts)
 		writeAsPngUsingGolangEncoder(img, "/tmp/out.png") // For debugging
 	}
 